@@ -0,0 +1,197 @@
+// Package client talks to a safebin server: it uploads files, picking the
+// chunked protocol for large ones, and downloads, inspects and deletes them
+// by share link.
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/skidoodle/safebin/internal/slug"
+)
+
+const (
+	// DefaultChunkSize is the largest chunk the server accepts.
+	DefaultChunkSize = 8 << 20
+	// MinChunkSize is the smallest chunk the server accepts.
+	MinChunkSize = 1 << 20
+	// DefaultThreshold is the size above which uploads use the chunked
+	// protocol instead of a single request.
+	DefaultThreshold = 32 << 20
+	// DefaultRetries is how often a chunk is resent after a failure.
+	DefaultRetries = 3
+
+	jsonContentType    = "application/json"
+	problemContentType = "application/problem+json"
+	deleteTokenHeader  = "X-Delete-Token"
+	noteHeader         = "X-Safebin-Note"
+	chunkDigestHeader  = "X-Chunk-SHA256"
+)
+
+var (
+	ErrInvalidLink      = errors.New("invalid share link")
+	ErrInvalidChunkSize = errors.New("invalid chunk size")
+)
+
+// StatusError is a response the server refused with. Title is the problem
+// title the server sent, or the standard status text.
+type StatusError struct {
+	StatusCode int
+	Title      string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("safebin: %d %s", e.StatusCode, e.Title)
+}
+
+// Client is a safebin API client. It is safe for concurrent use.
+type Client struct {
+	base       *url.URL
+	httpClient *http.Client
+	chunkSize  int64
+	threshold  int64
+	retries    int
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sends requests through httpClient instead of
+// http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithChunkSize sets the chunk size of chunked uploads. It must lie between
+// MinChunkSize and DefaultChunkSize.
+func WithChunkSize(n int64) Option {
+	return func(c *Client) {
+		c.chunkSize = n
+	}
+}
+
+// WithThreshold sets the size above which uploads are chunked.
+func WithThreshold(n int64) Option {
+	return func(c *Client) {
+		c.threshold = n
+	}
+}
+
+// WithRetries sets how often a failed chunk is resent.
+func WithRetries(n int) Option {
+	return func(c *Client) {
+		c.retries = n
+	}
+}
+
+// New returns a client for the server at baseURL, such as
+// "https://bin.example.com".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid server URL %q", baseURL)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	c := &Client{
+		base:       base,
+		httpClient: http.DefaultClient,
+		chunkSize:  DefaultChunkSize,
+		threshold:  DefaultThreshold,
+		retries:    DefaultRetries,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.chunkSize < MinChunkSize || c.chunkSize > DefaultChunkSize {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidChunkSize, c.chunkSize)
+	}
+	return c, nil
+}
+
+// endpoint resolves an API path against the server URL.
+func (c *Client) endpoint(p string) string {
+	u := *c.base
+	u.Path += p
+	return u.String()
+}
+
+// fileURL resolves a share link to the URL of the file. A full link is used
+// as is, so links from other servers work too; a bare slug is resolved
+// against the client's server. Either way the slug must carry a valid key.
+func (c *Client) fileURL(link string) (string, error) {
+	var u url.URL
+	if strings.Contains(link, "://") {
+		parsed, err := url.Parse(link)
+		if err != nil || parsed.Host == "" {
+			return "", fmt.Errorf("%w: %q", ErrInvalidLink, link)
+		}
+		u = *parsed
+	} else {
+		u = *c.base
+		u.Path += "/" + strings.TrimPrefix(link, "/")
+	}
+
+	if _, _, err := slug.Parse(path.Base(u.Path)); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidLink, err)
+	}
+	u.RawQuery, u.Fragment = "", ""
+	return u.String(), nil
+}
+
+func (c *Client) do(request *http.Request) (*http.Response, error) {
+	request.Header.Set("Accept", jsonContentType+", "+problemContentType)
+	return c.httpClient.Do(request)
+}
+
+// decode checks that resp has status want and decodes its JSON body into v,
+// if v is not nil. The body is always closed.
+func decode(resp *http.Response, want int, v any) error {
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != want {
+		return statusError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// statusError turns a failed response into a StatusError, using the problem
+// title when the server sent one.
+func statusError(resp *http.Response) error {
+	err := &StatusError{StatusCode: resp.StatusCode, Title: http.StatusText(resp.StatusCode)}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == problemContentType {
+		var problem struct {
+			Title string `json:"title"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&problem) == nil && problem.Title != "" {
+			err.Title = problem.Title
+		}
+	}
+	return err
+}
+
+// Result describes a stored upload.
+type Result struct {
+	URL           string    `json:"url"`
+	ID            string    `json:"id"`
+	Size          int64     `json:"size"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	DeleteToken   string    `json:"delete_token,omitempty"`
+	DownloadsLeft int       `json:"downloads_left,omitempty"`
+	Dedup         bool      `json:"dedup"` // false when the server does not report it
+}
@@ -0,0 +1,246 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+
+	"github.com/skidoodle/safebin/internal/app"
+	"github.com/skidoodle/safebin/internal/store"
+)
+
+// setupServer runs a safebin server over a temporary directory. wrap, when
+// set, sits in front of its routes.
+func setupServer(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.Server {
+	storageDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(storageDir, app.TempDirName), 0700); err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := app.InitDB(storageDir)
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	web := fstest.MapFS{
+		"layout.html":  {Data: []byte(`{{define "layout"}}OK{{end}}`)},
+		"decrypt.html": {Data: []byte(`{{define "decrypt"}}DECRYPT{{end}}`)},
+	}
+	server := &app.App{
+		Conf:   app.Config{StorageDir: storageDir, MaxMB: 10},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Tmpl:   app.ParseTemplates(web),
+		Assets: web,
+		DB:     db,
+		Store:  store.NewFS(storageDir),
+	}
+
+	var handler http.Handler = server.Routes()
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newClient(t *testing.T, baseURL string, opts ...Option) *Client {
+	c, err := New(baseURL, opts...)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return c
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readAll(t *testing.T, file *File) []byte {
+	defer func() { _ = file.Close() }()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("Read download failed: %v", err)
+	}
+	return data
+}
+
+func TestUploadAndDownload(t *testing.T) {
+	ts := setupServer(t, nil)
+	c := newClient(t, ts.URL)
+	ctx := context.Background()
+
+	content := []byte("uploaded with the Go client")
+	result, err := c.Upload(ctx, bytes.NewReader(content), "report.txt", int64(len(content)), UploadOptions{Note: "für dich"})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if !strings.HasSuffix(result.URL, ".txt") || result.Size != int64(len(content)) || result.DeleteToken == "" {
+		t.Fatalf("Result: %+v", result)
+	}
+
+	file, err := c.Download(ctx, result.URL)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if got := readAll(t, file); !bytes.Equal(got, content) {
+		t.Errorf("Download = %q", got)
+	}
+	if file.Name != "report.txt" || file.Note != "für dich" {
+		t.Errorf("Download info: %+v", file.Info)
+	}
+
+	slug := result.URL[strings.LastIndex(result.URL, "/")+1:]
+	file, err = c.DownloadRange(ctx, slug, 9, 4)
+	if err != nil {
+		t.Fatalf("DownloadRange failed: %v", err)
+	}
+	if got := readAll(t, file); string(got) != "with" || file.Offset != 9 || file.Size != int64(len(content)) {
+		t.Errorf("Range: %q at %d of %d", got, file.Offset, file.Size)
+	}
+
+	info, err := c.Info(ctx, result.URL)
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	if info.Size != int64(len(content)) || info.ETag == "" || info.ModTime.IsZero() {
+		t.Errorf("Info: %+v", info)
+	}
+}
+
+func TestUploadSwitchesToChunks(t *testing.T) {
+	var chunks atomic.Int32
+	ts := setupServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/upload/chunk" {
+				chunks.Add(1)
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := newClient(t, ts.URL, WithChunkSize(MinChunkSize), WithThreshold(2*MinChunkSize))
+	ctx := context.Background()
+
+	small := randomBytes(t, MinChunkSize)
+	if _, err := c.Upload(ctx, bytes.NewReader(small), "small.bin", int64(len(small)), UploadOptions{}); err != nil {
+		t.Fatalf("Small upload failed: %v", err)
+	}
+	if n := chunks.Load(); n != 0 {
+		t.Errorf("Small upload sent %d chunks", n)
+	}
+
+	large := randomBytes(t, 5*MinChunkSize/2)
+	result, err := c.Upload(ctx, bytes.NewReader(large), "large.bin", int64(len(large)), UploadOptions{MaxDownloads: 2})
+	if err != nil {
+		t.Fatalf("Large upload failed: %v", err)
+	}
+	if n := chunks.Load(); n != 3 {
+		t.Errorf("Large upload sent %d chunks, want 3", n)
+	}
+	if result.DownloadsLeft != 2 {
+		t.Errorf("DownloadsLeft = %d", result.DownloadsLeft)
+	}
+
+	file, err := c.Download(ctx, result.URL)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if got := readAll(t, file); !bytes.Equal(got, large) {
+		t.Error("Chunked upload did not round trip")
+	}
+}
+
+func TestChunkRetry(t *testing.T) {
+	var failed atomic.Bool
+	ts := setupServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/upload/chunk" && failed.CompareAndSwap(false, true) {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := newClient(t, ts.URL, WithChunkSize(MinChunkSize), WithThreshold(0))
+
+	content := randomBytes(t, MinChunkSize+100)
+	result, err := c.Upload(context.Background(), bytes.NewReader(content), "retry.bin", int64(len(content)), UploadOptions{})
+	if err != nil || !failed.Load() {
+		t.Fatalf("Upload after a failed chunk: %v (failed=%v)", err, failed.Load())
+	}
+	if result.Size != int64(len(content)) {
+		t.Errorf("Size = %d", result.Size)
+	}
+
+	c = newClient(t, ts.URL, WithChunkSize(MinChunkSize), WithThreshold(0), WithRetries(0))
+	failed.Store(false)
+	_, err = c.Upload(context.Background(), bytes.NewReader(content), "retry.bin", int64(len(content)), UploadOptions{})
+	var status *StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Upload without retries: %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ts := setupServer(t, nil)
+	c := newClient(t, ts.URL)
+	ctx := context.Background()
+
+	result, err := c.Upload(ctx, strings.NewReader("short lived"), "gone.txt", -1, UploadOptions{})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	var status *StatusError
+	if err := c.Delete(ctx, result.URL, "wrong"); !errors.As(err, &status) || status.StatusCode != http.StatusForbidden {
+		t.Fatalf("Delete with wrong token: %v", err)
+	}
+	if err := c.Delete(ctx, result.URL, result.DeleteToken); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := c.Info(ctx, result.URL); !errors.As(err, &status) || status.StatusCode != http.StatusNotFound {
+		t.Errorf("Info after delete: %v", err)
+	}
+}
+
+func TestRejectsInvalidLinks(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer ts.Close()
+	c := newClient(t, ts.URL)
+
+	for _, link := range []string{"short", ts.URL + "/" + strings.Repeat("!", 22), "https:///nohost"} {
+		if _, err := c.Download(context.Background(), link); !errors.Is(err, ErrInvalidLink) {
+			t.Errorf("Download(%q) error = %v", link, err)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("%d requests sent for invalid links", n)
+	}
+
+	if _, err := New("ftp://example.com"); err == nil {
+		t.Error("New accepted a non-HTTP URL")
+	}
+	if _, err := New(ts.URL, WithChunkSize(64)); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("New with tiny chunks: %v", err)
+	}
+}
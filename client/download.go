@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Info describes a stored file as the server presents it.
+type Info struct {
+	Name        string
+	ContentType string
+	// Size is the length of the whole file, or -1 when the server sent it
+	// compressed and did not say.
+	Size    int64
+	ModTime time.Time
+	ETag    string
+	Note    string
+}
+
+// File is the body of a download along with what the server said about the
+// file. It must be closed.
+type File struct {
+	Info
+	// Offset is the position of the first byte of the body in the file.
+	Offset int64
+
+	body io.ReadCloser
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	return f.body.Read(p)
+}
+
+func (f *File) Close() error {
+	return f.body.Close()
+}
+
+// Info fetches the metadata of the file behind link without downloading it
+// or spending any of its download budget.
+func (c *Client) Info(ctx context.Context, link string) (*Info, error) {
+	resp, err := c.fetch(ctx, http.MethodHead, link, "")
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	info := parseInfo(resp)
+	return &info, nil
+}
+
+// Download streams the file behind link.
+func (c *Client) Download(ctx context.Context, link string) (*File, error) {
+	return c.download(ctx, link, "")
+}
+
+// DownloadRange streams length bytes of the file behind link starting at
+// offset, or everything from offset on when length is not positive.
+func (c *Client) DownloadRange(ctx context.Context, link string, offset, length int64) (*File, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("negative offset %d", offset)
+	}
+
+	spec := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		spec += strconv.FormatInt(offset+length-1, 10)
+	}
+	return c.download(ctx, link, spec)
+}
+
+func (c *Client) download(ctx context.Context, link, rangeSpec string) (*File, error) {
+	resp, err := c.fetch(ctx, http.MethodGet, link, rangeSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{Info: parseInfo(resp), body: resp.Body}
+	switch {
+	case resp.StatusCode == http.StatusOK && (rangeSpec == "" || rangeSpec == "bytes=0-"):
+	case resp.StatusCode == http.StatusPartialContent && rangeSpec != "":
+		file.Offset, file.Size, err = parseContentRange(resp.Header.Get("Content-Range"))
+	default:
+		err = statusError(resp)
+	}
+
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Delete revokes the upload behind link with the deletion token it was
+// issued. The file itself goes once no other uploader holds it.
+func (c *Client) Delete(ctx context.Context, link, token string) error {
+	target, err := c.fileURL(link)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodDelete, target, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set(deleteTokenHeader, token)
+
+	resp, err := c.do(request)
+	if err != nil {
+		return err
+	}
+	return decode(resp, http.StatusNoContent, nil)
+}
+
+func (c *Client) fetch(ctx context.Context, method, link, rangeSpec string) (*http.Response, error) {
+	target, err := c.fileURL(link)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rangeSpec != "" {
+		request.Header.Set("Range", rangeSpec)
+	}
+	return c.do(request)
+}
+
+func parseInfo(resp *http.Response) Info {
+	info := Info{
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+		ETag:        resp.Header.Get("ETag"),
+	}
+	if resp.Uncompressed {
+		info.Size = -1
+	}
+
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		info.Name = params["filename"]
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	if note := resp.Header.Get(noteHeader); note != "" {
+		var decoder mime.WordDecoder
+		if decoded, err := decoder.DecodeHeader(note); err == nil {
+			info.Note = decoded
+		}
+	}
+	return info
+}
+
+// parseContentRange reads the start and complete length from a
+// "bytes first-last/complete" header.
+func parseContentRange(header string) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	span, total, found := strings.Cut(spec, "/")
+	first, _, hasDash := strings.Cut(span, "-")
+	if !ok || !found || !hasDash {
+		return 0, 0, fmt.Errorf("bad Content-Range %q", header)
+	}
+
+	offset, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad Content-Range %q", header)
+	}
+
+	size := int64(-1)
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("bad Content-Range %q", header)
+		}
+	}
+	return offset, size, nil
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusChecksumMismatch is the status the server answers a corrupted chunk
+// with.
+const StatusChecksumMismatch = 460
+
+// UploadOptions are the per-upload settings. Zero values leave the server
+// defaults in place.
+type UploadOptions struct {
+	Expires      time.Duration
+	MaxDownloads int
+	Private      bool
+	// E2E marks content the caller already encrypted.
+	E2E  bool
+	Note string
+}
+
+func (o UploadOptions) values() url.Values {
+	values := url.Values{}
+	if o.Expires > 0 {
+		values.Set("expires", o.Expires.String())
+	}
+	if o.MaxDownloads > 0 {
+		values.Set("max_downloads", strconv.Itoa(o.MaxDownloads))
+	}
+	if o.Private {
+		values.Set("private", "true")
+	}
+	if o.E2E {
+		values.Set("e2e", "true")
+	}
+	if o.Note != "" {
+		values.Set("note", o.Note)
+	}
+	return values
+}
+
+// UploadFile uploads the named file under its base name.
+func (c *Client) UploadFile(ctx context.Context, name string, opts UploadOptions) (*Result, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return c.Upload(ctx, file, filepath.Base(name), info.Size(), opts)
+}
+
+// Upload stores size bytes read from r under filename. Uploads above the
+// client's threshold go through a resumable session, so a failed chunk is
+// resent instead of the whole file. A negative size means the length is not
+// known up front and always uses a single streamed request.
+func (c *Client) Upload(ctx context.Context, r io.Reader, filename string, size int64, opts UploadOptions) (*Result, error) {
+	if size > c.threshold {
+		return c.uploadChunked(ctx, r, filename, size, opts)
+	}
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	return c.uploadSingle(ctx, r, filename, opts)
+}
+
+// uploadSingle streams the multipart form of a direct upload. The option
+// fields go first, as the server reads them before the file part.
+func (c *Client) uploadSingle(ctx context.Context, r io.Reader, filename string, opts UploadOptions) (*Result, error) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeUploadForm(form, r, filename, opts))
+	}()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("/"), pr)
+	if err != nil {
+		_ = pr.Close()
+		return nil, err
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := c.do(request)
+	_ = pr.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var result Result
+	if err := decode(resp, http.StatusOK, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func writeUploadForm(form *multipart.Writer, r io.Reader, filename string, opts UploadOptions) error {
+	for name, values := range opts.values() {
+		if err := form.WriteField(name, values[0]); err != nil {
+			return err
+		}
+	}
+
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	return form.Close()
+}
+
+// uploadChunked runs the session protocol: start, one request per chunk and
+// finish.
+func (c *Client) uploadChunked(ctx context.Context, r io.Reader, filename string, size int64, opts UploadOptions) (*Result, error) {
+	fields := opts.values()
+	fields.Set("filename", filename)
+	fields.Set("size", strconv.FormatInt(size, 10))
+	fields.Set("chunk_size", strconv.FormatInt(c.chunkSize, 10))
+
+	var session struct {
+		ID string `json:"id"`
+	}
+	if err := c.postForm(ctx, "/upload/start", fields, http.StatusCreated, &session); err != nil {
+		return nil, fmt.Errorf("start upload: %w", err)
+	}
+
+	buf := make([]byte, c.chunkSize)
+	for idx, offset := 0, int64(0); offset < size; idx++ {
+		chunk := buf[:min(c.chunkSize, size-offset)]
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, err
+		}
+		if err := c.sendChunk(ctx, session.ID, idx, chunk); err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", idx, err)
+		}
+		offset += int64(len(chunk))
+	}
+
+	var result Result
+	if err := c.postForm(ctx, "/upload/finish", url.Values{"upload_id": {session.ID}}, http.StatusOK, &result); err != nil {
+		return nil, fmt.Errorf("finish upload: %w", err)
+	}
+	return &result, nil
+}
+
+// sendChunk posts one chunk with its checksum, retrying transport failures,
+// server errors and checksum mismatches.
+func (c *Client) sendChunk(ctx context.Context, uploadID string, idx int, chunk []byte) error {
+	sum := sha256.Sum256(chunk)
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	if err := form.WriteField("upload_id", uploadID); err != nil {
+		return err
+	}
+	if err := form.WriteField("index", strconv.Itoa(idx)); err != nil {
+		return err
+	}
+	part, err := form.CreateFormFile("chunk", "blob")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(chunk); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("/upload/chunk"), bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", form.FormDataContentType())
+		request.Header.Set(chunkDigestHeader, hex.EncodeToString(sum[:]))
+
+		resp, err := c.do(request)
+		if err == nil {
+			err = decode(resp, http.StatusOK, nil)
+		}
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * 500 * time.Millisecond):
+		}
+	}
+}
+
+func retryable(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode >= http.StatusInternalServerError || status.StatusCode == StatusChecksumMismatch
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+func (c *Client) postForm(ctx context.Context, p string, fields url.Values, want int, v any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint(p), strings.NewReader(fields.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.do(request)
+	if err != nil {
+		return err
+	}
+	return decode(resp, want, v)
+}
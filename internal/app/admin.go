@@ -0,0 +1,360 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// FileSummary is a stored file as the admin commands report it.
+type FileSummary struct {
+	ID            string    `json:"id"`
+	Size          int64     `json:"size"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	DownloadsLeft int       `json:"downloads_left,omitempty"`
+	E2E           bool      `json:"e2e,omitempty"`
+	Leases        int       `json:"leases"`
+}
+
+// Stats summarises the database.
+type Stats struct {
+	Files    int   `json:"files"`
+	Bytes    int64 `json:"bytes"`
+	Leases   int   `json:"leases"`
+	Sessions int   `json:"sessions"`
+	Probes   int   `json:"probes"`
+	// Overdue counts leases past their expiry that cleanup has not reached.
+	Overdue    int        `json:"overdue"`
+	NextExpiry *time.Time `json:"next_expiry,omitempty"`
+}
+
+// Reconciliation lists disagreements between the blob store and the files,
+// leases, expiry_index and links buckets.
+type Reconciliation struct {
+	// OrphanBlobs are blobs without a file record.
+	OrphanBlobs []string `json:"orphan_blobs"`
+	// MissingBlobs are file records whose blob is gone.
+	MissingBlobs []string `json:"missing_blobs"`
+	// SizeMismatches are file records whose blob has another size.
+	SizeMismatches []string `json:"size_mismatches"`
+	// Unleased are file records without a lease, which would never expire.
+	Unleased []string `json:"unleased"`
+	// OrphanLeases are leases whose file record is gone.
+	OrphanLeases []string `json:"orphan_leases"`
+	// Unindexed are leases missing from the expiry index.
+	Unindexed []string `json:"unindexed"`
+	// Dangling are expiry index entries that match no lease.
+	Dangling []string `json:"dangling"`
+	// DanglingLinks are share links that match no lease.
+	DanglingLinks []string `json:"dangling_links"`
+}
+
+// Clean reports whether nothing disagreed.
+func (r Reconciliation) Clean() bool {
+	return len(r.OrphanBlobs)+len(r.MissingBlobs)+len(r.SizeMismatches)+
+		len(r.Unleased)+len(r.OrphanLeases)+len(r.Unindexed)+len(r.Dangling)+len(r.DanglingLinks) == 0
+}
+
+// ListFiles returns every file record ordered by expiry.
+func (app *App) ListFiles() ([]FileSummary, error) {
+	var files []FileSummary
+
+	err := app.DB.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(DBBucketName)).ForEach(func(k, v []byte) error {
+			var meta FileMeta
+			if err := json.Unmarshal(v, &meta); err != nil {
+				return fmt.Errorf("decode metadata %s: %w", k, err)
+			}
+
+			leases, err := app.loadLeases(tx, meta.ID)
+			if err != nil {
+				return err
+			}
+
+			files = append(files, FileSummary{
+				ID:            meta.ID,
+				Size:          meta.Size,
+				CreatedAt:     meta.CreatedAt,
+				ExpiresAt:     meta.ExpiresAt,
+				DownloadsLeft: meta.DownloadsLeft,
+				E2E:           meta.E2E,
+				Leases:        len(leases),
+			})
+			return nil
+		})
+	})
+
+	slices.SortFunc(files, func(a, b FileSummary) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	return files, err
+}
+
+// Stats counts what the database holds.
+func (app *App) Stats() (Stats, error) {
+	var stats Stats
+	now := time.Now()
+
+	err := app.DB.View(func(tx *bbolt.Tx) error {
+		err := tx.Bucket([]byte(DBBucketName)).ForEach(func(k, v []byte) error {
+			var meta FileMeta
+			if err := json.Unmarshal(v, &meta); err != nil {
+				return fmt.Errorf("decode metadata %s: %w", k, err)
+			}
+			stats.Files++
+			stats.Bytes += meta.Size
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket([]byte(DBBucketLeaseName)).ForEach(func(k, v []byte) error {
+			var lease Lease
+			if err := json.Unmarshal(v, &lease); err != nil {
+				return fmt.Errorf("decode lease %s: %w", k, err)
+			}
+			stats.Leases++
+			if lease.ExpiresAt.Before(now) {
+				stats.Overdue++
+			} else if stats.NextExpiry == nil || lease.ExpiresAt.Before(*stats.NextExpiry) {
+				stats.NextExpiry = &lease.ExpiresAt
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		stats.Sessions = tx.Bucket([]byte(DBBucketUploadName)).Stats().KeyN
+		stats.Probes = tx.Bucket([]byte(DBBucketProbeName)).Stats().KeyN
+		return nil
+	})
+
+	return stats, err
+}
+
+// ExpireFile removes a file and all of its leases at once, as if every
+// lease had run out.
+func (app *App) ExpireFile(id string) error {
+	var blob string
+	err := app.DB.Update(func(tx *bbolt.Tx) error {
+		meta, err := app.loadMeta(tx, id)
+		if err != nil {
+			return err
+		}
+		blob, err = app.removeFile(tx, meta)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	app.deleteBlobs(blob)
+	return nil
+}
+
+// Cleanup runs every periodic cleanup once.
+func (app *App) Cleanup() {
+	app.CleanStorage()
+	app.CleanSessions()
+	app.CleanProbes()
+	app.CleanChunks()
+	app.CleanTemp(filepath.Join(app.Conf.StorageDir, TempDirName))
+}
+
+// Reconcile compares the blob store with the database. With fix set it also
+// repairs what it can: orphan blobs and records without a blob are removed,
+// unleased files get a lease until their recorded expiry, the expiry index is
+// rebuilt and dangling links are dropped. Size mismatches are only reported.
+func (app *App) Reconcile(fix bool) (Reconciliation, error) {
+	var report Reconciliation
+
+	blobs, err := app.Store.List("")
+	if err != nil {
+		return report, fmt.Errorf("list blobs: %w", err)
+	}
+	sizes := make(map[string]int64)
+	for _, blob := range blobs {
+		if !strings.Contains(blob.Name, "/") {
+			sizes[blob.Name] = blob.Size
+		}
+	}
+
+	update := app.DB.View
+	if fix {
+		update = app.DB.Update
+	}
+
+	err = update(func(tx *bbolt.Tx) error {
+		files := tx.Bucket([]byte(DBBucketName))
+		leases := tx.Bucket([]byte(DBBucketLeaseName))
+		index := tx.Bucket([]byte(DBBucketIndexName))
+		links := tx.Bucket([]byte(DBBucketLinkName))
+
+		indexed := make(map[string]bool)
+		err := index.ForEach(func(k, v []byte) error {
+			var lease Lease
+			if data := leases.Get(v); data == nil || json.Unmarshal(data, &lease) != nil ||
+				!bytes.Equal(k, expiryIndexKey(lease.ExpiresAt, string(v))) {
+				report.Dangling = append(report.Dangling, string(k))
+				return nil
+			}
+			indexed[string(v)] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = leases.ForEach(func(k, v []byte) error {
+			var lease Lease
+			if err := json.Unmarshal(v, &lease); err != nil {
+				return fmt.Errorf("decode lease %s: %w", k, err)
+			}
+			if files.Get([]byte(lease.ID)) == nil {
+				report.OrphanLeases = append(report.OrphanLeases, string(k))
+			} else if !indexed[string(k)] {
+				report.Unindexed = append(report.Unindexed, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = links.ForEach(func(k, v []byte) error {
+			var lease Lease
+			if data := leases.Get(v); data == nil || json.Unmarshal(data, &lease) != nil || lease.LinkID != string(k) {
+				report.DanglingLinks = append(report.DanglingLinks, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		var metas []FileMeta
+		err = files.ForEach(func(k, v []byte) error {
+			var meta FileMeta
+			if err := json.Unmarshal(v, &meta); err != nil {
+				return fmt.Errorf("decode metadata %s: %w", k, err)
+			}
+			metas = append(metas, meta)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, meta := range metas {
+			size, stored := sizes[meta.ID]
+			delete(sizes, meta.ID)
+
+			switch {
+			case !stored:
+				report.MissingBlobs = append(report.MissingBlobs, meta.ID)
+			case size != meta.Size:
+				report.SizeMismatches = append(report.SizeMismatches, meta.ID)
+			}
+
+			prefix := leasePrefix(meta.ID)
+			if k, _ := leases.Cursor().Seek(prefix); k == nil || !bytes.HasPrefix(k, prefix) {
+				report.Unleased = append(report.Unleased, meta.ID)
+			}
+		}
+
+		for name := range sizes {
+			report.OrphanBlobs = append(report.OrphanBlobs, name)
+		}
+		slices.Sort(report.OrphanBlobs)
+
+		if !fix {
+			return nil
+		}
+		return app.repair(tx, report)
+	})
+	if err != nil || !fix {
+		return report, err
+	}
+
+	// Like every blob deletion, orphans are removed only after the
+	// transaction has committed.
+	for _, name := range report.OrphanBlobs {
+		if err := app.Store.Delete(name); err != nil {
+			return report, fmt.Errorf("remove orphan blob %s: %w", name, err)
+		}
+	}
+
+	return report, nil
+}
+
+func (app *App) repair(tx *bbolt.Tx, report Reconciliation) error {
+	index := tx.Bucket([]byte(DBBucketIndexName))
+	links := tx.Bucket([]byte(DBBucketLinkName))
+
+	for _, key := range report.Dangling {
+		if err := index.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range report.DanglingLinks {
+		if err := links.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range report.OrphanLeases {
+		lease, err := app.loadLease(tx, []byte(key))
+		if err != nil {
+			return err
+		}
+		if err := deleteLease(tx, lease); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range report.Unindexed {
+		lease, err := app.loadLease(tx, []byte(key))
+		if err != nil {
+			return err
+		}
+		if err := putLease(tx, lease); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range report.Unleased {
+		meta, err := app.loadMeta(tx, id)
+		if err != nil {
+			return err
+		}
+		leaseID, err := newLeaseID()
+		if err != nil {
+			return err
+		}
+		lease := Lease{ID: id, LeaseID: leaseID, CreatedAt: meta.CreatedAt, ExpiresAt: meta.ExpiresAt}
+		if err := putLease(tx, lease); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range report.MissingBlobs {
+		meta, err := app.loadMeta(tx, id)
+		if err != nil {
+			return err
+		}
+		if _, err := app.removeFile(tx, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
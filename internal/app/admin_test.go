@@ -0,0 +1,153 @@
+package app
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// storeFiles uploads each content and returns the file IDs.
+func storeFiles(t *testing.T, app *App, contents ...string) []string {
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	var ids []string
+	for _, content := range contents {
+		id, _ := resolveLink(t, app, slugFromResponse(t, uploadFile(t, server.URL, "f.txt", []byte(content), nil)))
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestAdmin_ListStatsExpire(t *testing.T) {
+	app, _ := setupTestApp(t)
+	ids := storeFiles(t, app, "first file", "second, longer file")
+
+	files, err := app.ListFiles()
+	if err != nil || len(files) != 2 {
+		t.Fatalf("ListFiles: %d files, %v", len(files), err)
+	}
+	for _, file := range files {
+		if !slices.Contains(ids, file.ID) || file.Leases != 1 || time.Until(file.ExpiresAt) <= 0 {
+			t.Errorf("File: %+v", file)
+		}
+	}
+
+	stats, err := app.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Files != 2 || stats.Leases != 2 || stats.Bytes != files[0].Size+files[1].Size || stats.NextExpiry == nil || stats.Overdue != 0 {
+		t.Errorf("Stats: %+v", stats)
+	}
+
+	if err := app.ExpireFile(ids[0]); err != nil {
+		t.Fatalf("ExpireFile failed: %v", err)
+	}
+	if _, err := app.Store.Stat(ids[0]); err == nil {
+		t.Error("Expired blob still stored")
+	}
+	if err := app.ExpireFile(ids[0]); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expiring twice: %v", err)
+	}
+	if stats, _ := app.Stats(); stats.Files != 1 || stats.Leases != 1 {
+		t.Errorf("Stats after expire: %+v", stats)
+	}
+}
+
+func TestAdmin_Reconcile(t *testing.T) {
+	app, storageDir := setupTestApp(t)
+	ids := storeFiles(t, app, "missing blob", "unleased", "unindexed", "healthy")
+	missing, unleased, unindexed := ids[0], ids[1], ids[2]
+
+	if err := os.WriteFile(filepath.Join(storageDir, "orphan"), []byte("no record"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Store.Delete(missing); err != nil {
+		t.Fatal(err)
+	}
+
+	var danglingLink string
+	err := app.DB.Update(func(tx *bbolt.Tx) error {
+		for _, id := range []string{unleased, unindexed} {
+			leases, err := app.loadLeases(tx, id)
+			if err != nil {
+				return err
+			}
+			key := leases[0].key()
+			if err := tx.Bucket([]byte(DBBucketIndexName)).Delete(expiryIndexKey(leases[0].ExpiresAt, string(key))); err != nil {
+				return err
+			}
+			if id == unleased {
+				if err := tx.Bucket([]byte(DBBucketLeaseName)).Delete(key); err != nil {
+					return err
+				}
+				danglingLink = leases[0].LinkID
+			}
+		}
+		if err := putLease(tx, Lease{ID: "gone", LeaseID: "00", ExpiresAt: time.Now()}); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(DBBucketIndexName)).Put([]byte("2000-01-01T00:00:00Z_x/y"), []byte("x/y"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := app.Reconcile(false)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	want := Reconciliation{
+		OrphanBlobs:   []string{"orphan"},
+		MissingBlobs:  []string{missing},
+		Unleased:      []string{unleased},
+		OrphanLeases:  []string{"gone/00"},
+		Unindexed:     []string{leaseKeyOf(t, app, unindexed)},
+		Dangling:      []string{"2000-01-01T00:00:00Z_x/y"},
+		DanglingLinks: []string{danglingLink},
+	}
+	if !slices.Equal(report.OrphanBlobs, want.OrphanBlobs) || !slices.Equal(report.MissingBlobs, want.MissingBlobs) ||
+		!slices.Equal(report.Unleased, want.Unleased) || !slices.Equal(report.OrphanLeases, want.OrphanLeases) ||
+		!slices.Equal(report.Unindexed, want.Unindexed) || !slices.Equal(report.Dangling, want.Dangling) ||
+		!slices.Equal(report.DanglingLinks, want.DanglingLinks) ||
+		len(report.SizeMismatches) != 0 {
+		t.Fatalf("Report:\n got %+v\nwant %+v", report, want)
+	}
+
+	if _, err := app.Reconcile(true); err != nil {
+		t.Fatalf("Reconcile fix failed: %v", err)
+	}
+	if report, err := app.Reconcile(false); err != nil || !report.Clean() {
+		t.Fatalf("After fix: %+v, %v", report, err)
+	}
+
+	files, _ := app.ListFiles()
+	if len(files) != 3 {
+		t.Errorf("Files after fix: %+v", files)
+	}
+	if _, err := os.Stat(filepath.Join(storageDir, "orphan")); !os.IsNotExist(err) {
+		t.Errorf("Orphan blob kept: %v", err)
+	}
+}
+
+func leaseKeyOf(t *testing.T, app *App, id string) string {
+	var key string
+	err := app.DB.View(func(tx *bbolt.Tx) error {
+		leases, err := app.loadLeases(tx, id)
+		if err == nil && len(leases) == 1 {
+			key = string(leases[0].key())
+		}
+		return err
+	})
+	if err != nil || key == "" {
+		t.Fatalf("No lease for %s: %v", id, err)
+	}
+	return key
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	_ "embed"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	JSONContentType    = "application/json"
+	ProblemContentType = "application/problem+json"
+)
+
+// OpenAPISpec describes every route in Routes. The tests hold the handlers
+// to it, so change both together.
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// UploadResponse is the JSON form of a finished upload. Dedup is left out when
+// a convergence secret is set.
+type UploadResponse struct {
+	URL           string    `json:"url"`
+	ID            string    `json:"id"`
+	Size          int64     `json:"size"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	DeleteToken   string    `json:"delete_token,omitempty"`
+	DownloadsLeft int       `json:"downloads_left,omitempty"`
+	Dedup         *bool     `json:"dedup,omitempty"`
+}
+
+// Problem is an RFC 7807 error body.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+}
+
+// wantsJSON reports whether the Accept header asks for JSON or problem+json
+// by name. Wildcards do not count, so curl and browsers keep the text and
+// HTML forms.
+func wantsJSON(request *http.Request) bool {
+	for item := range strings.SplitSeq(request.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil || (mediaType != JSONContentType && mediaType != ProblemContentType) {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (app *App) writeUploadJSON(writer http.ResponseWriter, request *http.Request, result UploadResult) {
+	response := UploadResponse{
+		URL:           requestScheme(request) + "://" + shareLink(request, result),
+		ID:            result.ID,
+		Size:          result.Size,
+		ExpiresAt:     result.ExpiresAt.UTC(),
+		DeleteToken:   result.DeleteToken,
+		DownloadsLeft: result.DownloadsLeft,
+	}
+
+	// The flag tells the uploader whether someone else stored the content,
+	// which a convergence secret is there to keep to the instance.
+	if len(app.Conf.Secret) == 0 {
+		response.Dedup = &result.Dedup
+	}
+
+	app.writeJSON(writer, JSONContentType, http.StatusOK, response)
+}
+
+func (app *App) writeProblem(writer http.ResponseWriter, code int) {
+	app.writeJSON(writer, ProblemContentType, code, Problem{
+		Type:   "about:blank",
+		Title:  statusText(code),
+		Status: code,
+	})
+}
+
+func (app *App) writeJSON(writer http.ResponseWriter, contentType string, code int, body any) {
+	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.WriteHeader(code)
+
+	if err := json.NewEncoder(writer).Encode(body); err != nil {
+		app.Logger.Error("Failed to write JSON response", "err", err)
+	}
+}
+
+// HandleOpenAPI serves the embedded OpenAPI document.
+func (app *App) HandleOpenAPI(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", JSONContentType)
+	writer.Header().Set("Cache-Control", "public, max-age=3600")
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if _, err := writer.Write(OpenAPISpec); err != nil {
+		app.Logger.Error("Failed to write OpenAPI document", "err", err)
+	}
+}
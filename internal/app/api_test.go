@@ -0,0 +1,106 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeJSON[T any](t *testing.T, resp *http.Response) T {
+	defer func() { _ = resp.Body.Close() }()
+
+	var body T
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Decode %s response failed: %v", resp.Header.Get("Content-Type"), err)
+	}
+	return body
+}
+
+func TestIntegration_JSONUploadResponse(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("structured response body")
+	accept := map[string]string{"Accept": "application/json"}
+
+	resp := uploadFile(t, server.URL, "notes.txt", content, accept)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != JSONContentType {
+		t.Fatalf("Upload: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	first := decodeJSON[UploadResponse](t, resp)
+
+	slug := first.URL[strings.LastIndex(first.URL, "/")+1:]
+	if !strings.HasPrefix(first.URL, "http://") {
+		t.Fatalf("Bad url %q", first.URL)
+	}
+	if id, _ := resolveLink(t, app, slug); first.ID != id || first.Size != int64(len(content)) || first.Dedup == nil || *first.Dedup || first.DeleteToken == "" {
+		t.Errorf("First upload: %+v", first)
+	}
+	if time.Until(first.ExpiresAt) < MinRetention-time.Minute {
+		t.Errorf("ExpiresAt %v too early", first.ExpiresAt)
+	}
+
+	second := decodeJSON[UploadResponse](t, uploadFile(t, server.URL, "notes.txt", content, accept))
+	if second.Dedup == nil || !*second.Dedup || second.ID != first.ID || second.URL == first.URL || second.DeleteToken == first.DeleteToken {
+		t.Errorf("Second upload: %+v", second)
+	}
+
+	text := uploadFile(t, server.URL, "notes.txt", content, map[string]string{"Accept": "*/*"})
+	if id, _ := resolveLink(t, app, slugFromResponse(t, text)); id != first.ID {
+		t.Errorf("Wildcard Accept: want text link to %q, got one to %q", first.ID, id)
+	}
+
+	app.Conf.Secret = []byte("api-test-secret-value")
+	keyed := decodeJSON[map[string]any](t, uploadFile(t, server.URL, "notes.txt", content, accept))
+	if _, ok := keyed["dedup"]; ok {
+		t.Errorf("Dedup reported despite a convergence secret: %v", keyed)
+	}
+}
+
+func TestIntegration_ProblemJSON(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	resp, _ := getWithHeaders(t, http.MethodGet, server.URL+"/short", nil)
+	if resp.Header.Get("Content-Type") == ProblemContentType {
+		t.Errorf("Problem JSON sent without being asked for")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/short", nil)
+	req.Header.Set("Accept", "application/problem+json, application/json;q=0.9")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.Header.Get("Content-Type") != ProblemContentType {
+		t.Fatalf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+
+	problem := decodeJSON[Problem](t, resp)
+	if problem.Status != resp.StatusCode || problem.Status != http.StatusBadRequest || problem.Title != "Bad Request" || problem.Type != "about:blank" {
+		t.Errorf("Problem: %+v (HTTP %d)", problem, resp.StatusCode)
+	}
+}
+
+func TestWantsJSON(t *testing.T) {
+	cases := map[string]bool{
+		"":                                false,
+		"*/*":                             false,
+		"application/json":                true,
+		"text/html, application/json;q=0": false,
+		"application/problem+json":        true,
+		"text/plain, application/json":    true,
+	}
+	for accept, want := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+		if got := wantsJSON(req); got != want {
+			t.Errorf("wantsJSON(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
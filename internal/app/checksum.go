@@ -0,0 +1,70 @@
+package app
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrChunkChecksum = errors.New("chunk checksum mismatch")
+	ErrInvalidDigest = errors.New("invalid chunk digest")
+)
+
+// digestReader hashes everything read through it and, at EOF, reports
+// ErrChunkChecksum instead of io.EOF when the digest differs from the one the
+// client declared. Staging writers therefore fail before they commit.
+type digestReader struct {
+	src  io.Reader
+	hash hash.Hash
+	want []byte
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.src.Read(p)
+	d.hash.Write(p[:n])
+
+	if err == io.EOF && subtle.ConstantTimeCompare(d.hash.Sum(nil), d.want) != 1 {
+		return n, ErrChunkChecksum
+	}
+	return n, err
+}
+
+// chunkDigest returns the SHA-256 a client declared for a chunk's bytes, given
+// as hex in X-Chunk-SHA256 or as an RFC 9530 Content-Digest sha-256 member.
+// It returns nil when neither header is present.
+func chunkDigest(header http.Header) ([]byte, error) {
+	if raw := strings.TrimSpace(header.Get(ChunkDigestHeader)); raw != "" {
+		sum, err := hex.DecodeString(raw)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidDigest, raw)
+		}
+		return sum, nil
+	}
+
+	for member := range strings.SplitSeq(header.Get("Content-Digest"), ",") {
+		algorithm, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok || !strings.EqualFold(algorithm, "sha-256") {
+			continue
+		}
+
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidDigest, value)
+		}
+
+		sum, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidDigest, value)
+		}
+		return sum, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/skidoodle/safebin/internal/compress"
+	"github.com/skidoodle/safebin/internal/crypto"
+)
+
+var ErrUnknownEncoding = errors.New("unknown content encoding")
+
+// content is the plaintext of a stored file, whether it was stored as is or
+// compressed.
+type content interface {
+	io.ReadSeeker
+	io.ReaderAt
+	Size() int64
+}
+
+// compressible reports whether the compression policy covers contentType.
+func (app *App) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range app.Conf.CompressTypes {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// compressUpload sniffs the start of src and, when the policy covers its
+// content type, returns a reader of its compressed form along with the
+// encoding to record. Uploads shorter than the sniff window are left alone,
+// as framing would only make them larger.
+func (app *App) compressUpload(src io.Reader, ext string, opts UploadOptions) (io.Reader, string, error) {
+	if opts.E2E || len(app.Conf.CompressTypes) == 0 {
+		return src, "", nil
+	}
+
+	buffered := bufio.NewReaderSize(src, SniffLength)
+	head, err := buffered.Peek(SniffLength)
+	if err != nil || !app.compressible(detectContentType(ext, head)) {
+		return buffered, "", nil
+	}
+
+	compressor, err := compress.NewCompressor(buffered, CompressLevel)
+	if err != nil {
+		return nil, "", err
+	}
+	return compressor, compress.Encoding, nil
+}
+
+// decodeContent presents the plaintext of a decrypted blob according to the
+// encoding recorded in its file info.
+func decodeContent(decryptor *crypto.Decryptor, encoding string) (content, error) {
+	switch encoding {
+	case "":
+		return decryptor, nil
+	case compress.Encoding:
+		return compress.NewReader(decryptor, decryptor.Size())
+	default:
+		return nil, ErrUnknownEncoding
+	}
+}
+
+// negotiateEncoding picks the representation of a download. Compressed files
+// go out as gzip built from the stored frames when the client accepts it and
+// wants the whole file; ranges and other clients get the decoded content. Each
+// representation carries its own ETag.
+func negotiateEncoding(writer http.ResponseWriter, request *http.Request, id string, body content) io.ReadSeeker {
+	header := writer.Header()
+	header.Set("ETag", strconv.Quote(id))
+
+	framed, ok := body.(*compress.Reader)
+	if !ok {
+		return body
+	}
+
+	header.Add("Vary", "Accept-Encoding")
+	if request.Header.Get("Range") != "" || !acceptsGzip(request.Header.Get("Accept-Encoding")) {
+		return body
+	}
+
+	gz := framed.Gzip()
+	header.Set("ETag", strconv.Quote(id+"-gzip"))
+	header.Set("Content-Encoding", "gzip")
+	header.Set("Content-Length", strconv.FormatInt(gz.Size(), 10))
+	return gz
+}
+
+// acceptsGzip reports whether an Accept-Encoding header admits gzip, either
+// by name or through a wildcard, with a non-zero quality.
+func acceptsGzip(header string) bool {
+	accepted := false
+	for item := range strings.SplitSeq(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		if coding == "gzip" {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
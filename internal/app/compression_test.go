@@ -0,0 +1,149 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skidoodle/safebin/internal/crypto"
+)
+
+func storedSize(t *testing.T, app *App, slug string) int64 {
+	id, _ := resolveLink(t, app, slug)
+
+	info, err := app.Store.Stat(id)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	return info.Size
+}
+
+func TestIntegration_CompressedUpload(t *testing.T) {
+	app, _ := setupTestApp(t)
+	app.Conf.CompressTypes = splitList(DefaultCompressTypes)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	var log bytes.Buffer
+	for i := 0; log.Len() < 1<<20; i++ {
+		fmt.Fprintf(&log, "level=info msg=\"job finished\" job=%d took=15ms\n", i)
+	}
+	content := log.Bytes()
+
+	slug := slugFromResponse(t, uploadFile(t, server.URL, "app.log", content, nil))
+	if stored := storedSize(t, app, slug); stored*5 > int64(len(content)) {
+		t.Errorf("Log stored as %d of %d bytes", stored, len(content))
+	}
+
+	resp, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, map[string]string{"Accept-Encoding": "identity"})
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Fatalf("Download: %d, %d bytes", resp.StatusCode, len(body))
+	}
+	if got := resp.Header.Get("Content-Length"); got != fmt.Sprint(len(content)) {
+		t.Errorf("Content-Length = %s, want %d", got, len(content))
+	}
+
+	resp, body = getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, map[string]string{"Range": "bytes=300000-300099"})
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, content[300000:300100]) {
+		t.Errorf("Range: %d %q", resp.StatusCode, body)
+	}
+
+	random := make([]byte, 64<<10)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	slug = slugFromResponse(t, uploadFile(t, server.URL, "noise.bin", random, nil))
+	if stored := storedSize(t, app, slug); stored != crypto.EnvelopeHeaderSize+int64(len(random))+crypto.TagSize {
+		t.Errorf("Binary upload stored as %d bytes, want it uncompressed", stored)
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	app := &App{Conf: Config{CompressTypes: splitList(DefaultCompressTypes)}}
+
+	cases := map[string]bool{
+		"text/plain; charset=utf-8": true,
+		"application/json":          true,
+		"image/svg+xml":             true,
+		"image/png":                 false,
+		"application/octet-stream":  false,
+		"":                          false,
+	}
+	for contentType, want := range cases {
+		if got := app.compressible(contentType); got != want {
+			t.Errorf("compressible(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+
+	app.Conf.CompressTypes = nil
+	if app.compressible("text/plain") {
+		t.Error("Empty policy compressed text/plain")
+	}
+}
+
+func TestIntegration_ServesStoredGzip(t *testing.T) {
+	app, _ := setupTestApp(t)
+	app.Conf.CompressTypes = splitList(DefaultCompressTypes)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := bytes.Repeat([]byte("{\"event\":\"login\",\"ok\":true}\n"), 20000)
+	url := server.URL + "/" + slugFromResponse(t, uploadFile(t, server.URL, "events.json", content, nil))
+
+	resp, body := getWithHeaders(t, http.MethodGet, url, map[string]string{"Accept-Encoding": "br, gzip;q=0.8"})
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Gzip response headers: %v", resp.Header)
+	}
+	if got := resp.Header.Get("Content-Length"); got != fmt.Sprint(len(body)) || len(body)*10 > len(content) {
+		t.Errorf("Gzip body of %d bytes, Content-Length %s", len(body), got)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("gzip.NewReader failed: %v", err)
+	}
+	if plain, err := io.ReadAll(zr); err != nil || !bytes.Equal(plain, content) {
+		t.Fatalf("Gzip body did not decode to the upload: %v", err)
+	}
+	gzipTag := resp.Header.Get("ETag")
+
+	resp, body = getWithHeaders(t, http.MethodGet, url, map[string]string{"Accept-Encoding": "gzip;q=0, identity"})
+	if resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(body, content) {
+		t.Fatalf("Identity response: encoding %q, %d bytes", resp.Header.Get("Content-Encoding"), len(body))
+	}
+	if tag := resp.Header.Get("ETag"); tag == "" || tag == gzipTag {
+		t.Errorf("ETags for identity %q and gzip %q must differ", tag, gzipTag)
+	}
+
+	resp, body = getWithHeaders(t, http.MethodGet, url, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=10-19"})
+	if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(body, content[10:20]) {
+		t.Errorf("Range with gzip: %d %q %q", resp.StatusCode, resp.Header.Get("Content-Encoding"), body)
+	}
+
+	resp, _ = getWithHeaders(t, http.MethodGet, url, map[string]string{"Accept-Encoding": "gzip", "If-None-Match": gzipTag})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match on gzip ETag: want 304, got %d", resp.StatusCode)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, GZIP":     true,
+		"gzip;q=0":          false,
+		"*":                 true,
+		"*;q=0.5, gzip;q=0": false,
+		"br, identity":      false,
+	}
+	for header, want := range cases {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
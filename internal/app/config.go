@@ -1,15 +1,22 @@
 package app
 
 import (
+	"bytes"
+	"compress/flate"
 	"flag"
 	"fmt"
 	"html/template"
 	"io/fs"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/skidoodle/safebin/internal/slug"
+	"github.com/skidoodle/safebin/internal/store"
 	"go.etcd.io/bbolt"
 )
 
@@ -32,25 +39,79 @@ const (
 	MegaByte           = 1 << 20
 	ChunkSafetyMargin  = 2
 
-	SlugLength = 22
-	KeyLength  = 16
+	SlugLength = slug.Length
+	KeyLength  = slug.KeyLength
+
+	MinSecretLength = 16
+
+	DeleteTokenLength = 16
+	LeaseIDLength     = 8
+	SessionIDLength   = 16
+	DeleteTokenHeader = "X-Delete-Token"
+	ExpiresHeader     = "X-Safebin-Expires"
+	ExpiresField      = "expires"
+	MaxFieldSize      = 1 << 10
+
+	MaxDownloadsHeader = "X-Safebin-Max-Downloads"
+	MaxDownloadsField  = "max_downloads"
+	MaxDownloadLimit   = 1000
+
+	E2EHeader        = "X-Safebin-E2E"
+	E2EField         = "e2e"
+	E2EChunkOverhead = 16
+
+	PrivateHeader = "X-Safebin-Private"
+	PrivateField  = "private"
+
+	NoteHeader    = "X-Safebin-Note"
+	NoteField     = "note"
+	MaxNoteLength = 512
+	SniffLength   = 512
 
 	CleanupInterval = 1 * time.Hour
 	TempExpiry      = 4 * time.Hour
 	MinRetention    = 24 * time.Hour
 	MaxRetention    = 365 * 24 * time.Hour
 
-	DBDirName         = "db"
-	DBFileName        = "safebin.db"
-	DBBucketName      = "files"
-	DBBucketIndexName = "expiry_index"
-	TempDirName       = "tmp"
+	DBDirName          = "db"
+	DBFileName         = "safebin.db"
+	DBBucketName       = "files"
+	DBBucketIndexName  = "expiry_index"
+	DBBucketLeaseName  = "leases"
+	DBBucketUploadName = "uploads"
+	DBBucketProbeName  = "probes"
+	DBBucketLinkName   = "links"
+	TempDirName        = "tmp"
+
+	TusVersion        = "1.0.0"
+	TusExtensions     = "creation,termination,checksum"
+	TusChecksums      = "sha1,sha256"
+	TusMaxChunks      = 10000
+	LinkHeader        = "X-Safebin-Link"
+	ChunkDigestHeader = "X-Chunk-SHA256"
+	StatusChecksumBad = 460
+
+	ProbeRangeCount  = 8
+	ProbeRangeLength = 4 << 10
+	ProbeExpiry      = 5 * time.Minute
+
+	DecryptReadahead = 8
+
+	DefaultCompressTypes = "text/*,application/json,application/xml,application/javascript,application/x-ndjson,application/yaml,image/svg+xml"
+	CompressLevel        = flate.DefaultCompression
 )
 
 type Config struct {
 	Addr       string
 	StorageDir string
 	MaxMB      int64
+	SecretFile string
+	Secret     []byte
+	S3         store.S3Config
+
+	// CompressTypes lists the media types compressed before encryption. An
+	// entry ending in "/*" matches the whole type.
+	CompressTypes []string
 }
 
 type App struct {
@@ -58,31 +119,91 @@ type App struct {
 	Tmpl   *template.Template
 	Logger *slog.Logger
 	DB     *bbolt.DB
+	Store  store.Store
 	Assets fs.FS
+
+	uploadLocks sync.Map
 }
 
-func LoadConfig() Config {
+// LoadConfig reads the server configuration from the environment and then
+// from args, the flags of the serve command.
+func LoadConfig(args []string) (Config, error) {
 	hostEnv := getEnv("SAFEBIN_HOST", DefaultHost)
 	portEnv := getEnvInt("SAFEBIN_PORT", DefaultPort)
 	storageEnv := getEnv("SAFEBIN_STORAGE", DefaultStorage)
 	maxMBEnv := int64(getEnvInt("SAFEBIN_MAX_MB", DefaultMaxMB))
+	secretFileEnv := getEnv("SAFEBIN_SECRET_FILE", "")
+	compressEnv := getEnv("SAFEBIN_COMPRESS_TYPES", DefaultCompressTypes)
 
 	var host string
 	var port int
 	var storage string
 	var maxMB int64
-
-	flag.StringVar(&host, "h", hostEnv, "Bind address")
-	flag.IntVar(&port, "p", portEnv, "Port")
-	flag.StringVar(&storage, "s", storageEnv, "Storage directory")
-	flag.Int64Var(&maxMB, "m", maxMBEnv, "Max file size in MB")
-	flag.Parse()
+	var secretFile string
+	var compressTypes string
+
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.StringVar(&host, "h", hostEnv, "Bind address")
+	flags.IntVar(&port, "p", portEnv, "Port")
+	flags.StringVar(&storage, "s", storageEnv, "Storage directory")
+	flags.Int64Var(&maxMB, "m", maxMBEnv, "Max file size in MB")
+	flags.StringVar(&secretFile, "k", secretFileEnv, "Convergence secret file")
+	flags.StringVar(&compressTypes, "z", compressEnv, "Comma-separated content types to compress, empty to disable")
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if flags.NArg() > 0 {
+		// Reported the way the flag package reports its own parse errors.
+		err := fmt.Errorf("unexpected argument %q", flags.Arg(0))
+		fmt.Fprintln(flags.Output(), err)
+		flags.Usage()
+		return Config{}, err
+	}
 
 	return Config{
-		Addr:       fmt.Sprintf("%s:%d", host, port),
-		StorageDir: storage,
-		MaxMB:      maxMB,
+		Addr:          fmt.Sprintf("%s:%d", host, port),
+		StorageDir:    storage,
+		MaxMB:         maxMB,
+		SecretFile:    secretFile,
+		Secret:        []byte(getEnv("SAFEBIN_SECRET", "")),
+		CompressTypes: splitList(compressTypes),
+		S3: store.S3Config{
+			Endpoint:  getEnv("SAFEBIN_S3_ENDPOINT", ""),
+			Region:    getEnv("SAFEBIN_S3_REGION", ""),
+			Bucket:    getEnv("SAFEBIN_S3_BUCKET", ""),
+			AccessKey: getEnv("SAFEBIN_S3_ACCESS_KEY", ""),
+			SecretKey: getEnv("SAFEBIN_S3_SECRET_KEY", ""),
+			TempDir:   filepath.Join(storage, TempDirName),
+		},
+	}, nil
+}
+
+func NewStore(conf Config) (store.Store, error) {
+	if conf.S3.Bucket == "" {
+		return store.NewFS(conf.StorageDir), nil
 	}
+	return store.NewS3(conf.S3)
+}
+
+func LoadSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read secret file: %w", err)
+	}
+
+	secret := bytes.TrimSpace(data)
+	if err := ValidateSecret(secret); err != nil {
+		return nil, fmt.Errorf("secret file %s: %w", path, err)
+	}
+
+	return secret, nil
+}
+
+func ValidateSecret(secret []byte) error {
+	if len(secret) > 0 && len(secret) < MinSecretLength {
+		return fmt.Errorf("secret too short: need at least %d bytes, got %d", MinSecretLength, len(secret))
+	}
+	return nil
 }
 
 func getEnv(key, fallback string) string {
@@ -92,6 +213,16 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnvInt(key string, fallback int) int {
 	if value, ok := os.LookupEnv(key); ok {
 		i, err := strconv.Atoi(value)
@@ -1,6 +1,8 @@
 package app
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -35,3 +37,55 @@ func TestGetEnvInt(t *testing.T) {
 		t.Errorf("Expected fallback on invalid input, got %d", got)
 	}
 }
+
+func TestLoadSecret(t *testing.T) {
+	dir := t.TempDir()
+
+	good := filepath.Join(dir, "good")
+	if err := os.WriteFile(good, []byte("  0123456789abcdef0123\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := LoadSecret(good)
+	if err != nil {
+		t.Fatalf("LoadSecret failed: %v", err)
+	}
+	if string(secret) != "0123456789abcdef0123" {
+		t.Errorf("Secret not trimmed: %q", secret)
+	}
+
+	short := filepath.Join(dir, "short")
+	if err := os.WriteFile(short, []byte("tiny"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSecret(short); err == nil {
+		t.Error("Expected error for short secret")
+	}
+
+	if _, err := LoadSecret(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected error for missing secret file")
+	}
+
+	if err := ValidateSecret(nil); err != nil {
+		t.Errorf("Empty secret should disable keyed convergence, got %v", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("SAFEBIN_PORT", "9090")
+	t.Setenv("SAFEBIN_STORAGE", "/env/storage")
+
+	conf, err := LoadConfig([]string{"-s", "/flag/storage", "-z", ""})
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if conf.Addr != DefaultHost+":9090" || conf.StorageDir != "/flag/storage" || conf.CompressTypes != nil {
+		t.Errorf("Config: %+v", conf)
+	}
+
+	if _, err := LoadConfig([]string{"-p", "x"}); err == nil {
+		t.Error("LoadConfig accepted a bad port")
+	}
+	if _, err := LoadConfig([]string{"stray"}); err == nil {
+		t.Error("LoadConfig accepted a stray argument")
+	}
+}
@@ -1,6 +1,8 @@
 package app
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"time"
@@ -9,10 +11,18 @@ import (
 )
 
 type FileMeta struct {
-	ID        string    `json:"id"`
-	Size      int64     `json:"size"`
-	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at"`
+	ID            string    `json:"id"`
+	Size          int64     `json:"size"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	DownloadsLeft int       `json:"downloads_left,omitempty"`
+	// DownloadsReserved counts downloads being streamed. They are spent when
+	// the body is delivered and handed back when the transfer fails.
+	DownloadsReserved int  `json:"downloads_reserved,omitempty"`
+	E2E               bool `json:"e2e,omitempty"`
+	// Info is the sealed file info of the content, which every lease shares.
+	// Names and notes live on the leases.
+	Info []byte `json:"info,omitempty"`
 }
 
 func InitDB(storageDir string) (*bbolt.DB, error) {
@@ -34,6 +44,18 @@ func InitDB(storageDir string) (*bbolt.DB, error) {
 		if _, err := tx.CreateBucketIfNotExists([]byte(DBBucketIndexName)); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(DBBucketLeaseName)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(DBBucketUploadName)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(DBBucketProbeName)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(DBBucketLinkName)); err != nil {
+			return err
+		}
 		return nil
 	})
 
@@ -44,3 +66,35 @@ func InitDB(storageDir string) (*bbolt.DB, error) {
 
 	return db, nil
 }
+
+func (app *App) loadMeta(tx *bbolt.Tx, id string) (FileMeta, error) {
+	var meta FileMeta
+
+	b := tx.Bucket([]byte(DBBucketName))
+	if b == nil {
+		return meta, fmt.Errorf("bucket not found")
+	}
+
+	data := b.Get([]byte(id))
+	if data == nil {
+		return meta, ErrFileNotFound
+	}
+
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("decode metadata: %w", err)
+	}
+
+	return meta, nil
+}
+
+func (app *App) saveMeta(tx *bbolt.Tx, meta FileMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(DBBucketName)).Put([]byte(meta.ID), data)
+}
+
+func expiryIndexKey(expiresAt time.Time, target string) []byte {
+	return []byte(expiresAt.Format(time.RFC3339) + "_" + target)
+}
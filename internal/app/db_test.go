@@ -35,6 +35,9 @@ func TestInitDB(t *testing.T) {
 		if b := tx.Bucket([]byte(DBBucketIndexName)); b == nil {
 			t.Errorf("Bucket '%s' was not created", DBBucketIndexName)
 		}
+		if b := tx.Bucket([]byte(DBBucketLeaseName)); b == nil {
+			t.Errorf("Bucket '%s' was not created", DBBucketLeaseName)
+		}
 		return nil
 	})
 	if err != nil {
@@ -62,7 +65,8 @@ func TestDB_MetadataLifecycle(t *testing.T) {
 	fileID := "test-file-id"
 	fileSize := int64(1024)
 
-	if err := app.RegisterFile(fileID, fileSize); err != nil {
+	_, lease, err := app.RegisterFile(fileID, fileSize, Lease{}, nil, UploadOptions{})
+	if err != nil {
 		t.Fatalf("RegisterFile failed: %v", err)
 	}
 
@@ -88,12 +92,17 @@ func TestDB_MetadataLifecycle(t *testing.T) {
 			t.Error("Expiration time is in the past")
 		}
 
+		leaseKey := fileID + "/" + lease.LeaseID
+		if data := tx.Bucket([]byte(DBBucketLeaseName)).Get([]byte(leaseKey)); data == nil {
+			t.Error("Lease record not found")
+		}
+
 		bIndex := tx.Bucket([]byte(DBBucketIndexName))
-		indexKey := []byte(meta.ExpiresAt.Format(time.RFC3339) + "_" + fileID)
+		indexKey := []byte(lease.ExpiresAt.Format(time.RFC3339) + "_" + leaseKey)
 		if val := bIndex.Get(indexKey); val == nil {
 			t.Error("Index entry not found")
-		} else if string(val) != fileID {
-			t.Errorf("Index value mismatch: want %s, got %s", fileID, string(val))
+		} else if string(val) != leaseKey {
+			t.Errorf("Index value mismatch: want %s, got %s", leaseKey, string(val))
 		}
 
 		return nil
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/skidoodle/safebin/internal/crypto"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	ErrFileNotFound       = errors.New("file not found")
+	ErrInvalidDeleteToken = errors.New("invalid deletion token")
+)
+
+func newDeleteToken() (string, string, error) {
+	raw := make([]byte, DeleteTokenLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("generate deletion token: %w", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashDeleteToken(token), nil
+}
+
+func hashDeleteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (app *App) HandleDeleteFile(writer http.ResponseWriter, request *http.Request) {
+	key, ext, err := parseSlug(request.PathValue("slug"))
+	if err != nil {
+		app.SendError(writer, request, slugErrorStatus(err))
+		return
+	}
+
+	// The token is a bearer credential, so it is only accepted as a header;
+	// query strings end up in access logs and browser history.
+	token := request.Header.Get(DeleteTokenHeader)
+	if token == "" {
+		app.SendError(writer, request, http.StatusUnauthorized)
+		return
+	}
+
+	linkID := crypto.GetID(key, ext)
+
+	switch err := app.DeleteFile(linkID, token); {
+	case err == nil:
+		writer.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrFileNotFound):
+		app.SendError(writer, request, http.StatusNotFound)
+	case errors.Is(err, ErrInvalidDeleteToken):
+		app.SendError(writer, request, http.StatusForbidden)
+	default:
+		app.Logger.Error("Failed to delete file", "link", linkID, "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+	}
+}
+
+// DeleteFile revokes the lease behind a share link, given the deletion token
+// issued with it.
+func (app *App) DeleteFile(linkID, token string) error {
+	hash := hashDeleteToken(token)
+
+	var blob string
+	err := app.DB.Update(func(tx *bbolt.Tx) error {
+		lease, err := app.loadLink(tx, linkID)
+		if err != nil {
+			return err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(lease.DeleteHash), []byte(hash)) != 1 {
+			return ErrInvalidDeleteToken
+		}
+
+		blob, err = app.revokeLease(tx, lease)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	app.deleteBlobs(blob)
+	return nil
+}
+
+// removeFile drops a file's metadata and leases and returns the ID of the blob
+// to delete. The blob itself is left to the caller, who deletes it with
+// deleteBlobs once the transaction has committed.
+func (app *App) removeFile(tx *bbolt.Tx, meta FileMeta) (string, error) {
+	leases, err := app.loadLeases(tx, meta.ID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, lease := range leases {
+		if err := deleteLease(tx, lease); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Bucket([]byte(DBBucketName)).Delete([]byte(meta.ID)); err != nil {
+		return "", fmt.Errorf("delete metadata: %w", err)
+	}
+
+	return meta.ID, nil
+}
+
+// deleteBlobs removes blobs whose records are gone. A failure only leaves an
+// orphan blob behind, which admin reconcile removes, so it is logged rather
+// than returned.
+func (app *App) deleteBlobs(ids ...string) {
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		if err := app.Store.Delete(id); err != nil {
+			app.Logger.Error("Failed to remove file", "id", id, "err", err)
+		}
+	}
+}
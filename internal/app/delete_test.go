@@ -0,0 +1,208 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skidoodle/safebin/internal/store"
+	"go.etcd.io/bbolt"
+)
+
+func deleteRequest(t *testing.T, url, token string) *http.Response {
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	if token != "" {
+		req.Header.Set(DeleteTokenHeader, token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Delete request failed: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Errorf("Failed to close delete response body: %v", err)
+	}
+	return resp
+}
+
+func TestIntegration_DeleteWithToken(t *testing.T) {
+	app, storageDir := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	resp := uploadFile(t, server.URL, "oops.txt", []byte("accidental secret"), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload failed status: %d", resp.StatusCode)
+	}
+
+	token := resp.Header.Get(DeleteTokenHeader)
+	if token == "" {
+		t.Fatal("Upload response did not include a deletion token")
+	}
+	slug := slugFromResponse(t, resp)
+	id, _ := resolveLink(t, app, slug)
+
+	var leases []Lease
+	if err := app.DB.View(func(tx *bbolt.Tx) error {
+		l, err := app.loadLeases(tx, id)
+		leases = l
+		return err
+	}); err != nil {
+		t.Fatalf("Load leases failed: %v", err)
+	}
+	if len(leases) != 1 {
+		t.Fatalf("Want one lease, got %d", len(leases))
+	}
+	for _, lease := range leases {
+		if lease.DeleteHash == token {
+			t.Fatal("Deletion token stored in plaintext")
+		}
+	}
+
+	if resp := deleteRequest(t, server.URL+"/"+slug, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Missing token: want 401, got %d", resp.StatusCode)
+	}
+	if resp := deleteRequest(t, server.URL+"/"+slug+"?token="+token, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Token in query string: want 401, got %d", resp.StatusCode)
+	}
+	if resp := deleteRequest(t, server.URL+"/"+slug, "wrong-token"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Wrong token: want 403, got %d", resp.StatusCode)
+	}
+	if resp := deleteRequest(t, server.URL+"/"+slug, token); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Valid token: want 204, got %d", resp.StatusCode)
+	}
+
+	if _, err := os.Stat(filepath.Join(storageDir, id)); !os.IsNotExist(err) {
+		t.Error("Blob still present after delete")
+	}
+
+	if err := app.DB.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket([]byte(DBBucketName)).Get([]byte(id)); v != nil {
+			t.Error("Metadata still present after delete")
+		}
+		if k, _ := tx.Bucket([]byte(DBBucketIndexName)).Cursor().First(); k != nil {
+			t.Errorf("Index entry still present after delete: %s", k)
+		}
+		if k, _ := tx.Bucket([]byte(DBBucketLinkName)).Cursor().First(); k != nil {
+			t.Errorf("Link still present after delete: %s", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("DB View failed: %v", err)
+	}
+
+	dl, err := http.Get(server.URL + "/" + slug)
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	if err := dl.Body.Close(); err != nil {
+		t.Errorf("Failed to close download body: %v", err)
+	}
+	if dl.StatusCode != http.StatusNotFound {
+		t.Errorf("Download after delete: want 404, got %d", dl.StatusCode)
+	}
+
+	if resp := deleteRequest(t, server.URL+"/"+slug, token); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Repeated delete: want 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_DeleteTokenPerUploader(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("shared content")
+
+	first := uploadFile(t, server.URL, "a.txt", content, nil)
+	firstToken := first.Header.Get(DeleteTokenHeader)
+	firstSlug := slugFromResponse(t, first)
+
+	second := uploadFile(t, server.URL, "a.txt", content, nil)
+	secondToken := second.Header.Get(DeleteTokenHeader)
+	secondSlug := slugFromResponse(t, second)
+
+	id, _ := resolveLink(t, app, firstSlug)
+	if other, _ := resolveLink(t, app, secondSlug); other != id || secondSlug == firstSlug {
+		t.Fatalf("Duplicate upload should share the blob under a link of its own: %s %s", firstSlug, secondSlug)
+	}
+
+	if firstToken == secondToken {
+		t.Fatal("Deletion tokens should be unique per upload")
+	}
+
+	if resp := deleteRequest(t, server.URL+"/"+secondSlug, firstToken); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Token used on another upload's link: want 403, got %d", resp.StatusCode)
+	}
+
+	if resp := deleteRequest(t, server.URL+"/"+firstSlug, firstToken); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("First uploader token rejected: %d", resp.StatusCode)
+	}
+
+	if resp, _ := getWithHeaders(t, http.MethodGet, server.URL+"/"+firstSlug, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Revoked link still served: %d", resp.StatusCode)
+	}
+
+	if resp, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+secondSlug, nil); resp.StatusCode != http.StatusOK || string(body) != string(content) {
+		t.Errorf("Revoking one lease removed a file another upload still holds: %d", resp.StatusCode)
+	}
+
+	if resp := deleteRequest(t, server.URL+"/"+firstSlug, firstToken); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Revoked link deleted again: want 404, got %d", resp.StatusCode)
+	}
+
+	if resp := deleteRequest(t, server.URL+"/"+secondSlug, secondToken); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Second uploader token rejected: %d", resp.StatusCode)
+	}
+
+	if _, err := app.Store.Stat(id); err == nil {
+		t.Error("Blob survived its last lease")
+	}
+}
+
+// failingDeletes is a store whose deletions always fail.
+type failingDeletes struct {
+	store.Store
+}
+
+func (failingDeletes) Delete(string) error {
+	return errors.New("disk on fire")
+}
+
+func TestIntegration_DeleteCommitsDespiteStoreFailure(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	resp := uploadFile(t, server.URL, "doomed.txt", []byte("short lived"), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload failed status: %d", resp.StatusCode)
+	}
+	token := resp.Header.Get(DeleteTokenHeader)
+	slug := slugFromResponse(t, resp)
+	id, _ := resolveLink(t, app, slug)
+
+	app.Store = failingDeletes{app.Store}
+	if resp := deleteRequest(t, server.URL+"/"+slug, token); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Delete: want 204, got %d", resp.StatusCode)
+	}
+
+	if err := app.DB.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket([]byte(DBBucketName)).Get([]byte(id)); v != nil {
+			t.Error("Metadata still present after delete")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("DB View failed: %v", err)
+	}
+
+	report, err := app.Reconcile(false)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(report.OrphanBlobs) != 1 || report.OrphanBlobs[0] != id {
+		t.Errorf("Want the undeleted blob reported as an orphan, got %v", report.OrphanBlobs)
+	}
+}
@@ -1,47 +1,52 @@
 package app
 
 import (
-	"encoding/base64"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/skidoodle/safebin/internal/crypto"
+	"github.com/skidoodle/safebin/internal/slug"
 	"go.etcd.io/bbolt"
 )
 
-func (app *App) HandleGetFile(writer http.ResponseWriter, request *http.Request) {
-	slug := request.PathValue("slug")
-	if len(slug) < SlugLength {
-		app.SendError(writer, request, http.StatusBadRequest)
-		return
-	}
+var (
+	ErrSlugTooShort       = slug.ErrTooShort
+	ErrInvalidKey         = slug.ErrInvalidKey
+	ErrDownloadsExhausted = errors.New("no downloads left")
+)
 
-	keyBase64 := slug[:SlugLength]
-	ext := slug[SlugLength:]
+func parseSlug(value string) ([]byte, string, error) {
+	return slug.Parse(value)
+}
 
-	key, err := base64.RawURLEncoding.DecodeString(keyBase64)
-	if err != nil || len(key) != KeyLength {
-		app.SendError(writer, request, http.StatusUnauthorized)
+func slugErrorStatus(err error) int {
+	if errors.Is(err, ErrInvalidKey) {
+		return http.StatusUnauthorized
+	}
+	return http.StatusBadRequest
+}
+
+func (app *App) HandleGetFile(writer http.ResponseWriter, request *http.Request) {
+	slug := request.PathValue("slug")
+	linkKey, ext, err := parseSlug(slug)
+	if err != nil {
+		app.SendError(writer, request, slugErrorStatus(err))
 		return
 	}
 
-	id := crypto.GetID(key, ext)
+	linkID := crypto.GetID(linkKey, ext)
 
+	var lease Lease
 	var meta FileMeta
 	err = app.DB.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(DBBucketName))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
-		}
-		data := b.Get([]byte(id))
-		if data == nil {
-			return fmt.Errorf("file not found")
+		if lease, err = app.loadLink(tx, linkID); err != nil {
+			return err
 		}
-		return json.Unmarshal(data, &meta)
+		meta, err = app.loadMeta(tx, lease.ID)
+		return err
 	})
 
 	if err != nil {
@@ -49,27 +54,39 @@ func (app *App) HandleGetFile(writer http.ResponseWriter, request *http.Request)
 		return
 	}
 
-	path := filepath.Join(app.Conf.StorageDir, id)
-	info, err := os.Stat(path)
+	id := lease.ID
+	key, err := crypto.OpenMetadata(linkKey, lease.Key, []byte(linkID))
+	if err != nil {
+		app.Logger.Error("Integrity check failed: file key rejected", "id", id, "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	if meta.E2E && wantsDecryptPage(request) {
+		app.renderDecryptPage(writer, request, slug)
+		return
+	}
+
+	stat, err := app.Store.Stat(id)
 	if err != nil {
 		app.SendError(writer, request, http.StatusNotFound)
 		return
 	}
 
-	if info.Size() != meta.Size {
+	if stat.Size != meta.Size {
 		app.Logger.Error("Integrity check failed: disk size mismatch",
 			"id", id,
-			"disk_bytes", info.Size(),
+			"disk_bytes", stat.Size,
 			"expected_bytes", meta.Size,
 		)
 		app.SendError(writer, request, http.StatusInternalServerError)
 		return
 	}
 
-	file, err := os.Open(path)
+	file, err := app.Store.Open(id)
 
 	if err != nil {
-		app.Logger.Error("Failed to open file", "path", path, "err", err)
+		app.Logger.Error("Failed to open file", "id", id, "err", err)
 		app.SendError(writer, request, http.StatusInternalServerError)
 		return
 	}
@@ -80,28 +97,238 @@ func (app *App) HandleGetFile(writer http.ResponseWriter, request *http.Request)
 		}
 	}()
 
-	streamer, err := crypto.NewGCMStreamer(key)
+	// Range requests are served from the decryptor's chunk cache; readahead
+	// would only decrypt chunks the client never asked for.
+	var opts []crypto.Option
+	if request.Header.Get("Range") == "" {
+		opts = sequentialOptions()
+	}
 
+	decryptor, err := crypto.NewDecryptor(file, key, stat.Size, []byte(id), opts...)
 	if err != nil {
-		app.Logger.Error("Failed to create crypto streamer", "err", err)
+		app.Logger.Error("Integrity check failed: blob rejected", "id", id, "err", err)
 		app.SendError(writer, request, http.StatusInternalServerError)
 		return
 	}
+	defer func() { _ = decryptor.Close() }()
+
+	// The content type and encoding belong to the blob; the name, note and
+	// upload time to the lease this link was issued with.
+	info := FileInfo{Name: slug, ContentType: mime.TypeByExtension(ext), UploadedAt: stat.ModTime}
+	if len(meta.Info) > 0 {
+		stored, err := openFileInfo(key, id, meta.Info)
+		if err != nil {
+			app.Logger.Error("Integrity check failed: file info rejected", "id", id, "err", err)
+			app.SendError(writer, request, http.StatusInternalServerError)
+			return
+		}
+		info.ContentType, info.Encoding = stored.ContentType, stored.Encoding
+	}
+	if len(lease.Info) > 0 {
+		uploaded, err := openFileInfo(linkKey, linkID, lease.Info)
+		if err != nil {
+			app.Logger.Error("Integrity check failed: upload info rejected", "id", id, "err", err)
+			app.SendError(writer, request, http.StatusInternalServerError)
+			return
+		}
+		if uploaded.Name != "" {
+			info.Name = uploaded.Name
+		}
+		info.UploadedAt, info.Note = uploaded.UploadedAt, uploaded.Note
+	}
 
-	decryptor := crypto.NewDecryptor(file, streamer.AEAD, info.Size())
+	body, err := decodeContent(decryptor, info.Encoding)
+	if err != nil {
+		app.Logger.Error("Integrity check failed: content rejected", "id", id, "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
 
-	contentType := mime.TypeByExtension(ext)
-	if contentType == "" {
-		contentType = "application/octet-stream"
+	disposition := "inline"
+	if info.ContentType == "" || meta.E2E {
+		info.ContentType = "application/octet-stream"
+	}
+	if meta.E2E {
+		disposition = "attachment"
 	}
 
 	csp := "default-src 'none'; img-src 'self' data:; media-src 'self' data:; " +
 		"style-src 'unsafe-inline'; sandbox allow-forms allow-scripts allow-downloads allow-same-origin"
 
-	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Content-Type", info.ContentType)
 	writer.Header().Set("Content-Security-Policy", csp)
 	writer.Header().Set("X-Content-Type-Options", "nosniff")
-	writer.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", slug))
+	writer.Header().Set("Content-Disposition", contentDisposition(disposition, info.Name))
+	if info.Note != "" {
+		writer.Header().Set(NoteHeader, mime.QEncoding.Encode("utf-8", info.Note))
+	}
+
+	representation := negotiateEncoding(writer, request, id, body)
+
+	if meta.DownloadsLeft == 0 {
+		http.ServeContent(writer, request, slug, info.UploadedAt, representation)
+		return
+	}
+
+	tracker := &downloadTracker{
+		ResponseWriter: writer,
+		app:            app,
+		request:        request,
+		id:             id,
+		spends:         request.Method == http.MethodGet,
+	}
+	http.ServeContent(tracker, request, slug, info.UploadedAt, representation)
+
+	if tracker.reserved {
+		if err := app.settleDownload(id, tracker.complete()); err != nil {
+			app.Logger.Error("Failed to settle download budget", "id", id, "err", err)
+		}
+	}
+}
+
+func wantsDecryptPage(request *http.Request) bool {
+	if request.Method != http.MethodGet || request.URL.Query().Has("raw") {
+		return false
+	}
+	return strings.Contains(request.Header.Get("Accept"), "text/html")
+}
+
+func (app *App) renderDecryptPage(writer http.ResponseWriter, request *http.Request, slug string) {
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Referrer-Policy", "no-referrer")
+
+	err := app.Tmpl.ExecuteTemplate(writer, "decrypt", map[string]any{
+		"Slug":    slug,
+		"Host":    request.Host,
+		"Version": Version,
+	})
+
+	if err != nil {
+		app.Logger.Error("Template error", "err", err)
+	}
+}
+
+// reserveDownload takes one download from the budget of id before its body
+// is streamed, so concurrent requests cannot both be handed the last one.
+func (app *App) reserveDownload(id string) error {
+	return app.DB.Update(func(tx *bbolt.Tx) error {
+		meta, err := app.loadMeta(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if meta.DownloadsLeft-meta.DownloadsReserved <= 0 {
+			return ErrDownloadsExhausted
+		}
+
+		meta.DownloadsReserved++
+		return app.saveMeta(tx, meta)
+	})
+}
+
+// settleDownload releases a reservation. A completed download spends it and
+// removes the file when it was the last; a failed one is refunded.
+func (app *App) settleDownload(id string, completed bool) error {
+	var blob string
+	err := app.DB.Update(func(tx *bbolt.Tx) error {
+		meta, err := app.loadMeta(tx, id)
+		if errors.Is(err, ErrFileNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		meta.DownloadsReserved = max(0, meta.DownloadsReserved-1)
+		if completed {
+			meta.DownloadsLeft--
+			if meta.DownloadsLeft <= 0 {
+				blob, err = app.removeFile(tx, meta)
+				return err
+			}
+		}
+
+		return app.saveMeta(tx, meta)
+	})
+	if err != nil {
+		return err
+	}
+
+	app.deleteBlobs(blob)
+	return nil
+}
+
+// downloadTracker reserves a download from the budget as the headers of a
+// response with a body go out, before any of the body, and records how much of
+// it was delivered. Every such response counts, ranges included, so a budget
+// cannot be read piecemeal. Without a download left the response becomes a
+// 404.
+type downloadTracker struct {
+	http.ResponseWriter
+	app     *App
+	request *http.Request
+	id      string
+	spends  bool
+
+	reserved bool
+	denied   bool
+	status   int
+	written  int64
+}
+
+func (d *downloadTracker) WriteHeader(status int) {
+	if d.status != 0 {
+		return
+	}
+	d.status = status
+
+	if d.spends && (status == http.StatusOK || status == http.StatusPartialContent) {
+		if err := d.app.reserveDownload(d.id); err != nil {
+			d.deny(err)
+			return
+		}
+		d.reserved = true
+	}
+
+	d.ResponseWriter.WriteHeader(status)
+}
+
+func (d *downloadTracker) deny(err error) {
+	d.denied = true
+	clear(d.Header())
+
+	if errors.Is(err, ErrDownloadsExhausted) || errors.Is(err, ErrFileNotFound) {
+		d.app.SendError(d.ResponseWriter, d.request, http.StatusNotFound)
+		return
+	}
+
+	d.app.Logger.Error("Failed to reserve download", "id", d.id, "err", err)
+	d.app.SendError(d.ResponseWriter, d.request, http.StatusInternalServerError)
+}
+
+func (d *downloadTracker) Write(p []byte) (int, error) {
+	if d.status == 0 {
+		d.WriteHeader(http.StatusOK)
+	}
+	if d.denied {
+		return 0, ErrDownloadsExhausted
+	}
+
+	n, err := d.ResponseWriter.Write(p)
+	d.written += int64(n)
+	return n, err
+}
+
+func (d *downloadTracker) complete() bool {
+	if d.denied || (d.status != http.StatusOK && d.status != http.StatusPartialContent) {
+		return false
+	}
+
+	length, err := strconv.ParseInt(d.Header().Get("Content-Length"), 10, 64)
+	if err != nil {
+		return false
+	}
 
-	http.ServeContent(writer, request, slug, info.ModTime(), decryptor)
+	return d.written == length
 }
@@ -0,0 +1,274 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skidoodle/safebin/internal/crypto"
+	"go.etcd.io/bbolt"
+)
+
+func getWithHeaders(t *testing.T, method, url string, headers map[string]string) (*http.Response, []byte) {
+	req, _ := http.NewRequest(method, url, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s request failed: %v", method, err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Read body failed: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Errorf("Failed to close response body: %v", err)
+	}
+	return resp, body
+}
+
+func downloadsLeft(t *testing.T, app *App, slug string) (int, bool) {
+	key, ext, err := parseSlug(slug)
+	if err != nil {
+		t.Fatalf("parseSlug failed: %v", err)
+	}
+
+	var meta FileMeta
+	err = app.DB.View(func(tx *bbolt.Tx) error {
+		lease, err := app.loadLink(tx, crypto.GetID(key, ext))
+		if err != nil {
+			return err
+		}
+		meta, err = app.loadMeta(tx, lease.ID)
+		return err
+	})
+	if err != nil {
+		return 0, false
+	}
+	return meta.DownloadsLeft, true
+}
+
+func TestIntegration_BurnAfterRead(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("one-time credential")
+	resp := uploadFile(t, server.URL, "creds.txt", content, map[string]string{
+		MaxDownloadsHeader: "1",
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload failed status: %d", resp.StatusCode)
+	}
+	slug := slugFromResponse(t, resp)
+
+	head, _ := getWithHeaders(t, http.MethodHead, server.URL+"/"+slug, nil)
+	if head.StatusCode != http.StatusOK {
+		t.Fatalf("HEAD failed: %d", head.StatusCode)
+	}
+	if left, ok := downloadsLeft(t, app, slug); !ok || left != 1 {
+		t.Fatalf("HEAD consumed budget: left=%d ok=%v", left, ok)
+	}
+
+	dl, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil)
+	if dl.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Fatalf("First download failed: %d %q", dl.StatusCode, body)
+	}
+
+	if _, ok := downloadsLeft(t, app, slug); ok {
+		t.Error("Metadata still present after budget exhausted")
+	}
+
+	dl, _ = getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil)
+	if dl.StatusCode != http.StatusNotFound {
+		t.Errorf("Second download: want 404, got %d", dl.StatusCode)
+	}
+}
+
+func TestIntegration_BudgetedUploadsAreNotDeduplicated(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("handed to two people")
+	plain := slugFromResponse(t, uploadFile(t, server.URL, "shared.txt", content, nil))
+	first := slugFromResponse(t, uploadFile(t, server.URL, "shared.txt", content, map[string]string{MaxDownloadsHeader: "1"}))
+	second := slugFromResponse(t, uploadFile(t, server.URL, "shared.txt", content, map[string]string{MaxDownloadsHeader: "1"}))
+	if first == second || first == plain || second == plain {
+		t.Fatalf("Budgeted uploads share a link: %s %s %s", plain, first, second)
+	}
+
+	if dl, _ := getWithHeaders(t, http.MethodGet, server.URL+"/"+first, nil); dl.StatusCode != http.StatusOK {
+		t.Fatalf("First download failed: %d", dl.StatusCode)
+	}
+	for _, slug := range []string{plain, second} {
+		if dl, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil); dl.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+			t.Errorf("Download of %s after another upload's budget ran out: %d", slug, dl.StatusCode)
+		}
+	}
+}
+
+func TestIntegration_DownloadBudgetReservation(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("one-time credential")
+	slug := slugFromResponse(t, uploadFile(t, server.URL, "creds.txt", content, map[string]string{
+		MaxDownloadsHeader: "1",
+	}))
+	id, _ := resolveLink(t, app, slug)
+
+	// Another download holds the only one in the budget while it streams.
+	if err := app.reserveDownload(id); err != nil {
+		t.Fatalf("reserveDownload failed: %v", err)
+	}
+	if err := app.reserveDownload(id); !errors.Is(err, ErrDownloadsExhausted) {
+		t.Fatalf("Second reservation: want ErrDownloadsExhausted, got %v", err)
+	}
+
+	dl, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil)
+	if dl.StatusCode != http.StatusNotFound || bytes.Contains(body, content) {
+		t.Fatalf("Download during reservation: want 404, got %d %q", dl.StatusCode, body)
+	}
+
+	// The other transfer fails, so its download is handed back.
+	if err := app.settleDownload(id, false); err != nil {
+		t.Fatalf("settleDownload failed: %v", err)
+	}
+	if left, ok := downloadsLeft(t, app, slug); !ok || left != 1 {
+		t.Fatalf("Refund: left=%d ok=%v", left, ok)
+	}
+
+	dl, body = getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil)
+	if dl.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Fatalf("Download after refund failed: %d %q", dl.StatusCode, body)
+	}
+	if _, ok := downloadsLeft(t, app, slug); ok {
+		t.Error("Metadata still present after budget exhausted")
+	}
+}
+
+func TestIntegration_DownloadBudgetRanges(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("0123456789abcdefghij")
+	resp := uploadFile(t, server.URL, "range.txt", content, map[string]string{
+		MaxDownloadsHeader: "2",
+	})
+	slug := slugFromResponse(t, resp)
+	if got := resp.Header.Get(MaxDownloadsHeader); got != "2" {
+		t.Errorf("Upload response should echo budget, got %q", got)
+	}
+
+	partial, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, map[string]string{"Range": "bytes=0-18"})
+	if partial.StatusCode != http.StatusPartialContent || !bytes.Equal(body, content[:19]) {
+		t.Fatalf("Range short of the end failed: %d %q", partial.StatusCode, body)
+	}
+	if left, _ := downloadsLeft(t, app, slug); left != 1 {
+		t.Fatalf("A range should spend a download like any other body, left=%d", left)
+	}
+
+	partial, body = getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, map[string]string{"Range": "bytes=4-9"})
+	if partial.StatusCode != http.StatusPartialContent || !bytes.Equal(body, content[4:10]) {
+		t.Fatalf("Inner range failed: %d %q", partial.StatusCode, body)
+	}
+	if _, ok := downloadsLeft(t, app, slug); ok {
+		t.Error("Budget should be exhausted after two ranges")
+	}
+
+	full, _ := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil)
+	if full.StatusCode != http.StatusNotFound {
+		t.Errorf("Download after the budget ran out: want 404, got %d", full.StatusCode)
+	}
+}
+
+func TestIntegration_FileInfoRestoresNameAndType(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 64)...)
+	slug := slugFromResponse(t, uploadFile(t, server.URL, "Résumé photo", png, map[string]string{
+		NoteHeader: "scanned at the office",
+	}))
+
+	resp, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, png) {
+		t.Fatalf("Download failed: %d", resp.StatusCode)
+	}
+
+	if got := resp.Header.Get("Content-Type"); got != "image/png" {
+		t.Errorf("Expected sniffed image/png, got %q", got)
+	}
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] != "Résumé photo" {
+		t.Errorf("Expected original filename, got %q (%v)", resp.Header.Get("Content-Disposition"), err)
+	}
+
+	note, err := new(mime.WordDecoder).DecodeHeader(resp.Header.Get(NoteHeader))
+	if err != nil || note != "scanned at the office" {
+		t.Errorf("Expected note to round trip, got %q (%v)", note, err)
+	}
+
+	id, _ := resolveLink(t, app, slug)
+	err = app.DB.View(func(tx *bbolt.Tx) error {
+		records := [][]byte{tx.Bucket([]byte(DBBucketName)).Get([]byte(id))}
+		c := tx.Bucket([]byte(DBBucketLeaseName)).Cursor()
+		for k, v := c.Seek(leasePrefix(id)); k != nil && bytes.HasPrefix(k, leasePrefix(id)); k, v = c.Next() {
+			records = append(records, v)
+		}
+		for _, raw := range records {
+			if bytes.Contains(raw, []byte("Résumé")) || bytes.Contains(raw, []byte("scanned at the office")) {
+				t.Errorf("Record stores the filename or note in the clear: %s", raw)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View failed: %v", err)
+	}
+}
+
+func TestIntegration_DedupIsolatesFileInfo(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("shared body")
+	first := slugFromResponse(t, uploadFile(t, server.URL, "first.txt", content, nil))
+	second := slugFromResponse(t, uploadFile(t, server.URL, "second.txt", content, nil))
+	noted := slugFromResponse(t, uploadFile(t, server.URL, "first.txt", content, map[string]string{NoteHeader: "for the auditors"}))
+
+	id, _ := resolveLink(t, app, first)
+	for _, slug := range []string{second, noted} {
+		if other, _ := resolveLink(t, app, slug); other != id {
+			t.Errorf("%s: expected the content to be stored once, got blobs %s and %s", slug, id, other)
+		}
+	}
+	if first == second || first == noted {
+		t.Fatalf("Uploads share a link: %s %s %s", first, second, noted)
+	}
+
+	for slug, want := range map[string]string{first: "first.txt", second: "second.txt", noted: "first.txt"} {
+		resp, _ := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil)
+		_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+		if params["filename"] != want {
+			t.Errorf("%s: expected filename %q, got %q", slug, want, params["filename"])
+		}
+		if got := resp.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+			t.Errorf("%s: expected text/plain, got %q", slug, got)
+		}
+		if note := resp.Header.Get(NoteHeader); (slug == noted) != (note != "") {
+			t.Errorf("%s: unexpected note header %q", slug, note)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/skidoodle/safebin/internal/crypto"
+)
+
+// FileInfo describes a stored file. It is sealed in two parts: the content
+// type, size and encoding with the blob, and the name, note and upload time
+// with each lease.
+type FileInfo struct {
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	Note        string    `json:"note,omitempty"`
+	Encoding    string    `json:"encoding,omitempty"`
+}
+
+func sealFileInfo(key []byte, id string, info FileInfo) ([]byte, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("encode file info: %w", err)
+	}
+	return crypto.SealMetadata(key, data, []byte(id))
+}
+
+func openFileInfo(key []byte, id string, sealed []byte) (FileInfo, error) {
+	var info FileInfo
+
+	data, err := crypto.OpenMetadata(key, sealed, []byte(id))
+	if err != nil {
+		return info, err
+	}
+
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("decode file info: %w", err)
+	}
+
+	return info, nil
+}
+
+func detectContentType(ext string, head []byte) string {
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(head)
+}
+
+func contentDisposition(disposition, name string) string {
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": name}); header != "" {
+		return header
+	}
+	return disposition
+}
+
+type infoProbe struct {
+	src  io.Reader
+	head []byte
+	size int64
+}
+
+func (p *infoProbe) Read(buf []byte) (int, error) {
+	n, err := p.src.Read(buf)
+	if room := SniffLength - len(p.head); room > 0 {
+		p.head = append(p.head, buf[:min(n, room)]...)
+	}
+	p.size += int64(n)
+	return n, err
+}
+
+// info describes the content itself, which every upload of a deduplicated
+// blob shares.
+func (p *infoProbe) info(ext string) FileInfo {
+	return FileInfo{
+		ContentType: detectContentType(ext, p.head),
+		Size:        p.size,
+	}
+}
+
+// uploadInfo describes one upload of the content, which is kept with its
+// lease.
+func uploadInfo(filename string, opts UploadOptions) FileInfo {
+	name := filepath.Base(filename)
+	if name == "." || name == string(filepath.Separator) {
+		name = ""
+	}
+
+	return FileInfo{
+		Name:       name,
+		UploadedAt: time.Now().UTC(),
+		Note:       opts.Note,
+	}
+}
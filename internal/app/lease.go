@@ -0,0 +1,158 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Lease is one upload's hold on a stored file. Each lease has a share link of
+// its own: the link key seals the file key into Key and the uploader's name
+// and note into Info, so a link opens the shared blob but only ever shows the
+// file info of the upload that made it.
+type Lease struct {
+	ID         string    `json:"id"`
+	LeaseID    string    `json:"lease_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	DeleteHash string    `json:"delete_hash,omitempty"`
+	LinkID     string    `json:"link_id,omitempty"`
+	Key        []byte    `json:"key,omitempty"`
+	Info       []byte    `json:"info,omitempty"`
+}
+
+func (l Lease) key() []byte {
+	return leaseKey(l.ID, l.LeaseID)
+}
+
+func leaseKey(id, leaseID string) []byte {
+	return []byte(id + "/" + leaseID)
+}
+
+func leasePrefix(id string) []byte {
+	return []byte(id + "/")
+}
+
+func newLeaseID() (string, error) {
+	raw := make([]byte, LeaseIDLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate lease id: %w", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func putLease(tx *bbolt.Tx, lease Lease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Bucket([]byte(DBBucketLeaseName)).Put(lease.key(), data); err != nil {
+		return err
+	}
+
+	if lease.LinkID != "" {
+		if err := tx.Bucket([]byte(DBBucketLinkName)).Put([]byte(lease.LinkID), lease.key()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket([]byte(DBBucketIndexName)).Put(expiryIndexKey(lease.ExpiresAt, string(lease.key())), lease.key())
+}
+
+// deleteLease removes a lease together with its expiry index entry and link.
+func deleteLease(tx *bbolt.Tx, lease Lease) error {
+	if err := tx.Bucket([]byte(DBBucketLeaseName)).Delete(lease.key()); err != nil {
+		return fmt.Errorf("delete lease: %w", err)
+	}
+
+	if err := tx.Bucket([]byte(DBBucketIndexName)).Delete(expiryIndexKey(lease.ExpiresAt, string(lease.key()))); err != nil {
+		return fmt.Errorf("delete index: %w", err)
+	}
+
+	if lease.LinkID != "" {
+		if err := tx.Bucket([]byte(DBBucketLinkName)).Delete([]byte(lease.LinkID)); err != nil {
+			return fmt.Errorf("delete link: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (app *App) loadLease(tx *bbolt.Tx, key []byte) (Lease, error) {
+	var lease Lease
+
+	data := tx.Bucket([]byte(DBBucketLeaseName)).Get(key)
+	if data == nil {
+		return lease, ErrFileNotFound
+	}
+
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return lease, fmt.Errorf("decode lease: %w", err)
+	}
+
+	return lease, nil
+}
+
+// loadLink finds the lease a share link belongs to.
+func (app *App) loadLink(tx *bbolt.Tx, linkID string) (Lease, error) {
+	key := tx.Bucket([]byte(DBBucketLinkName)).Get([]byte(linkID))
+	if key == nil {
+		return Lease{}, ErrFileNotFound
+	}
+
+	return app.loadLease(tx, key)
+}
+
+func (app *App) loadLeases(tx *bbolt.Tx, id string) ([]Lease, error) {
+	var leases []Lease
+
+	prefix := leasePrefix(id)
+	c := tx.Bucket([]byte(DBBucketLeaseName)).Cursor()
+
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		var lease Lease
+		if err := json.Unmarshal(v, &lease); err != nil {
+			return nil, fmt.Errorf("decode lease %s: %w", k, err)
+		}
+		leases = append(leases, lease)
+	}
+
+	return leases, nil
+}
+
+// revokeLease drops a lease. When it was the file's last, the file goes with
+// it and the ID of its blob is returned for deletion after the transaction.
+func (app *App) revokeLease(tx *bbolt.Tx, lease Lease) (string, error) {
+	if err := deleteLease(tx, lease); err != nil {
+		return "", err
+	}
+
+	meta, err := app.loadMeta(tx, lease.ID)
+	if err != nil {
+		return "", err
+	}
+
+	remaining, err := app.loadLeases(tx, lease.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(remaining) == 0 {
+		return app.removeFile(tx, meta)
+	}
+
+	meta.ExpiresAt = remaining[0].ExpiresAt
+	for _, l := range remaining[1:] {
+		if l.ExpiresAt.After(meta.ExpiresAt) {
+			meta.ExpiresAt = l.ExpiresAt
+		}
+	}
+
+	return "", app.saveMeta(tx, meta)
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidExpiry       = errors.New("invalid expiry")
+	ErrInvalidMaxDownloads = errors.New("invalid download limit")
+	ErrInvalidFlag         = errors.New("invalid flag value")
+	ErrInvalidNote         = errors.New("invalid note")
+)
+
+type UploadOptions struct {
+	Expiry       time.Duration `json:"expiry,omitempty"`
+	MaxDownloads int           `json:"max_downloads,omitempty"`
+	E2E          bool          `json:"e2e,omitempty"`
+	Private      bool          `json:"private,omitempty"`
+	Note         string        `json:"note,omitempty"`
+}
+
+// Convergent reports whether the upload is keyed by its content and shares a
+// blob with identical uploads. Budgeted uploads are not: the budget belongs to
+// the file, so other uploaders would spend one another's downloads.
+func (opts UploadOptions) Convergent() bool {
+	return !opts.E2E && !opts.Private && opts.MaxDownloads == 0
+}
+
+type UploadResult struct {
+	Key           []byte
+	ID            string
+	Filename      string
+	Size          int64
+	DeleteToken   string
+	ExpiresAt     time.Time
+	DownloadsLeft int
+	Dedup         bool
+}
+
+func parseUploadOptions(request *http.Request, form func(string) string) (UploadOptions, error) {
+	var opts UploadOptions
+
+	if raw := optionValue(request, form, ExpiresField, ExpiresHeader); raw != "" {
+		expiry, err := parseExpiry(raw, time.Now())
+		if err != nil {
+			return opts, err
+		}
+		opts.Expiry = expiry
+	}
+
+	if raw := optionValue(request, form, MaxDownloadsField, MaxDownloadsHeader); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > MaxDownloadLimit {
+			return opts, fmt.Errorf("%w: %q", ErrInvalidMaxDownloads, raw)
+		}
+		opts.MaxDownloads = n
+	}
+
+	var err error
+	if opts.E2E, err = flagOption(request, form, E2EField, E2EHeader); err != nil {
+		return opts, err
+	}
+	if opts.Private, err = flagOption(request, form, PrivateField, PrivateHeader); err != nil {
+		return opts, err
+	}
+
+	opts.Note = optionValue(request, form, NoteField, NoteHeader)
+	if len(opts.Note) > MaxNoteLength || !utf8.ValidString(opts.Note) {
+		return opts, fmt.Errorf("%w: %d bytes", ErrInvalidNote, len(opts.Note))
+	}
+
+	return opts, nil
+}
+
+func flagOption(request *http.Request, form func(string) string, field, header string) (bool, error) {
+	raw := optionValue(request, form, field, header)
+	if raw == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%w: %q", ErrInvalidFlag, raw)
+	}
+	return enabled, nil
+}
+
+func optionValue(request *http.Request, form func(string) string, field, header string) string {
+	if form != nil {
+		if v := strings.TrimSpace(form(field)); v != "" {
+			return v
+		}
+	}
+	return strings.TrimSpace(request.Header.Get(header))
+}
+
+func parseExpiry(raw string, now time.Time) (time.Duration, error) {
+	var expiry time.Duration
+
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidExpiry, raw)
+		}
+		expiry = time.Duration(n) * 24 * time.Hour
+	} else if d, err := time.ParseDuration(raw); err == nil {
+		expiry = d
+	} else if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		expiry = t.Sub(now)
+	} else {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidExpiry, raw)
+	}
+
+	if expiry <= 0 {
+		return 0, fmt.Errorf("%w: %q is not in the future", ErrInvalidExpiry, raw)
+	}
+
+	return expiry, nil
+}
+
+func clampExpiry(requested time.Duration, fileSize, maxMB int64) time.Duration {
+	limit := CalculateRetention(fileSize, maxMB)
+
+	if requested <= 0 || requested > limit {
+		return limit
+	}
+
+	if requested < MinRetention {
+		return MinRetention
+	}
+
+	return requested
+}
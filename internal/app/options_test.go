@@ -0,0 +1,130 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"1h", time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"2025-01-03T00:00:00Z", 48 * time.Hour, false},
+		{"0d", 0, true},
+		{"-1h", 0, true},
+		{"2024-12-31T00:00:00Z", 0, true},
+		{"tomorrow", 0, true},
+		{"xd", 0, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.raw, func(t *testing.T) {
+			got, err := parseExpiry(tc.raw, now)
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalidExpiry) {
+					t.Fatalf("Expected ErrInvalidExpiry, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseExpiry failed: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestClampExpiry(t *testing.T) {
+	maxMB := int64(100)
+	small := int64(1024)
+	large := 100 * int64(MegaByte)
+
+	if got := clampExpiry(0, small, maxMB); got != CalculateRetention(small, maxMB) {
+		t.Errorf("Default expiry should follow size policy, got %v", got)
+	}
+	if got := clampExpiry(time.Hour, small, maxMB); got != MinRetention {
+		t.Errorf("Short expiry should clamp to MinRetention, got %v", got)
+	}
+	if got := clampExpiry(48*time.Hour, small, maxMB); got != 48*time.Hour {
+		t.Errorf("In-range expiry should be kept, got %v", got)
+	}
+	if got := clampExpiry(MaxRetention*2, small, maxMB); got != CalculateRetention(small, maxMB) {
+		t.Errorf("Long expiry should clamp to size policy, got %v", got)
+	}
+	if got := clampExpiry(30*24*time.Hour, large, maxMB); got != CalculateRetention(large, maxMB) {
+		t.Errorf("Large file expiry should clamp to size policy, got %v", got)
+	}
+}
+
+func TestIntegration_ClientExpiry(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	resp := uploadFile(t, server.URL, "build.log", []byte("log line"), map[string]string{
+		ExpiresHeader: "3d",
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload failed status: %d", resp.StatusCode)
+	}
+	slugFromResponse(t, resp)
+
+	expiresAt, err := time.Parse(time.RFC3339, resp.Header.Get(ExpiresHeader))
+	if err != nil {
+		t.Fatalf("Response missing effective expiry: %v", err)
+	}
+	if d := time.Until(expiresAt); d < 71*time.Hour || d > 73*time.Hour {
+		t.Errorf("Expected ~72h expiry, got %v", d)
+	}
+
+	resp = uploadFile(t, server.URL, "bad.log", []byte("other"), map[string]string{
+		ExpiresHeader: "soon",
+	})
+	slugFromResponse(t, resp)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Invalid expiry: want 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_ChunkedExpiryNeverShortensDedup(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("deduplicated payload")
+
+	first := uploadFile(t, server.URL, "a.txt", content, nil)
+	slugFromResponse(t, first)
+	longExpiry := first.Header.Get(ExpiresHeader)
+
+	uid := startUpload(t, server.URL, map[string]string{
+		"filename": "a.txt",
+		"size":     fmt.Sprint(len(content)),
+		"expires":  fmt.Sprintf("%dh", 25),
+	})
+	uploadChunk(t, server.URL, uid, 0, content)
+	resp := postForm(t, server.URL+"/upload/finish", map[string]string{
+		"upload_id": uid,
+	})
+	slugFromResponse(t, resp)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Finish failed: %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get(ExpiresHeader); got != longExpiry {
+		t.Errorf("Dedup upload shortened expiry: want %s, got %s", longExpiry, got)
+	}
+}
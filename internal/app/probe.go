@@ -0,0 +1,365 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/skidoodle/safebin/internal/crypto"
+	"github.com/skidoodle/safebin/internal/store"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	ErrProbeNotFound = errors.New("probe challenge not found")
+	ErrInvalidProof  = errors.New("invalid proof of possession")
+)
+
+// ProbeRange is a span of plaintext the client must hash to prove it holds
+// the file it claims to be uploading.
+type ProbeRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+type ProbeResponse struct {
+	Present   bool         `json:"present"`
+	Challenge string       `json:"challenge,omitempty"`
+	Ranges    []ProbeRange `json:"ranges,omitempty"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+}
+
+// probeChallenge is the server side of an outstanding probe. It deliberately
+// holds the file ID rather than the digest, so the database never stores
+// anything that derives the file key.
+type probeChallenge struct {
+	ID        string        `json:"id"`
+	FileID    string        `json:"file_id"`
+	Filename  string        `json:"filename"`
+	Options   UploadOptions `json:"options"`
+	Size      int64         `json:"size"`
+	Ranges    []ProbeRange  `json:"ranges"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+// probesEnabled reports whether the probe endpoints are served. A probe tells
+// whoever holds a digest whether the content is stored, which is exactly what
+// a convergence secret is there to hide, so probes are off when one is set.
+func (app *App) probesEnabled() bool {
+	return len(app.Conf.Secret) == 0
+}
+
+func (app *App) HandleProbe(writer http.ResponseWriter, request *http.Request) {
+	if !app.probesEnabled() {
+		app.SendError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	digest, err := hex.DecodeString(request.FormValue("sha256"))
+	if err != nil || len(digest) != sha256.Size {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	size, err := strconv.ParseInt(request.FormValue("size"), 10, 64)
+	if err != nil || size < 0 {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	opts, err := parseUploadOptions(request, request.FormValue)
+	if err != nil || !opts.Convergent() {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	filename := request.FormValue("filename")
+	key := crypto.ConvergentKey(digest, app.Conf.Secret)
+	id := crypto.GetID(key, filepath.Ext(filename))
+
+	plainSize, err := app.storedPlainSize(id, key)
+	if err != nil || plainSize != size {
+		app.writeProbeResponse(writer, ProbeResponse{Present: false})
+		return
+	}
+
+	challengeID, err := newSessionID()
+	if err != nil {
+		app.Logger.Error("Failed to create probe challenge", "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	ranges, err := probeRanges(size)
+	if err != nil {
+		app.Logger.Error("Failed to choose probe ranges", "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	challenge := probeChallenge{
+		ID:        challengeID,
+		FileID:    id,
+		Filename:  filename,
+		Options:   opts,
+		Size:      size,
+		Ranges:    ranges,
+		ExpiresAt: time.Now().Add(ProbeExpiry),
+	}
+
+	if err := app.saveProbe(challenge); err != nil {
+		app.Logger.Error("Failed to save probe challenge", "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeProbeResponse(writer, ProbeResponse{
+		Present:   true,
+		Challenge: challenge.ID,
+		Ranges:    challenge.Ranges,
+		ExpiresAt: &challenge.ExpiresAt,
+	})
+}
+
+func (app *App) HandleProbeProof(writer http.ResponseWriter, request *http.Request) {
+	if !app.probesEnabled() {
+		app.SendError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	id := request.PathValue("id")
+	if !reUploadID.MatchString(id) {
+		app.SendError(writer, request, http.StatusNotFound)
+		return
+	}
+
+	digest, err := hex.DecodeString(request.FormValue("sha256"))
+	proof, proofErr := hex.DecodeString(request.FormValue("proof"))
+	if err != nil || proofErr != nil || len(digest) != sha256.Size {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := app.takeProbe(id)
+	if errors.Is(err, ErrProbeNotFound) {
+		app.SendError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.Logger.Error("Failed to load probe challenge", "id", id, "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	key := crypto.ConvergentKey(digest, app.Conf.Secret)
+	if crypto.GetID(key, filepath.Ext(challenge.Filename)) != challenge.FileID {
+		app.SendError(writer, request, http.StatusForbidden)
+		return
+	}
+
+	if err := app.verifyProof(challenge, key, proof); err != nil {
+		if errors.Is(err, ErrInvalidProof) {
+			app.SendError(writer, request, http.StatusForbidden)
+			return
+		}
+		app.Logger.Error("Failed to verify probe proof", "id", id, "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	result, err := app.registerUpload(challenge.FileID, key, challenge.Filename, nil, challenge.Options)
+	if err != nil {
+		app.Logger.Error("Failed to register probed upload", "id", id, "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+	result.Size, result.Dedup = challenge.Size, true
+
+	app.RespondWithLink(writer, request, result)
+}
+
+// storedPlainSize reports the plaintext length of a stored blob, failing when
+// the blob is absent or the key does not open it.
+func (app *App) storedPlainSize(id string, key []byte) (int64, error) {
+	body, closer, err := app.openBlob(id, key)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = closer.Close() }()
+
+	return body.Size(), nil
+}
+
+func (app *App) openBlob(id string, key []byte) (content, io.Closer, error) {
+	var meta FileMeta
+	err := app.DB.View(func(tx *bbolt.Tx) error {
+		var err error
+		meta, err = app.loadMeta(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var info FileInfo
+	if len(meta.Info) > 0 {
+		if info, err = openFileInfo(key, id, meta.Info); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	stat, err := app.Store.Stat(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blob, err := app.Store.Open(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decryptor, err := crypto.NewDecryptor(blob, key, stat.Size, []byte(id))
+	if err == nil {
+		var body content
+		if body, err = decodeContent(decryptor, info.Encoding); err == nil {
+			return body, blob, nil
+		}
+	}
+
+	_ = blob.Close()
+	return nil, nil, err
+}
+
+// verifyProof checks a proof against the stored content. Content that is not
+// stored, or not at the claimed size, fails like a wrong proof.
+func (app *App) verifyProof(challenge probeChallenge, key, proof []byte) error {
+	body, closer, err := app.openBlob(challenge.FileID, key)
+	if errors.Is(err, ErrFileNotFound) || errors.Is(err, store.ErrNotExist) {
+		return ErrInvalidProof
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = closer.Close() }()
+
+	if body.Size() != challenge.Size {
+		return ErrInvalidProof
+	}
+
+	hasher := sha256.New()
+	for _, r := range challenge.Ranges {
+		if _, err := io.Copy(hasher, io.NewSectionReader(body, r.Offset, r.Length)); err != nil {
+			return fmt.Errorf("read probe range: %w", err)
+		}
+	}
+
+	if subtle.ConstantTimeCompare(hasher.Sum(nil), proof) != 1 {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// probeRanges picks the spans a client has to hash. Small files are covered
+// whole; larger ones are sampled at unpredictable offsets.
+func probeRanges(size int64) ([]ProbeRange, error) {
+	if size <= ProbeRangeCount*ProbeRangeLength {
+		return []ProbeRange{{Offset: 0, Length: size}}, nil
+	}
+
+	ranges := make([]ProbeRange, ProbeRangeCount)
+	span := uint64(size - ProbeRangeLength + 1)
+	raw := make([]byte, 8)
+
+	for i := range ranges {
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		offset := int64(binary.BigEndian.Uint64(raw) % span)
+		ranges[i] = ProbeRange{Offset: offset, Length: ProbeRangeLength}
+	}
+
+	return ranges, nil
+}
+
+func (app *App) saveProbe(challenge probeChallenge) error {
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return err
+	}
+
+	return app.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(DBBucketProbeName)).Put([]byte(challenge.ID), data)
+	})
+}
+
+// takeProbe loads and deletes a challenge in one step, so every challenge can
+// be answered at most once.
+func (app *App) takeProbe(id string) (probeChallenge, error) {
+	var challenge probeChallenge
+
+	err := app.DB.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(DBBucketProbeName))
+
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrProbeNotFound
+		}
+		if err := json.Unmarshal(data, &challenge); err != nil {
+			return err
+		}
+		if time.Now().After(challenge.ExpiresAt) {
+			return ErrProbeNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+
+	return challenge, err
+}
+
+func (app *App) CleanProbes() {
+	err := app.DB.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(DBBucketProbeName))
+
+		var stale [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var challenge probeChallenge
+			if err := json.Unmarshal(v, &challenge); err != nil || time.Now().After(challenge.ExpiresAt) {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		app.Logger.Error("Failed to clean probe challenges", "err", err)
+	}
+}
+
+func (app *App) writeProbeResponse(writer http.ResponseWriter, response ProbeResponse) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+
+	if err := json.NewEncoder(writer).Encode(response); err != nil {
+		app.Logger.Error("Failed to write probe response", "err", err)
+	}
+}
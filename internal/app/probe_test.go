@@ -0,0 +1,157 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func probe(t *testing.T, baseURL string, fields map[string]string) (int, ProbeResponse) {
+	resp := postForm(t, baseURL+"/upload/probe", fields)
+	defer func() { _ = resp.Body.Close() }()
+
+	var result ProbeResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Decode probe response failed: %v", err)
+		}
+	}
+	return resp.StatusCode, result
+}
+
+func probeProof(content []byte, ranges []ProbeRange) string {
+	hasher := sha256.New()
+	for _, r := range ranges {
+		hasher.Write(content[r.Offset : r.Offset+r.Length])
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func TestIntegration_ProbeSkipsTransfer(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	fields := map[string]string{"sha256": digest, "size": fmt.Sprint(len(content)), "filename": "disk.iso"}
+	if code, absent := probe(t, server.URL, fields); code != http.StatusOK || absent.Present {
+		t.Fatalf("Probe before upload: %d %+v", code, absent)
+	}
+
+	slug := slugFromResponse(t, uploadFile(t, server.URL, "disk.iso", content, nil))
+
+	code, result := probe(t, server.URL, fields)
+	if code != http.StatusOK || !result.Present || len(result.Ranges) != ProbeRangeCount {
+		t.Fatalf("Probe after upload: %d %+v", code, result)
+	}
+
+	wrong := postForm(t, server.URL+"/upload/probe/"+result.Challenge, map[string]string{
+		"sha256": digest,
+		"proof":  hex.EncodeToString(make([]byte, sha256.Size)),
+	})
+	slugFromResponse(t, wrong)
+	if wrong.StatusCode != http.StatusForbidden {
+		t.Errorf("Wrong proof: want 403, got %d", wrong.StatusCode)
+	}
+
+	replay := postForm(t, server.URL+"/upload/probe/"+result.Challenge, map[string]string{
+		"sha256": digest,
+		"proof":  probeProof(content, result.Ranges),
+	})
+	slugFromResponse(t, replay)
+	if replay.StatusCode != http.StatusNotFound {
+		t.Errorf("Reused challenge: want 404, got %d", replay.StatusCode)
+	}
+
+	_, result = probe(t, server.URL, fields)
+	resp := postForm(t, server.URL+"/upload/probe/"+result.Challenge, map[string]string{
+		"sha256": digest,
+		"proof":  probeProof(content, result.Ranges),
+	})
+	token := resp.Header.Get(DeleteTokenHeader)
+	proved := slugFromResponse(t, resp)
+	if resp.StatusCode != http.StatusOK || proved == slug || token == "" {
+		t.Fatalf("Proof: %d slug %q token %q, want a link of its own", resp.StatusCode, proved, token)
+	}
+
+	id, _ := resolveLink(t, app, slug)
+	if other, _ := resolveLink(t, app, proved); other != id {
+		t.Errorf("Proof link opens %s, want %s", other, id)
+	}
+	if dl, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+proved, nil); dl.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("Download through the proof link: %d", dl.StatusCode)
+	}
+
+	err := app.DB.View(func(tx *bbolt.Tx) error {
+		leases, err := app.loadLeases(tx, id)
+		if len(leases) != 2 {
+			t.Errorf("Want 2 leases after probe, got %d", len(leases))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Load leases failed: %v", err)
+	}
+
+	if code, _ := probe(t, server.URL, map[string]string{"sha256": digest, "size": fmt.Sprint(len(content)), "private": "1"}); code != http.StatusBadRequest {
+		t.Errorf("Private probe: want 400, got %d", code)
+	}
+}
+
+func TestIntegration_ProbeDisabledWithSecret(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("stored before the secret was set")
+	sum := sha256.Sum256(content)
+	fields := map[string]string{"sha256": hex.EncodeToString(sum[:]), "size": fmt.Sprint(len(content))}
+
+	slugFromResponse(t, uploadFile(t, server.URL, "", content, nil))
+	_, result := probe(t, server.URL, fields)
+	if !result.Present {
+		t.Fatalf("Probe without a secret: %+v", result)
+	}
+
+	app.Conf.Secret = []byte("probe-test-secret-value")
+	if code, _ := probe(t, server.URL, fields); code != http.StatusNotFound {
+		t.Errorf("Probe with a secret: want 404, got %d", code)
+	}
+
+	proof := postForm(t, server.URL+"/upload/probe/"+result.Challenge, map[string]string{
+		"sha256": fields["sha256"],
+		"proof":  probeProof(content, result.Ranges),
+	})
+	slugFromResponse(t, proof)
+	if proof.StatusCode != http.StatusNotFound {
+		t.Errorf("Proof with a secret: want 404, got %d", proof.StatusCode)
+	}
+}
+
+func TestProbeRanges(t *testing.T) {
+	small, err := probeRanges(100)
+	if err != nil || len(small) != 1 || small[0] != (ProbeRange{Offset: 0, Length: 100}) {
+		t.Errorf("Small file ranges: %+v %v", small, err)
+	}
+
+	size := int64(ProbeRangeCount*ProbeRangeLength + 1)
+	ranges, err := probeRanges(size)
+	if err != nil || len(ranges) != ProbeRangeCount {
+		t.Fatalf("Large file ranges: %+v %v", ranges, err)
+	}
+	for _, r := range ranges {
+		if r.Offset < 0 || r.Offset+r.Length > size {
+			t.Errorf("Range %+v outside file of %d bytes", r, size)
+		}
+	}
+}
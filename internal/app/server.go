@@ -1,11 +1,13 @@
 package app
 
 import (
-	"encoding/base64"
 	"fmt"
 	"net/http"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/skidoodle/safebin/internal/slug"
 )
 
 func (app *App) Routes() *http.ServeMux {
@@ -14,9 +16,20 @@ func (app *App) Routes() *http.ServeMux {
 	mux.Handle("GET /static/", http.StripPrefix("/static/", app.handleStatic()))
 	mux.HandleFunc("GET /{$}", app.HandleHome)
 	mux.HandleFunc("POST /{$}", app.HandleUpload)
+	mux.HandleFunc("GET /api/openapi.json", app.HandleOpenAPI)
+	mux.HandleFunc("POST /upload/start", app.HandleStartUpload)
+	mux.HandleFunc("POST /upload/probe", app.HandleProbe)
+	mux.HandleFunc("POST /upload/probe/{id}", app.HandleProbeProof)
+	mux.HandleFunc("GET /upload/{id}", app.HandleUploadStatus)
 	mux.HandleFunc("POST /upload/chunk", app.HandleChunk)
 	mux.HandleFunc("POST /upload/finish", app.HandleFinish)
+	mux.Handle("OPTIONS /files/", app.tus(app.HandleTusOptions))
+	mux.Handle("POST /files/{$}", app.tus(app.HandleTusCreate))
+	mux.Handle("HEAD /files/{id}", app.tus(app.HandleTusHead))
+	mux.Handle("PATCH /files/{id}", app.tus(app.HandleTusPatch))
+	mux.Handle("DELETE /files/{id}", app.tus(app.HandleTusDelete))
 	mux.HandleFunc("GET /{slug}", app.HandleGetFile)
+	mux.HandleFunc("DELETE /{slug}", app.HandleDeleteFile)
 
 	return mux
 }
@@ -34,6 +47,7 @@ func (app *App) handleStatic() http.Handler {
 }
 
 func (app *App) HandleHome(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
 	err := app.Tmpl.ExecuteTemplate(writer, "layout", map[string]any{
 		"MaxMB":   app.Conf.MaxMB,
 		"Host":    request.Host,
@@ -45,19 +59,41 @@ func (app *App) HandleHome(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
-func (app *App) RespondWithLink(writer http.ResponseWriter, request *http.Request, key []byte, originalName string) {
-	keySlug := base64.RawURLEncoding.EncodeToString(key)
-	ext := filepath.Ext(originalName)
+func shareLink(request *http.Request, result UploadResult) string {
+	return request.Host + "/" + slug.Format(result.Key, result.Filename)
+}
 
-	const unsafeChars = "\"<> \\/:;?@[]^`{}|~"
-	safeExt := strings.Map(func(r rune) rune {
-		if strings.ContainsRune(unsafeChars, r) {
-			return -1
+func requestScheme(request *http.Request) string {
+	scheme := request.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "https"
+		if request.TLS == nil {
+			scheme = "http"
 		}
-		return r
-	}, ext)
+	}
+	return scheme
+}
 
-	link := fmt.Sprintf("%s/%s%s", request.Host, keySlug, safeExt)
+func setResultHeaders(writer http.ResponseWriter, result UploadResult) {
+	writer.Header().Set(ExpiresHeader, result.ExpiresAt.UTC().Format(time.RFC3339))
+	if result.DeleteToken != "" {
+		writer.Header().Set(DeleteTokenHeader, result.DeleteToken)
+	}
+	if result.DownloadsLeft > 0 {
+		writer.Header().Set(MaxDownloadsHeader, strconv.Itoa(result.DownloadsLeft))
+	}
+}
+
+func (app *App) RespondWithLink(writer http.ResponseWriter, request *http.Request, result UploadResult) {
+	link := shareLink(request, result)
+	expiresAt := result.ExpiresAt.UTC().Format(time.RFC3339)
+
+	setResultHeaders(writer, result)
+
+	if wantsJSON(request) {
+		app.writeUploadJSON(writer, request, result)
+		return
+	}
 
 	if request.Header.Get("X-Requested-With") == "XMLHttpRequest" {
 		html := `
@@ -67,31 +103,34 @@ func (app *App) RespondWithLink(writer http.ResponseWriter, request *http.Reques
 					<input type="text" value="%s" id="share-url" readonly onclick="this.select()">
 					<button onclick="copyToClipboard(this)">Copy</button>
 				</div>
+				<div class="dim result-label result-meta">Expires: %s</div>
+				<div class="dim result-label result-meta">Deletion token (keep it to remove the file early):</div>
+				<div class="copy-box">
+					<input type="text" value="%s" id="delete-token" readonly onclick="this.select()">
+					<button onclick="deleteFile(this)">Delete</button>
+				</div>
 				<div class="reset-wrapper">
 					<button class="reset-btn" onclick="resetUI()">Upload another</button>
 				</div>
 			</div>`
 
-		if _, err := fmt.Fprintf(writer, html, link); err != nil {
+		if _, err := fmt.Fprintf(writer, html, link, expiresAt, result.DeleteToken); err != nil {
 			app.Logger.Error("Failed to write response", "err", err)
 		}
 		return
 	}
 
-	scheme := request.Header.Get("X-Forwarded-Proto")
-	if scheme == "" {
-		scheme = "https"
-		if request.TLS == nil {
-			scheme = "http"
-		}
-	}
-
-	if _, err := fmt.Fprintf(writer, "%s://%s\n", scheme, link); err != nil {
+	if _, err := fmt.Fprintf(writer, "%s://%s\n", requestScheme(request), link); err != nil {
 		app.Logger.Error("Failed to write response", "err", err)
 	}
 }
 
 func (app *App) SendError(writer http.ResponseWriter, request *http.Request, code int) {
+	if wantsJSON(request) {
+		app.writeProblem(writer, code)
+		return
+	}
+
 	if request.Header.Get("X-Requested-With") == "XMLHttpRequest" {
 		writer.WriteHeader(code)
 
@@ -109,5 +148,12 @@ func (app *App) SendError(writer http.ResponseWriter, request *http.Request, cod
 		return
 	}
 
-	http.Error(writer, http.StatusText(code), code)
+	http.Error(writer, statusText(code), code)
+}
+
+func statusText(code int) string {
+	if code == StatusChecksumBad {
+		return "Checksum Mismatch"
+	}
+	return http.StatusText(code)
 }
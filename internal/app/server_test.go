@@ -2,22 +2,34 @@ package app
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"io"
 	"log/slog"
+	"math"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/skidoodle/safebin/internal/crypto"
+	"github.com/skidoodle/safebin/internal/store"
+	"go.etcd.io/bbolt"
 )
 
-func setupTestApp(t *testing.T) (*App, string) {
+func setupTestApp(t testing.TB) (*App, string) {
 	storageDir := t.TempDir()
 	if err := os.MkdirAll(filepath.Join(storageDir, TempDirName), 0700); err != nil {
 		t.Fatalf("Failed to create temp dir: %v", err)
@@ -34,6 +46,9 @@ func setupTestApp(t *testing.T) (*App, string) {
 	if err := os.WriteFile(filepath.Join(webDir, "home.html"), []byte(`{{define "content"}}OK{{end}}`), 0600); err != nil {
 		t.Fatalf("Failed to write home.html: %v", err)
 	}
+	if err := os.WriteFile(filepath.Join(webDir, "decrypt.html"), []byte(`{{define "decrypt"}}DECRYPT {{.Slug}}{{end}}`), 0600); err != nil {
+		t.Fatalf("Failed to write decrypt.html: %v", err)
+	}
 
 	testFS := os.DirFS(webDir)
 	tmpl := ParseTemplates(testFS)
@@ -57,6 +72,7 @@ func setupTestApp(t *testing.T) (*App, string) {
 		Tmpl:   tmpl,
 		Assets: testFS,
 		DB:     db,
+		Store:  store.NewFS(storageDir),
 	}
 
 	return app, storageDir
@@ -133,10 +149,15 @@ func TestIntegration_ChunkedUpload(t *testing.T) {
 	server := httptest.NewServer(app.Routes())
 	defer server.Close()
 
-	uploadID := "testchunkid123"
 	content := []byte("Chunk1Content-Chunk2Content")
-	chunk1 := content[:13]
-	chunk2 := content[13:]
+	chunk1 := content[:14]
+	chunk2 := content[14:]
+
+	uploadID := startUpload(t, server.URL, map[string]string{
+		"filename":   "chunked.txt",
+		"size":       fmt.Sprint(len(content)),
+		"chunk_size": "14",
+	})
 
 	uploadChunk(t, server.URL, uploadID, 0, chunk1)
 	uploadChunk(t, server.URL, uploadID, 1, chunk2)
@@ -144,8 +165,6 @@ func TestIntegration_ChunkedUpload(t *testing.T) {
 	finishURL := fmt.Sprintf("%s/upload/finish", server.URL)
 	form := map[string]string{
 		"upload_id": uploadID,
-		"total":     "2",
-		"filename":  "chunked.txt",
 	}
 
 	resp := postForm(t, finishURL, form)
@@ -184,8 +203,8 @@ func TestIntegration_ChunkedUpload_VerifyEncryption(t *testing.T) {
 	server := httptest.NewServer(app.Routes())
 	defer server.Close()
 
-	uploadID := "securechunk123"
 	plaintext := []byte("This is a secret message that should be encrypted")
+	uploadID := startUpload(t, server.URL, map[string]string{"size": fmt.Sprint(len(plaintext))})
 
 	uploadChunk(t, server.URL, uploadID, 0, plaintext)
 
@@ -206,14 +225,12 @@ func TestIntegration_ChunkedUpload_VerifyEncryption(t *testing.T) {
 	key := encryptedData[:crypto.KeySize]
 	ciphertext := encryptedData[crypto.KeySize:]
 
-	streamer, err := crypto.NewGCMStreamer(key)
+	r := bytes.NewReader(ciphertext)
+	d, err := crypto.NewDecryptor(r, key, int64(len(ciphertext)), chunkAD(uploadID, 0))
 	if err != nil {
-		t.Fatalf("Failed to create streamer: %v", err)
+		t.Fatalf("Failed to open chunk: %v", err)
 	}
 
-	r := bytes.NewReader(ciphertext)
-	d := crypto.NewDecryptor(r, streamer.AEAD, int64(len(ciphertext)))
-
 	decrypted, err := io.ReadAll(d)
 	if err != nil {
 		t.Fatalf("Failed to decrypt chunk: %v", err)
@@ -262,10 +279,7 @@ func TestIntegration_Upload_VerifyEncryption(t *testing.T) {
 	if len(slug) < SlugLength {
 		t.Fatalf("Invalid slug: %s", slug)
 	}
-	keyBase64 := slug[:SlugLength]
-	key, _ := base64.RawURLEncoding.DecodeString(keyBase64)
-	ext := filepath.Ext("secret.txt")
-	id := crypto.GetID(key, ext)
+	id, key := resolveLink(t, app, slug)
 
 	finalPath := filepath.Join(storageDir, id)
 	finalData, err := os.ReadFile(finalPath)
@@ -277,8 +291,10 @@ func TestIntegration_Upload_VerifyEncryption(t *testing.T) {
 		t.Fatal("Final file contains plaintext!")
 	}
 
-	streamer, _ := crypto.NewGCMStreamer(key)
-	d := crypto.NewDecryptor(bytes.NewReader(finalData), streamer.AEAD, int64(len(finalData)))
+	d, err := crypto.NewDecryptor(bytes.NewReader(finalData), key, int64(len(finalData)), []byte(id))
+	if err != nil {
+		t.Fatalf("Failed to open final file: %v", err)
+	}
 	decrypted, _ := io.ReadAll(d)
 
 	if !bytes.Equal(decrypted, plaintext) {
@@ -286,6 +302,25 @@ func TestIntegration_Upload_VerifyEncryption(t *testing.T) {
 	}
 }
 
+func startUpload(t *testing.T, baseURL string, fields map[string]string) string {
+	resp := postForm(t, baseURL+"/upload/start", fields)
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			t.Errorf("Failed to close start response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Start upload failed: %d", resp.StatusCode)
+	}
+
+	var status SessionStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("Decode session failed: %v", err)
+	}
+	return status.ID
+}
+
 func uploadChunk(t *testing.T, baseURL, uid string, idx int, data []byte) {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
@@ -337,3 +372,635 @@ func postForm(t *testing.T, url string, fields map[string]string) *http.Response
 	}
 	return resp
 }
+
+func uploadFile(t *testing.T, baseURL, filename string, content []byte, headers map[string]string) *http.Response {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("CreateFormFile failed: %v", err)
+	}
+	if _, err := part.Write(content); err != nil {
+		t.Fatalf("Write part failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Writer close failed: %v", err)
+	}
+
+	req, _ := http.NewRequest("POST", baseURL+"/", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	return resp
+}
+
+func slugFromResponse(t *testing.T, resp *http.Response) string {
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Read response failed: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Errorf("Failed to close response body: %v", err)
+	}
+	return filepath.Base(strings.TrimSpace(string(respBytes)))
+}
+
+// resolveLink returns the ID and key of the blob a share link opens.
+func resolveLink(t *testing.T, app *App, slug string) (string, []byte) {
+	t.Helper()
+
+	linkKey, ext, err := parseSlug(slug)
+	if err != nil {
+		t.Fatalf("parseSlug failed: %v", err)
+	}
+	linkID := crypto.GetID(linkKey, ext)
+
+	var lease Lease
+	if err := app.DB.View(func(tx *bbolt.Tx) error {
+		lease, err = app.loadLink(tx, linkID)
+		return err
+	}); err != nil {
+		t.Fatalf("Resolve link %s: %v", slug, err)
+	}
+
+	key, err := crypto.OpenMetadata(linkKey, lease.Key, []byte(linkID))
+	if err != nil {
+		t.Fatalf("Open file key: %v", err)
+	}
+	return lease.ID, key
+}
+
+func TestIntegration_E2EUploadServesOpaqueCiphertext(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	ciphertext := []byte("opaque-bytes-from-the-browser")
+
+	var slugs []string
+	for range 2 {
+		uid := startUpload(t, server.URL, map[string]string{
+			"size": fmt.Sprint(len(ciphertext)),
+			"e2e":  "1",
+		})
+		uploadChunk(t, server.URL, uid, 0, ciphertext)
+		resp := postForm(t, server.URL+"/upload/finish", map[string]string{
+			"upload_id": uid,
+		})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Finish failed: %d", resp.StatusCode)
+		}
+		slugs = append(slugs, slugFromResponse(t, resp))
+	}
+
+	first, _ := resolveLink(t, app, slugs[0])
+	second, _ := resolveLink(t, app, slugs[1])
+	if first == second {
+		t.Fatal("E2E uploads must not be deduplicated")
+	}
+
+	page, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slugs[0], map[string]string{
+		"Accept": "text/html,application/xhtml+xml",
+	})
+	if page.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "DECRYPT "+slugs[0]) {
+		t.Fatalf("Browser should get decrypt page, got %d %q", page.StatusCode, body)
+	}
+
+	raw, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slugs[0]+"?raw=1", map[string]string{
+		"Accept": "text/html",
+	})
+	if raw.StatusCode != http.StatusOK || !bytes.Equal(body, ciphertext) {
+		t.Fatalf("Raw download mismatch: %d %q", raw.StatusCode, body)
+	}
+	if ct := raw.Header.Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("E2E blob served as %q", ct)
+	}
+
+	curl, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slugs[1], nil)
+	if curl.StatusCode != http.StatusOK || !bytes.Equal(body, ciphertext) {
+		t.Fatalf("Non-browser client should receive ciphertext: %d %q", curl.StatusCode, body)
+	}
+}
+
+func TestIntegration_KeyedConvergence(t *testing.T) {
+	content := []byte("well-known document")
+	digest := sha256.Sum256(content)
+	publicSlug := base64.RawURLEncoding.EncodeToString(digest[:crypto.KeySize]) + ".txt"
+
+	app, _ := setupTestApp(t)
+	app.Conf.Secret = []byte("instance-secret-0123456789")
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	first := slugFromResponse(t, uploadFile(t, server.URL, "doc.txt", content, nil))
+	second := slugFromResponse(t, uploadFile(t, server.URL, "doc.txt", content, nil))
+
+	firstID, _ := resolveLink(t, app, first)
+	if secondID, _ := resolveLink(t, app, second); firstID != secondID {
+		t.Error("Keyed convergence should still deduplicate within an instance")
+	}
+	if first == publicSlug || firstID == crypto.GetID(digest[:crypto.KeySize], ".txt") {
+		t.Error("File key is computable from content alone")
+	}
+
+	resp, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+first, nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Fatalf("Download failed: %d", resp.StatusCode)
+	}
+
+	probe, _ := getWithHeaders(t, http.MethodGet, server.URL+"/"+publicSlug, nil)
+	if probe.StatusCode != http.StatusNotFound {
+		t.Errorf("Outsider confirmation probe: want 404, got %d", probe.StatusCode)
+	}
+}
+
+func TestIntegration_PrivateUploadSkipsDedup(t *testing.T) {
+	app, storageDir := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("db_password=hunter2")
+	digest := sha256.Sum256(content)
+	convergentID := crypto.GetID(crypto.ConvergentKey(digest[:], nil), ".env")
+
+	first := slugFromResponse(t, uploadFile(t, server.URL, "app.env", content, map[string]string{PrivateHeader: "1"}))
+	second := slugFromResponse(t, uploadFile(t, server.URL, "app.env", content, map[string]string{PrivateHeader: "true"}))
+
+	firstID, _ := resolveLink(t, app, first)
+	if secondID, _ := resolveLink(t, app, second); first == second || firstID == secondID {
+		t.Fatal("Private uploads must not share a blob")
+	}
+
+	for _, slug := range []string{first, second} {
+		if len(slug) != SlugLength+len(".env") || !strings.HasSuffix(slug, ".env") {
+			t.Errorf("Private link has unexpected shape: %s", slug)
+		}
+		resp, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil)
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+			t.Errorf("Private download failed: %d", resp.StatusCode)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(storageDir, convergentID)); !os.IsNotExist(err) {
+		t.Error("Private upload was stored under its convergent ID")
+	}
+
+	uid := startUpload(t, server.URL, map[string]string{
+		"filename": "app.env",
+		"size":     fmt.Sprint(len(content)),
+		"private":  "1",
+	})
+	uploadChunk(t, server.URL, uid, 0, content)
+	resp := postForm(t, server.URL+"/upload/finish", map[string]string{
+		"upload_id": uid,
+	})
+	if chunked, _ := resolveLink(t, app, slugFromResponse(t, resp)); chunked == firstID {
+		t.Error("Private chunked upload reused an existing blob")
+	}
+}
+
+func TestIntegration_MemoryStore(t *testing.T) {
+	app, storageDir := setupTestApp(t)
+	app.Store = store.NewMemory()
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("kept in memory")
+	slug := slugFromResponse(t, uploadFile(t, server.URL, "mem.txt", content, nil))
+
+	uid := startUpload(t, server.URL, map[string]string{
+		"filename":   "chunks.txt",
+		"size":       "14",
+		"chunk_size": "8",
+	})
+	uploadChunk(t, server.URL, uid, 0, []byte("chunked "))
+	uploadChunk(t, server.URL, uid, 1, []byte("memory"))
+	chunked := slugFromResponse(t, postForm(t, server.URL+"/upload/finish", map[string]string{
+		"upload_id": uid,
+	}))
+
+	for slug, want := range map[string]string{slug: string(content), chunked: "chunked memory"} {
+		resp, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil)
+		if resp.StatusCode != http.StatusOK || string(body) != want {
+			t.Errorf("Download %s: status %d, body %q", slug, resp.StatusCode, body)
+		}
+	}
+
+	if leftovers, _ := app.Store.List(TempDirName + "/"); len(leftovers) != 0 {
+		t.Errorf("Chunks not removed after finish: %+v", leftovers)
+	}
+
+	entries, err := os.ReadDir(storageDir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			t.Errorf("Blob written to disk despite memory store: %s", entry.Name())
+		}
+	}
+}
+
+// apiSpec is the embedded OpenAPI document, decoded loosely enough to walk.
+// It records which operations a test exercised.
+type apiSpec struct {
+	root map[string]any
+	seen map[string]bool
+}
+
+func loadSpec(t *testing.T) *apiSpec {
+	var root map[string]any
+	if err := json.Unmarshal(OpenAPISpec, &root); err != nil {
+		t.Fatalf("OpenAPI document is not JSON: %v", err)
+	}
+	return &apiSpec{root: root, seen: map[string]bool{}}
+}
+
+// node follows $ref pointers until it reaches an inline object.
+func (s *apiSpec) node(value any) map[string]any {
+	obj, _ := value.(map[string]any)
+	for obj != nil {
+		ref, ok := obj["$ref"].(string)
+		if !ok {
+			return obj
+		}
+
+		var target any = s.root
+		for part := range strings.SplitSeq(strings.TrimPrefix(ref, "#/"), "/") {
+			parent, _ := target.(map[string]any)
+			target = parent[part]
+		}
+		obj, _ = target.(map[string]any)
+	}
+	return nil
+}
+
+// operations lists the documented operations as "METHOD /path".
+func (s *apiSpec) operations() []string {
+	var ops []string
+	for path, item := range s.node(s.root["paths"]) {
+		for method := range s.node(item) {
+			ops = append(ops, strings.ToUpper(method)+" "+path)
+		}
+	}
+	slices.Sort(ops)
+	return ops
+}
+
+func (s *apiSpec) operation(op string) map[string]any {
+	method, path, _ := strings.Cut(op, " ")
+	return s.node(s.node(s.node(s.root["paths"])[path])[strings.ToLower(method)])
+}
+
+// send performs request and holds the response to the documented operation:
+// the status must be listed, required headers present, any body in a listed
+// media type and JSON bodies shaped like their schema.
+func (s *apiSpec) send(t *testing.T, op string, request *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	s.seen[op] = true
+
+	operation := s.operation(op)
+	if operation == nil {
+		t.Fatalf("%s is not documented", op)
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("%s failed: %v", op, err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatalf("%s: read body failed: %v", op, err)
+	}
+
+	documented := s.node(s.node(operation["responses"])[strconv.Itoa(resp.StatusCode)])
+	if documented == nil {
+		t.Errorf("%s: status %d is not documented", op, resp.StatusCode)
+		return resp, body
+	}
+
+	for name, header := range s.node(documented["headers"]) {
+		if required, _ := s.node(header)["required"].(bool); required && resp.Header.Get(name) == "" {
+			t.Errorf("%s %d: documented header %s missing", op, resp.StatusCode, name)
+		}
+	}
+
+	if len(body) == 0 {
+		return resp, body
+	}
+
+	content := s.node(documented["content"])
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	media, ok := content[mediaType]
+	if !ok {
+		media, ok = content["*/*"]
+	}
+	if !ok {
+		t.Errorf("%s %d: body of type %q is not documented", op, resp.StatusCode, mediaType)
+		return resp, body
+	}
+
+	if strings.HasSuffix(mediaType, "json") {
+		var value any
+		if err := json.Unmarshal(body, &value); err != nil {
+			t.Errorf("%s %d: invalid JSON body: %v", op, resp.StatusCode, err)
+			return resp, body
+		}
+		s.validate(t, fmt.Sprintf("%s %d", op, resp.StatusCode), s.node(media)["schema"], value)
+	}
+	return resp, body
+}
+
+// validate checks value against the subset of JSON Schema the document uses.
+// Object properties missing from the schema are reported too, so fields
+// cannot be added to a response without documenting them.
+func (s *apiSpec) validate(t *testing.T, where string, schemaValue, value any) {
+	t.Helper()
+
+	schema := s.node(schemaValue)
+	if schema == nil {
+		return
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			t.Errorf("%s: want object, got %T", where, value)
+			return
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				t.Errorf("%s: required property %q missing", where, name)
+			}
+		}
+		properties := s.node(schema["properties"])
+		for name, item := range obj {
+			if property, ok := properties[name]; ok {
+				s.validate(t, where+"."+name, property, item)
+			} else if properties != nil {
+				t.Errorf("%s: property %q is not documented", where, name)
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			t.Errorf("%s: want array, got %T", where, value)
+			return
+		}
+		for i, item := range items {
+			s.validate(t, fmt.Sprintf("%s[%d]", where, i), schema["items"], item)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			t.Errorf("%s: want string, got %T", where, value)
+		} else if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				t.Errorf("%s: %q is not a date-time", where, str)
+			}
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != math.Trunc(number) {
+			t.Errorf("%s: want integer, got %v", where, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			t.Errorf("%s: want boolean, got %T", where, value)
+		}
+	}
+}
+
+// routePatterns reads the patterns registered in Routes from server.go, so a
+// new route cannot be added without documenting it.
+func routePatterns(t *testing.T) []string {
+	file, err := parser.ParseFile(token.NewFileSet(), "server.go", nil, 0)
+	if err != nil {
+		t.Fatalf("Parse server.go failed: %v", err)
+	}
+
+	var patterns []string
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok || len(call.Args) != 2 {
+			return true
+		}
+		selector, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || (selector.Sel.Name != "Handle" && selector.Sel.Name != "HandleFunc") {
+			return true
+		}
+		if literal, ok := call.Args[0].(*ast.BasicLit); ok && literal.Kind == token.STRING {
+			pattern, _ := strconv.Unquote(literal.Value)
+			patterns = append(patterns, pattern)
+		}
+		return true
+	})
+	return patterns
+}
+
+// specOperation names the documented operation for a ServeMux pattern.
+func specOperation(pattern string) string {
+	if pattern == "GET /static/" {
+		return "GET /static/{file}"
+	}
+	return strings.TrimSuffix(pattern, "{$}")
+}
+
+func TestOpenAPIDocumentsRoutes(t *testing.T) {
+	spec := loadSpec(t)
+	if version, _ := spec.root["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		t.Errorf("openapi = %q, want 3.x", version)
+	}
+
+	patterns := routePatterns(t)
+	if len(patterns) == 0 {
+		t.Fatal("No routes found in server.go")
+	}
+
+	routed := make(map[string]bool)
+	for _, pattern := range patterns {
+		op := specOperation(pattern)
+		routed[op] = true
+		if spec.operation(op) == nil {
+			t.Errorf("Route %q is not documented as %s", pattern, op)
+		}
+	}
+	for _, op := range spec.operations() {
+		if !routed[op] {
+			t.Errorf("%s is documented but not routed", op)
+		}
+	}
+
+	var walk func(value any)
+	walk = func(value any) {
+		switch v := value.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok && spec.node(v) == nil {
+				t.Errorf("Dangling $ref %q", ref)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(spec.root)
+}
+
+func newRequest(t *testing.T, method, url string, body io.Reader, headers map[string]string) *http.Request {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return req
+}
+
+// formRequest builds a multipart POST of fields and, when name is set, one
+// file part.
+func formRequest(t *testing.T, url string, fields map[string]string, name string, content []byte, headers map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for k, v := range fields {
+		if err := writer.WriteField(k, v); err != nil {
+			t.Fatalf("WriteField %s failed: %v", k, err)
+		}
+	}
+	if name != "" {
+		part, err := writer.CreateFormFile(name, "notes.txt")
+		if err != nil {
+			t.Fatalf("CreateFormFile failed: %v", err)
+		}
+		if _, err := part.Write(content); err != nil {
+			t.Fatalf("Write part failed: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Writer close failed: %v", err)
+	}
+
+	req := newRequest(t, http.MethodPost, url, body, headers)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestIntegration_HandlersMatchOpenAPI(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	spec := loadSpec(t)
+	base := server.URL
+	asJSON := map[string]string{"Accept": JSONContentType}
+	asProblem := map[string]string{"Accept": ProblemContentType}
+	xhr := map[string]string{"X-Requested-With": "XMLHttpRequest"}
+
+	if _, body := spec.send(t, "GET /api/openapi.json", newRequest(t, http.MethodGet, base+"/api/openapi.json", nil, nil)); !bytes.Equal(body, OpenAPISpec) {
+		t.Error("Served OpenAPI document differs from the embedded one")
+	}
+	spec.send(t, "GET /", newRequest(t, http.MethodGet, base+"/", nil, nil))
+	spec.send(t, "GET /static/{file}", newRequest(t, http.MethodGet, base+"/static/missing.css", nil, nil))
+
+	content := []byte("described by the spec")
+	spec.send(t, "POST /", formRequest(t, base+"/", nil, "file", content, nil))
+	spec.send(t, "POST /", formRequest(t, base+"/", nil, "file", content, xhr))
+	spec.send(t, "POST /", formRequest(t, base+"/", map[string]string{"max_downloads": "0"}, "file", content, asProblem))
+	spec.send(t, "POST /", formRequest(t, base+"/", nil, "", nil, xhr))
+	_, body := spec.send(t, "POST /", formRequest(t, base+"/", map[string]string{"note": "spec"}, "file", content, asJSON))
+
+	var uploaded UploadResponse
+	if err := json.Unmarshal(body, &uploaded); err != nil {
+		t.Fatalf("Decode upload failed: %v", err)
+	}
+	file := base + "/" + uploaded.URL[strings.LastIndex(uploaded.URL, "/")+1:]
+
+	resp, _ := spec.send(t, "GET /{slug}", newRequest(t, http.MethodGet, file, nil, nil))
+	spec.send(t, "GET /{slug}", newRequest(t, http.MethodGet, file, nil, map[string]string{"Range": "bytes=2-5"}))
+	spec.send(t, "GET /{slug}", newRequest(t, http.MethodGet, file, nil, map[string]string{"Range": "bytes=4096-"}))
+	spec.send(t, "GET /{slug}", newRequest(t, http.MethodGet, file, nil, map[string]string{"If-None-Match": resp.Header.Get("ETag")}))
+	spec.send(t, "GET /{slug}", newRequest(t, http.MethodGet, base+"/short", nil, asProblem))
+	spec.send(t, "GET /{slug}", newRequest(t, http.MethodGet, base+"/"+strings.Repeat("A", SlugLength)+".txt", nil, nil))
+
+	spec.send(t, "DELETE /{slug}", newRequest(t, http.MethodDelete, file, nil, asProblem))
+	spec.send(t, "DELETE /{slug}", newRequest(t, http.MethodDelete, file, nil, map[string]string{DeleteTokenHeader: "wrong"}))
+
+	sum := sha256.Sum256(content)
+	_, body = spec.send(t, "POST /upload/probe", formRequest(t, base+"/upload/probe", map[string]string{
+		"sha256": fmt.Sprintf("%x", sum), "size": fmt.Sprint(len(content)), "filename": "notes.txt",
+	}, "", nil, nil))
+	spec.send(t, "POST /upload/probe", formRequest(t, base+"/upload/probe", map[string]string{"sha256": "zz"}, "", nil, asProblem))
+
+	var challenge ProbeResponse
+	if err := json.Unmarshal(body, &challenge); err != nil || !challenge.Present {
+		t.Fatalf("Probe: %s (%v)", body, err)
+	}
+	proofURL := base + "/upload/probe/" + challenge.Challenge
+	spec.send(t, "POST /upload/probe/{id}", formRequest(t, proofURL, map[string]string{
+		"sha256": fmt.Sprintf("%x", sum), "proof": fmt.Sprintf("%x", sha256.Sum256(nil)),
+	}, "", nil, nil))
+	spec.send(t, "POST /upload/probe/{id}", formRequest(t, base+"/upload/probe/unknownchallenge", map[string]string{
+		"sha256": fmt.Sprintf("%x", sum), "proof": fmt.Sprintf("%x", sha256.Sum256(nil)),
+	}, "", nil, nil))
+
+	spec.send(t, "DELETE /{slug}", newRequest(t, http.MethodDelete, file, nil, map[string]string{DeleteTokenHeader: uploaded.DeleteToken}))
+
+	_, body = spec.send(t, "POST /upload/start", formRequest(t, base+"/upload/start", map[string]string{
+		"size": fmt.Sprint(len(content)), "filename": "session.txt",
+	}, "", nil, nil))
+	spec.send(t, "POST /upload/start", formRequest(t, base+"/upload/start", map[string]string{"size": "-1"}, "", nil, nil))
+
+	var session SessionStatus
+	if err := json.Unmarshal(body, &session); err != nil {
+		t.Fatalf("Decode session failed: %v", err)
+	}
+	chunk := map[string]string{"upload_id": session.ID, "index": "0"}
+	spec.send(t, "POST /upload/chunk", formRequest(t, base+"/upload/chunk", chunk, "chunk", content, map[string]string{
+		"X-Chunk-SHA256": fmt.Sprintf("%x", sha256.Sum256(nil)),
+	}))
+	spec.send(t, "POST /upload/chunk", formRequest(t, base+"/upload/chunk", chunk, "chunk", content, nil))
+	spec.send(t, "GET /upload/{id}", newRequest(t, http.MethodGet, base+"/upload/"+session.ID, nil, nil))
+	spec.send(t, "GET /upload/{id}", newRequest(t, http.MethodGet, base+"/upload/unknownsession", nil, nil))
+	spec.send(t, "POST /upload/finish", formRequest(t, base+"/upload/finish", map[string]string{"upload_id": session.ID}, "", nil, asJSON))
+	spec.send(t, "POST /upload/finish", formRequest(t, base+"/upload/finish", map[string]string{"upload_id": session.ID}, "", nil, nil))
+
+	tus := map[string]string{"Tus-Resumable": TusVersion}
+	spec.send(t, "OPTIONS /files/", newRequest(t, http.MethodOptions, base+"/files/", nil, nil))
+	spec.send(t, "POST /files/", newRequest(t, http.MethodPost, base+"/files/", nil, map[string]string{"Upload-Length": "5"}))
+	spec.send(t, "POST /files/", newRequest(t, http.MethodPost, base+"/files/", nil, map[string]string{"Tus-Resumable": TusVersion, "Upload-Length": "x"}))
+
+	var locations []string
+	for range 2 {
+		resp, _ := spec.send(t, "POST /files/", newRequest(t, http.MethodPost, base+"/files/", nil, map[string]string{
+			"Tus-Resumable": TusVersion, "Upload-Length": fmt.Sprint(len(content)),
+		}))
+		locations = append(locations, base+resp.Header.Get("Location"))
+	}
+
+	spec.send(t, "HEAD /files/{id}", newRequest(t, http.MethodHead, locations[0], nil, tus))
+	spec.send(t, "PATCH /files/{id}", newRequest(t, http.MethodPatch, locations[0], bytes.NewReader(content), tus))
+	spec.send(t, "PATCH /files/{id}", newRequest(t, http.MethodPatch, locations[1], bytes.NewReader(content), map[string]string{
+		"Tus-Resumable": TusVersion, "Content-Type": "application/offset+octet-stream", "Upload-Offset": "0",
+	}))
+	spec.send(t, "HEAD /files/{id}", newRequest(t, http.MethodHead, locations[1], nil, tus))
+	spec.send(t, "DELETE /files/{id}", newRequest(t, http.MethodDelete, locations[0], nil, tus))
+	spec.send(t, "DELETE /files/{id}", newRequest(t, http.MethodDelete, locations[0], nil, tus))
+
+	for _, op := range spec.operations() {
+		if !spec.seen[op] {
+			t.Errorf("%s has no conformance request", op)
+		}
+	}
+}
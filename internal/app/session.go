@@ -0,0 +1,328 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	ErrSessionNotFound  = errors.New("upload session not found")
+	ErrInvalidChunkSize = errors.New("invalid chunk size")
+	ErrChunkOutOfRange  = errors.New("chunk index out of range")
+	ErrChunkLength      = errors.New("chunk length does not match session")
+	ErrUploadIncomplete = errors.New("upload incomplete")
+	ErrUploadTooLarge   = errors.New("upload exceeds the size limit")
+)
+
+type UploadSession struct {
+	ID        string        `json:"id"`
+	Filename  string        `json:"filename"`
+	Size      int64         `json:"size"`
+	ChunkSize int64         `json:"chunk_size"`
+	Options   UploadOptions `json:"options"`
+	Received  map[int]int64 `json:"received,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+type ChunkStatus struct {
+	Index int   `json:"index"`
+	Size  int64 `json:"size"`
+}
+
+type SessionStatus struct {
+	ID            string        `json:"id"`
+	Filename      string        `json:"filename"`
+	Size          int64         `json:"size"`
+	ChunkSize     int64         `json:"chunk_size"`
+	Total         int           `json:"total"`
+	Received      []ChunkStatus `json:"received"`
+	ReceivedBytes int64         `json:"received_bytes"`
+	ExpiresAt     time.Time     `json:"expires_at"`
+}
+
+// Total reports the number of chunks in the upload. Sessions with no fixed
+// chunk size grow one chunk per append, as tus PATCH requests do.
+func (s UploadSession) Total() int {
+	if s.appendOnly() {
+		return len(s.Received)
+	}
+	if s.Size == 0 {
+		return 1
+	}
+	return int((s.Size + s.ChunkSize - 1) / s.ChunkSize)
+}
+
+func (s UploadSession) appendOnly() bool {
+	return s.ChunkSize == 0
+}
+
+func (s UploadSession) receivedBytes() int64 {
+	var n int64
+	for _, size := range s.Received {
+		n += size
+	}
+	return n
+}
+
+func (s UploadSession) expectedChunkSize(idx int) int64 {
+	if idx < s.Total()-1 {
+		return s.ChunkSize
+	}
+	return s.Size - int64(s.Total()-1)*s.ChunkSize
+}
+
+func (s UploadSession) complete() bool {
+	if s.appendOnly() {
+		return s.receivedBytes() == s.Size
+	}
+	for i := range s.Total() {
+		if _, ok := s.Received[i]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s UploadSession) status() SessionStatus {
+	status := SessionStatus{
+		ID:        s.ID,
+		Filename:  s.Filename,
+		Size:      s.Size,
+		ChunkSize: s.ChunkSize,
+		Total:     s.Total(),
+		Received:  []ChunkStatus{},
+		ExpiresAt: s.UpdatedAt.Add(TempExpiry),
+	}
+
+	for idx, size := range s.Received {
+		status.Received = append(status.Received, ChunkStatus{Index: idx, Size: size})
+		status.ReceivedBytes += size
+	}
+	sort.Slice(status.Received, func(i, j int) bool { return status.Received[i].Index < status.Received[j].Index })
+
+	return status
+}
+
+func newSessionID() (string, error) {
+	raw := make([]byte, SessionIDLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate session id: %w", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func (app *App) newSession(filename string, size, chunkSize int64, opts UploadOptions) (UploadSession, error) {
+	if chunkSize == 0 {
+		chunkSize = UploadChunkSize
+		if opts.E2E {
+			chunkSize += E2EChunkOverhead
+		}
+	}
+
+	if chunkSize < 1 || chunkSize > UploadChunkSize+E2EChunkOverhead {
+		return UploadSession{}, fmt.Errorf("%w: %d", ErrInvalidChunkSize, chunkSize)
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	now := time.Now()
+	session := UploadSession{
+		ID:        id,
+		Filename:  filename,
+		Size:      size,
+		ChunkSize: chunkSize,
+		Options:   opts,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// Sessions are only persisted once they are known to be acceptable, so a
+	// rejected request leaves nothing to clean up.
+	quota := app.Conf.MaxMB * MegaByte
+	if opts.E2E {
+		quota += int64(session.Total()) * E2EChunkOverhead
+	}
+	if size > quota {
+		return UploadSession{}, fmt.Errorf("%w: %d bytes", ErrUploadTooLarge, size)
+	}
+
+	maxChunks := int((app.Conf.MaxMB*MegaByte)/MinChunkSize) + ChunkSafetyMargin
+	if session.Total() > maxChunks {
+		return UploadSession{}, fmt.Errorf("%w: %d chunks", ErrInvalidChunkSize, session.Total())
+	}
+
+	return session, app.saveSession(session)
+}
+
+func (app *App) saveSession(session UploadSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return app.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(DBBucketUploadName)).Put([]byte(session.ID), data)
+	})
+}
+
+func (app *App) loadSession(id string) (UploadSession, error) {
+	var session UploadSession
+
+	err := app.DB.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(DBBucketUploadName)).Get([]byte(id))
+		if data == nil {
+			return ErrSessionNotFound
+		}
+		return json.Unmarshal(data, &session)
+	})
+
+	return session, err
+}
+
+func (app *App) markChunkReceived(id string, idx int, size int64) error {
+	return app.DB.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(DBBucketUploadName))
+
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrSessionNotFound
+		}
+
+		var session UploadSession
+		if err := json.Unmarshal(data, &session); err != nil {
+			return err
+		}
+
+		if session.Received == nil {
+			session.Received = make(map[int]int64)
+		}
+		session.Received[idx] = size
+		session.UpdatedAt = time.Now()
+
+		updated, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), updated)
+	})
+}
+
+func (app *App) removeSession(id string) error {
+	if err := app.removeChunks(id); err != nil {
+		return err
+	}
+	app.uploadLocks.Delete(id)
+
+	return app.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(DBBucketUploadName)).Delete([]byte(id))
+	})
+}
+
+func (app *App) CleanSessions() {
+	var stale []string
+
+	err := app.DB.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(DBBucketUploadName)).ForEach(func(k, v []byte) error {
+			var session UploadSession
+			if err := json.Unmarshal(v, &session); err != nil || time.Since(session.UpdatedAt) > TempExpiry {
+				stale = append(stale, string(k))
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		app.Logger.Error("Failed to view upload sessions", "err", err)
+		return
+	}
+
+	for _, id := range stale {
+		if err := app.removeSession(id); err != nil {
+			app.Logger.Error("Failed to remove stale upload session", "id", id, "err", err)
+		}
+	}
+}
+
+func (app *App) HandleStartUpload(writer http.ResponseWriter, request *http.Request) {
+	size, err := strconv.ParseInt(request.FormValue("size"), 10, 64)
+	if err != nil || size < 0 {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	var chunkSize int64
+	if raw := request.FormValue("chunk_size"); raw != "" {
+		if chunkSize, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			app.SendError(writer, request, http.StatusBadRequest)
+			return
+		}
+	}
+
+	opts, err := parseUploadOptions(request, request.FormValue)
+	if err != nil {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	session, err := app.newSession(request.FormValue("filename"), size, chunkSize, opts)
+	if errors.Is(err, ErrUploadTooLarge) {
+		app.SendError(writer, request, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, ErrInvalidChunkSize) {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		app.Logger.Error("Failed to create upload session", "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Location", "/upload/"+session.ID)
+	app.writeSessionStatus(writer, http.StatusCreated, session)
+}
+
+func (app *App) HandleUploadStatus(writer http.ResponseWriter, request *http.Request) {
+	id := request.PathValue("id")
+	if !reUploadID.MatchString(id) {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	session, err := app.loadSession(id)
+	if errors.Is(err, ErrSessionNotFound) {
+		app.SendError(writer, request, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.Logger.Error("Failed to load upload session", "id", id, "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeSessionStatus(writer, http.StatusOK, session)
+}
+
+func (app *App) writeSessionStatus(writer http.ResponseWriter, code int, session UploadSession) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(code)
+
+	if err := json.NewEncoder(writer).Encode(session.status()); err != nil {
+		app.Logger.Error("Failed to write session status", "err", err)
+	}
+}
@@ -0,0 +1,227 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func uploadStatus(t *testing.T, baseURL, uid string) (int, SessionStatus) {
+	resp, body := getWithHeaders(t, http.MethodGet, baseURL+"/upload/"+uid, nil)
+
+	var status SessionStatus
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(body, &status); err != nil {
+			t.Fatalf("Decode status failed: %v", err)
+		}
+	}
+	return resp.StatusCode, status
+}
+
+func postChunk(t *testing.T, baseURL, uid string, idx int, data []byte, headers map[string]string) int {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	_ = writer.WriteField("upload_id", uid)
+	_ = writer.WriteField("index", fmt.Sprint(idx))
+	part, _ := writer.CreateFormFile("chunk", "blob")
+	_, _ = part.Write(data)
+	_ = writer.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, baseURL+"/upload/chunk", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Chunk request failed: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Errorf("Failed to close chunk response body: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestIntegration_ResumableSession(t *testing.T) {
+	app, storageDir := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("first-second-third")
+	uid := startUpload(t, server.URL, map[string]string{
+		"filename":   "resume.txt",
+		"size":       fmt.Sprint(len(content)),
+		"chunk_size": "7",
+	})
+
+	code, status := uploadStatus(t, server.URL, uid)
+	if code != http.StatusOK || status.Total != 3 || len(status.Received) != 0 {
+		t.Fatalf("Fresh session status: %d %+v", code, status)
+	}
+
+	uploadChunk(t, server.URL, uid, 2, content[14:])
+	uploadChunk(t, server.URL, uid, 0, content[:7])
+
+	if code := postChunk(t, server.URL, uid, 1, content[7:13], nil); code != http.StatusBadRequest {
+		t.Errorf("Short chunk: want 400, got %d", code)
+	}
+	if code := postChunk(t, server.URL, uid, 3, []byte("x"), nil); code != http.StatusBadRequest {
+		t.Errorf("Out of range chunk: want 400, got %d", code)
+	}
+
+	_, status = uploadStatus(t, server.URL, uid)
+	want := []ChunkStatus{{Index: 0, Size: 7}, {Index: 2, Size: 4}}
+	if fmt.Sprint(status.Received) != fmt.Sprint(want) || status.ReceivedBytes != 11 {
+		t.Errorf("Status after partial upload: %+v", status)
+	}
+
+	early := postForm(t, server.URL+"/upload/finish", map[string]string{"upload_id": uid})
+	slugFromResponse(t, early)
+	if early.StatusCode != http.StatusConflict {
+		t.Errorf("Finish with missing chunk: want 409, got %d", early.StatusCode)
+	}
+
+	uploadChunk(t, server.URL, uid, 1, content[7:14])
+
+	resp := postForm(t, server.URL+"/upload/finish", map[string]string{"upload_id": uid})
+	slug := slugFromResponse(t, resp)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Finish failed: %d", resp.StatusCode)
+	}
+
+	if dl, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil); dl.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("Resumed upload mismatch: %d %q", dl.StatusCode, body)
+	}
+
+	if code, _ := uploadStatus(t, server.URL, uid); code != http.StatusNotFound {
+		t.Errorf("Session should be gone after finish, got %d", code)
+	}
+
+	if _, err := os.Stat(filepath.Join(storageDir, TempDirName)); err != nil {
+		t.Errorf("Removing the chunks took the temp dir with them: %v", err)
+	}
+	if resp := uploadFile(t, server.URL, "after.txt", []byte("single request"), nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("Upload after a chunked finish: want 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_SessionRequired(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	if code := postChunk(t, server.URL, "clientchosen01", 0, []byte("data"), nil); code != http.StatusNotFound {
+		t.Errorf("Chunk without session: want 404, got %d", code)
+	}
+
+	resp := postForm(t, server.URL+"/upload/finish", map[string]string{"upload_id": "clientchosen01"})
+	slugFromResponse(t, resp)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Finish without session: want 404, got %d", resp.StatusCode)
+	}
+
+	tooBig := postForm(t, server.URL+"/upload/start", map[string]string{
+		"size": fmt.Sprint(app.Conf.MaxMB*MegaByte + 1),
+	})
+	slugFromResponse(t, tooBig)
+	if tooBig.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized session: want 413, got %d", tooBig.StatusCode)
+	}
+	if stats, err := app.Stats(); err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	} else if stats.Sessions != 0 {
+		t.Errorf("Rejected session was persisted: %d sessions", stats.Sessions)
+	}
+
+	badChunk := postForm(t, server.URL+"/upload/start", map[string]string{
+		"size":       "10",
+		"chunk_size": fmt.Sprint(UploadChunkSize * 2),
+	})
+	slugFromResponse(t, badChunk)
+	if badChunk.StatusCode != http.StatusBadRequest {
+		t.Errorf("Oversized chunk size: want 400, got %d", badChunk.StatusCode)
+	}
+}
+
+func TestIntegration_ChunkChecksum(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	content := []byte("checksummed-chunks")
+	uid := startUpload(t, server.URL, map[string]string{
+		"filename":   "sum.txt",
+		"size":       fmt.Sprint(len(content)),
+		"chunk_size": "9",
+	})
+
+	first, second := content[:9], content[9:]
+	firstSum := sha256.Sum256(first)
+	secondSum := sha256.Sum256(second)
+
+	wrong := map[string]string{ChunkDigestHeader: hex.EncodeToString(secondSum[:])}
+	if code := postChunk(t, server.URL, uid, 0, first, wrong); code != StatusChecksumBad {
+		t.Errorf("Mismatched digest: want %d, got %d", StatusChecksumBad, code)
+	}
+	if code := postChunk(t, server.URL, uid, 0, first, map[string]string{ChunkDigestHeader: "abc"}); code != http.StatusBadRequest {
+		t.Errorf("Malformed digest: want 400, got %d", code)
+	}
+
+	if _, status := uploadStatus(t, server.URL, uid); len(status.Received) != 0 {
+		t.Fatalf("Rejected chunk was recorded: %+v", status.Received)
+	}
+	if chunks, _ := app.Store.List(chunkPrefix(uid)); len(chunks) != 0 {
+		t.Errorf("Rejected chunk was committed: %+v", chunks)
+	}
+
+	if code := postChunk(t, server.URL, uid, 0, first, map[string]string{ChunkDigestHeader: hex.EncodeToString(firstSum[:])}); code != http.StatusOK {
+		t.Errorf("Hex digest: want 200, got %d", code)
+	}
+	digest := "sha-512=:AAAA:, sha-256=:" + base64.StdEncoding.EncodeToString(secondSum[:]) + ":"
+	if code := postChunk(t, server.URL, uid, 1, second, map[string]string{"Content-Digest": digest}); code != http.StatusOK {
+		t.Errorf("Content-Digest: want 200, got %d", code)
+	}
+
+	resp := postForm(t, server.URL+"/upload/finish", map[string]string{"upload_id": uid})
+	slug := slugFromResponse(t, resp)
+	if dl, body := getWithHeaders(t, http.MethodGet, server.URL+"/"+slug, nil); dl.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("Checksummed upload mismatch: %d %q", dl.StatusCode, body)
+	}
+}
+
+func TestCleanSessions_RemovesStale(t *testing.T) {
+	app, _ := setupTestApp(t)
+
+	session, err := app.newSession("stale.txt", 4, 0, UploadOptions{})
+	if err != nil {
+		t.Fatalf("newSession failed: %v", err)
+	}
+	if err := app.saveChunk(session.ID, 0, bytes.NewReader([]byte("data"))); err != nil {
+		t.Fatalf("saveChunk failed: %v", err)
+	}
+
+	session.UpdatedAt = time.Now().Add(-TempExpiry - time.Hour)
+	if err := app.saveSession(session); err != nil {
+		t.Fatalf("saveSession failed: %v", err)
+	}
+
+	app.CleanSessions()
+
+	if _, err := app.loadSession(session.ID); err == nil {
+		t.Error("Stale session survived cleanup")
+	}
+	if chunks, _ := app.Store.List(chunkPrefix(session.ID)); len(chunks) != 0 {
+		t.Errorf("Stale session chunks survived cleanup: %+v", chunks)
+	}
+}
@@ -3,16 +3,19 @@ package app
 import (
 	"context"
 	"crypto/rand"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/skidoodle/safebin/internal/crypto"
+	"github.com/skidoodle/safebin/internal/store"
 	"go.etcd.io/bbolt"
 )
 
@@ -25,27 +28,20 @@ func (app *App) StartCleanupTask(ctx context.Context) {
 			ticker.Stop()
 			return
 		case <-ticker.C:
-			app.CleanStorage()
-			app.CleanTemp(filepath.Join(app.Conf.StorageDir, TempDirName))
+			app.Cleanup()
 		}
 	}
 }
 
 func (app *App) saveChunk(uid string, idx int, src io.Reader) error {
-	dir := filepath.Join(app.Conf.StorageDir, TempDirName, uid)
-
-	if err := os.MkdirAll(dir, PermUserRWX); err != nil {
-		return fmt.Errorf("create chunk dir: %w", err)
-	}
-
-	dest, err := os.Create(filepath.Join(dir, strconv.Itoa(idx)))
+	dest, err := app.Store.Put(chunkName(uid, idx))
 	if err != nil {
-		return fmt.Errorf("create chunk file: %w", err)
+		return fmt.Errorf("create chunk: %w", err)
 	}
 
 	defer func() {
-		if closeErr := dest.Close(); closeErr != nil {
-			app.Logger.Error("Failed to close chunk dest", "err", closeErr)
+		if abortErr := dest.Abort(); abortErr != nil {
+			app.Logger.Error("Failed to discard chunk", "err", abortErr)
 		}
 	}()
 
@@ -63,16 +59,19 @@ func (app *App) saveChunk(uid string, idx int, src io.Reader) error {
 		return fmt.Errorf("create streamer: %w", err)
 	}
 
-	if err := streamer.EncryptStream(dest, src); err != nil {
+	if err := streamer.EncryptStream(dest, src, chunkAD(uid, idx)); err != nil {
 		return fmt.Errorf("encrypt chunk: %w", err)
 	}
 
+	if err := dest.Commit(); err != nil {
+		return fmt.Errorf("commit chunk: %w", err)
+	}
+
 	return nil
 }
 
 func (app *App) openChunkDecryptor(uid string, idx int) (io.ReadCloser, error) {
-	partPath := filepath.Join(app.Conf.StorageDir, TempDirName, uid, strconv.Itoa(idx))
-	f, err := os.Open(partPath)
+	f, err := app.Store.Open(chunkName(uid, idx))
 	if err != nil {
 		return nil, fmt.Errorf("open chunk %d: %w", idx, err)
 	}
@@ -83,37 +82,90 @@ func (app *App) openChunkDecryptor(uid string, idx int) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("read chunk key %d: %w", idx, err)
 	}
 
-	info, err := f.Stat()
+	size, err := f.Seek(0, io.SeekEnd)
 	if err != nil {
 		_ = f.Close()
-		return nil, fmt.Errorf("stat chunk %d: %w", idx, err)
+		return nil, fmt.Errorf("size chunk %d: %w", idx, err)
 	}
 
-	bodySize := info.Size() - int64(crypto.KeySize)
+	bodySize := size - int64(crypto.KeySize)
 	if bodySize < 0 {
 		_ = f.Close()
 		return nil, fmt.Errorf("invalid chunk size %d", idx)
 	}
 
-	bodyReader := io.NewSectionReader(f, int64(crypto.KeySize), bodySize)
+	bodyReader := &offsetReadSeeker{rs: f, base: int64(crypto.KeySize)}
 
-	streamer, err := crypto.NewGCMStreamer(key)
+	decryptor, err := crypto.NewDecryptor(bodyReader, key, bodySize, chunkAD(uid, idx), sequentialOptions()...)
 	if err != nil {
 		_ = f.Close()
-		return nil, fmt.Errorf("create streamer %d: %w", idx, err)
+		return nil, fmt.Errorf("open chunk decryptor %d: %w", idx, err)
 	}
 
-	decryptor := crypto.NewDecryptor(bodyReader, streamer.AEAD, bodySize)
-
 	return &chunkReadCloser{Decryptor: decryptor, f: f}, nil
 }
 
+func (app *App) removeChunks(uid string) error {
+	chunks, err := app.Store.List(chunkPrefix(uid))
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if err := app.Store.Delete(chunk.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sequentialOptions is for decryptors read front to back, which can decrypt
+// the next chunks on other cores while the current one is consumed.
+func sequentialOptions() []crypto.Option {
+	return []crypto.Option{
+		crypto.WithWorkers(runtime.GOMAXPROCS(0)),
+		crypto.WithReadahead(DecryptReadahead),
+	}
+}
+
+func chunkPrefix(uid string) string {
+	return TempDirName + "/" + uid + "/"
+}
+
+func chunkName(uid string, idx int) string {
+	return chunkPrefix(uid) + strconv.Itoa(idx)
+}
+
+func chunkAD(uid string, idx int) []byte {
+	return []byte(uid + "/" + strconv.Itoa(idx))
+}
+
+type offsetReadSeeker struct {
+	rs   io.ReadSeeker
+	base int64
+}
+
+func (o *offsetReadSeeker) Read(p []byte) (int, error) {
+	return o.rs.Read(p)
+}
+
+func (o *offsetReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekStart {
+		offset += o.base
+	}
+
+	abs, err := o.rs.Seek(offset, whence)
+	return abs - o.base, err
+}
+
 type chunkReadCloser struct {
 	*crypto.Decryptor
-	f *os.File
+	f io.Closer
 }
 
 func (c *chunkReadCloser) Close() error {
+	_ = c.Decryptor.Close()
 	return c.f.Close()
 }
 
@@ -159,79 +211,98 @@ func (s *SequentialChunkReader) Close() error {
 	return nil
 }
 
-func (app *App) encryptAndSave(src io.Reader, key []byte, finalPath string) error {
-	out, err := os.Create(finalPath + ".tmp")
+// stagedBlob is an upload encrypted under a random data key, held back until
+// its content-derived key and ID are known.
+type stagedBlob struct {
+	out      store.Writer
+	envelope *crypto.Envelope
+}
+
+func (app *App) stageBlob(src io.Reader) (*stagedBlob, error) {
+	name, err := newSessionID()
 	if err != nil {
-		return fmt.Errorf("create final file: %w", err)
+		return nil, err
 	}
 
-	var closed bool
-
-	defer func() {
-		if !closed {
-			if closeErr := out.Close(); closeErr != nil {
-				app.Logger.Error("Failed to close final file", "err", closeErr)
-			}
-		}
-
-		if removeErr := os.Remove(finalPath + ".tmp"); removeErr != nil && !os.IsNotExist(removeErr) {
-			app.Logger.Error("Failed to remove temp final file", "err", removeErr)
-		}
-	}()
-
-	streamer, err := crypto.NewGCMStreamer(key)
+	out, err := app.Store.Put(TempDirName + "/" + name)
 	if err != nil {
-		return fmt.Errorf("create streamer: %w", err)
+		return nil, fmt.Errorf("create staged blob: %w", err)
 	}
 
-	if err := streamer.EncryptStream(out, src); err != nil {
-		return fmt.Errorf("encrypt stream: %w", err)
+	envelope, err := crypto.NewEnvelope(crypto.WithWorkers(runtime.GOMAXPROCS(0)))
+	if err == nil {
+		err = envelope.EncryptStream(out, src)
 	}
-
-	if err := out.Close(); err != nil {
-		return fmt.Errorf("close final file: %w", err)
+	if err != nil {
+		_ = out.Abort()
+		return nil, fmt.Errorf("encrypt stream: %w", err)
 	}
 
-	closed = true
+	return &stagedBlob{out: out, envelope: envelope}, nil
+}
 
-	if err := os.Rename(finalPath+".tmp", finalPath); err != nil {
-		return fmt.Errorf("rename final file: %w", err)
+// commit wraps the data key under key and publishes the blob as id.
+func (b *stagedBlob) commit(key []byte, id string) error {
+	if err := b.envelope.Seal(b.out, key, []byte(id)); err != nil {
+		return err
 	}
+	return b.out.CommitAs(id)
+}
 
-	return nil
+func (b *stagedBlob) discard() error {
+	return b.out.Abort()
 }
 
-func (app *App) RegisterFile(id string, size int64) error {
-	retention := CalculateRetention(size, app.Conf.MaxMB)
+func (app *App) RegisterFile(id string, size int64, lease Lease, info []byte, opts UploadOptions) (FileMeta, Lease, error) {
+	now := time.Now()
+	retention := clampExpiry(opts.Expiry, size, app.Conf.MaxMB)
+
+	leaseID, err := newLeaseID()
+	if err != nil {
+		return FileMeta{}, Lease{}, err
+	}
+
+	lease.ID = id
+	lease.LeaseID = leaseID
+	lease.CreatedAt = now
+	lease.ExpiresAt = now.Add(retention)
+
 	meta := FileMeta{
-		ID:        id,
-		Size:      size,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(retention),
+		ID:            id,
+		Size:          size,
+		CreatedAt:     now,
+		ExpiresAt:     lease.ExpiresAt,
+		DownloadsLeft: opts.MaxDownloads,
+		E2E:           opts.E2E,
+		Info:          info,
 	}
 
-	return app.DB.Update(func(tx *bbolt.Tx) error {
-		bFiles := tx.Bucket([]byte(DBBucketName))
-		bIndex := tx.Bucket([]byte(DBBucketIndexName))
+	err = app.DB.Update(func(tx *bbolt.Tx) error {
+		if prev, err := app.loadMeta(tx, id); err == nil {
+			meta.CreatedAt = prev.CreatedAt
+			if len(prev.Info) > 0 {
+				meta.Info = prev.Info
+			}
 
-		data, err := json.Marshal(meta)
-		if err != nil {
-			return err
+			if prev.ExpiresAt.After(meta.ExpiresAt) {
+				meta.ExpiresAt = prev.ExpiresAt
+			}
 		}
 
-		if err := bFiles.Put([]byte(id), data); err != nil {
+		if err := putLease(tx, lease); err != nil {
 			return err
 		}
 
-		indexKey := []byte(meta.ExpiresAt.Format(time.RFC3339) + "_" + id)
-		return bIndex.Put(indexKey, []byte(id))
+		return app.saveMeta(tx, meta)
 	})
+
+	return meta, lease, err
 }
 
 func (app *App) CleanStorage() {
 	now := time.Now().Format(time.RFC3339)
-	var toDeleteIDs []string
-	var toDeleteKeys []string
+	var expired []string
+	var targets []string
 
 	err := app.DB.View(func(tx *bbolt.Tx) error {
 		bIndex := tx.Bucket([]byte(DBBucketIndexName))
@@ -245,8 +316,8 @@ func (app *App) CleanStorage() {
 				break
 			}
 
-			toDeleteKeys = append(toDeleteKeys, string(k))
-			toDeleteIDs = append(toDeleteIDs, string(v))
+			expired = append(expired, string(k))
+			targets = append(targets, string(v))
 		}
 		return nil
 	})
@@ -256,26 +327,23 @@ func (app *App) CleanStorage() {
 		return
 	}
 
-	if len(toDeleteIDs) == 0 {
+	if len(expired) == 0 {
 		return
 	}
 
+	var blobs []string
 	err = app.DB.Update(func(tx *bbolt.Tx) error {
-		bFiles := tx.Bucket([]byte(DBBucketName))
 		bIndex := tx.Bucket([]byte(DBBucketIndexName))
 
-		for i, id := range toDeleteIDs {
-			path := filepath.Join(app.Conf.StorageDir, id)
-			if err := os.RemoveAll(path); err != nil {
-				app.Logger.Error("Failed to remove expired file", "path", id, "err", err)
+		for i, target := range targets {
+			blob, err := app.expireTarget(tx, target)
+			if err != nil {
+				app.Logger.Error("Failed to expire lease", "lease", target, "err", err)
 			}
+			blobs = append(blobs, blob)
 
-			if err := bFiles.Delete([]byte(id)); err != nil {
-				app.Logger.Error("Failed to delete metadata", "id", id, "err", err)
-			}
-
-			if err := bIndex.Delete([]byte(toDeleteKeys[i])); err != nil {
-				app.Logger.Error("Failed to delete index", "key", toDeleteKeys[i], "err", err)
+			if err := bIndex.Delete([]byte(expired[i])); err != nil {
+				app.Logger.Error("Failed to delete index", "key", expired[i], "err", err)
 			}
 		}
 		return nil
@@ -283,7 +351,22 @@ func (app *App) CleanStorage() {
 
 	if err != nil {
 		app.Logger.Error("Failed to update DB during cleanup", "err", err)
+		return
 	}
+
+	app.deleteBlobs(blobs...)
+}
+
+func (app *App) expireTarget(tx *bbolt.Tx, target string) (string, error) {
+	lease, err := app.loadLease(tx, []byte(target))
+	if errors.Is(err, ErrFileNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return app.revokeLease(tx, lease)
 }
 
 func (app *App) CleanTemp(path string) {
@@ -307,6 +390,34 @@ func (app *App) CleanTemp(path string) {
 	}
 }
 
+func (app *App) CleanChunks() {
+	chunks, err := app.Store.List(TempDirName + "/")
+	if err != nil {
+		app.Logger.Error("Failed to list chunks", "err", err)
+		return
+	}
+
+	lastWrite := make(map[string]time.Time)
+	for _, chunk := range chunks {
+		uid, _, ok := strings.Cut(strings.TrimPrefix(chunk.Name, TempDirName+"/"), "/")
+		if !ok {
+			continue
+		}
+		if chunk.ModTime.After(lastWrite[uid]) {
+			lastWrite[uid] = chunk.ModTime
+		}
+	}
+
+	for uid, modTime := range lastWrite {
+		if time.Since(modTime) <= TempExpiry {
+			continue
+		}
+		if err := app.removeChunks(uid); err != nil {
+			app.Logger.Error("Failed to remove abandoned chunks", "uid", uid, "err", err)
+		}
+	}
+}
+
 func CalculateRetention(fileSize, maxMB int64) time.Duration {
 	ratio := math.Max(0, math.Min(1, float64(fileSize)/float64(maxMB*MegaByte)))
 
@@ -3,7 +3,9 @@ package app
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -11,6 +13,7 @@ import (
 	"time"
 
 	"github.com/skidoodle/safebin/internal/crypto"
+	"github.com/skidoodle/safebin/internal/store"
 	"go.etcd.io/bbolt"
 )
 
@@ -57,6 +60,36 @@ func TestCleanup_AbandonedChunks(t *testing.T) {
 	}
 }
 
+func TestCleanup_AbandonedStoreChunks(t *testing.T) {
+	tmpDir := t.TempDir()
+	app := &App{
+		Conf:   Config{StorageDir: tmpDir},
+		Logger: discardLogger(),
+		Store:  store.NewFS(tmpDir),
+	}
+
+	for _, uid := range []string{"abandoned_upload", "active_upload"} {
+		if err := app.saveChunk(uid, 0, bytes.NewReader([]byte("chunk data"))); err != nil {
+			t.Fatalf("saveChunk failed: %v", err)
+		}
+	}
+
+	oldTime := time.Now().Add(-TempExpiry - time.Hour)
+	oldChunk := filepath.Join(tmpDir, TempDirName, "abandoned_upload", "0")
+	if err := os.Chtimes(oldChunk, oldTime, oldTime); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	app.CleanChunks()
+
+	if _, err := os.Stat(filepath.Join(tmpDir, TempDirName, "abandoned_upload")); !os.IsNotExist(err) {
+		t.Error("Cleanup failed to remove abandoned chunks")
+	}
+	if _, err := app.Store.Stat(chunkName("active_upload", 0)); err != nil {
+		t.Errorf("Cleanup removed an active upload's chunk: %v", err)
+	}
+}
+
 func TestCleanup_ExpiredStorage(t *testing.T) {
 	storageDir := t.TempDir()
 	db, err := InitDB(storageDir)
@@ -76,6 +109,7 @@ func TestCleanup_ExpiredStorage(t *testing.T) {
 		},
 		Logger: discardLogger(),
 		DB:     db,
+		Store:  store.NewFS(storageDir),
 	}
 
 	filename := "large_file_id"
@@ -98,17 +132,22 @@ func TestCleanup_ExpiredStorage(t *testing.T) {
 		ExpiresAt: time.Now().Add(-time.Hour),
 	}
 
+	lease := Lease{
+		ID:        filename,
+		LeaseID:   "expired-lease",
+		CreatedAt: expiredMeta.CreatedAt,
+		ExpiresAt: expiredMeta.ExpiresAt,
+	}
+
 	if err := app.DB.Update(func(tx *bbolt.Tx) error {
 		bFiles := tx.Bucket([]byte(DBBucketName))
-		bIndex := tx.Bucket([]byte(DBBucketIndexName))
 
 		data, _ := json.Marshal(expiredMeta)
 		if err := bFiles.Put([]byte(filename), data); err != nil {
 			return err
 		}
 
-		indexKey := []byte(expiredMeta.ExpiresAt.Format(time.RFC3339) + "_" + filename)
-		return bIndex.Put(indexKey, []byte(filename))
+		return putLease(tx, lease)
 	}); err != nil {
 		t.Fatalf("DB Update failed: %v", err)
 	}
@@ -126,8 +165,7 @@ func TestCleanup_ExpiredStorage(t *testing.T) {
 		}
 
 		bIndex := tx.Bucket([]byte(DBBucketIndexName))
-		indexKey := []byte(expiredMeta.ExpiresAt.Format(time.RFC3339) + "_" + filename)
-		if v := bIndex.Get(indexKey); v != nil {
+		if v := bIndex.Get(expiryIndexKey(lease.ExpiresAt, string(lease.key()))); v != nil {
 			t.Error("Cleanup failed to remove index entry")
 		}
 		return nil
@@ -136,11 +174,84 @@ func TestCleanup_ExpiredStorage(t *testing.T) {
 	}
 }
 
+func TestCleanup_KeepsBlobWhileLeaseRemains(t *testing.T) {
+	storageDir := t.TempDir()
+	db, err := InitDB(storageDir)
+	if err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close DB: %v", err)
+		}
+	}()
+
+	app := &App{
+		Conf:   Config{StorageDir: storageDir, MaxMB: 100},
+		Logger: discardLogger(),
+		DB:     db,
+		Store:  store.NewFS(storageDir),
+	}
+
+	id := "shared_file_id"
+	path := filepath.Join(storageDir, id)
+	if err := os.WriteFile(path, []byte("blob"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	_, short, err := app.RegisterFile(id, 4, Lease{}, nil, UploadOptions{})
+	if err != nil {
+		t.Fatalf("RegisterFile failed: %v", err)
+	}
+	_, long, err := app.RegisterFile(id, 4, Lease{}, nil, UploadOptions{})
+	if err != nil {
+		t.Fatalf("RegisterFile failed: %v", err)
+	}
+
+	if err := app.DB.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket([]byte(DBBucketIndexName)).Delete(expiryIndexKey(short.ExpiresAt, string(short.key()))); err != nil {
+			return err
+		}
+		short.ExpiresAt = time.Now().Add(-time.Hour)
+		return putLease(tx, short)
+	}); err != nil {
+		t.Fatalf("DB Update failed: %v", err)
+	}
+
+	app.CleanStorage()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal("Cleanup removed a blob that still has a live lease")
+	}
+
+	if err := app.DB.View(func(tx *bbolt.Tx) error {
+		leases, err := app.loadLeases(tx, id)
+		if err != nil {
+			return err
+		}
+		if len(leases) != 1 || leases[0].LeaseID != long.LeaseID {
+			t.Errorf("Want only the live lease to remain, got %+v", leases)
+		}
+
+		meta, err := app.loadMeta(tx, id)
+		if err != nil {
+			t.Fatal("Cleanup removed metadata that still has a live lease")
+		}
+		if !meta.ExpiresAt.Equal(long.ExpiresAt) {
+			t.Errorf("Meta expiry not recomputed: want %v, got %v", long.ExpiresAt, meta.ExpiresAt)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("DB View failed: %v", err)
+	}
+}
+
 func TestSaveChunk_EncryptsData(t *testing.T) {
 	tmpDir := t.TempDir()
 	app := &App{
 		Conf:   Config{StorageDir: tmpDir},
 		Logger: discardLogger(),
+		Store:  store.NewFS(tmpDir),
 	}
 
 	uid := "test-encrypt-chunk"
@@ -166,8 +277,8 @@ func TestSaveChunk_EncryptsData(t *testing.T) {
 		t.Fatal("Chunk contains plaintext!")
 	}
 
-	expectedSize := crypto.KeySize + len(plaintext) + 16
-	if len(fileData) != expectedSize {
+	expectedSize := crypto.KeySize + crypto.EncryptedSize(int64(len(plaintext)))
+	if int64(len(fileData)) != expectedSize {
 		t.Errorf("Unexpected file size. Want %d, got %d", expectedSize, len(fileData))
 	}
 }
@@ -177,6 +288,7 @@ func TestSequentialChunkReader_RestoresData(t *testing.T) {
 	app := &App{
 		Conf:   Config{StorageDir: tmpDir},
 		Logger: discardLogger(),
+		Store:  store.NewFS(tmpDir),
 	}
 
 	uid := "test-restore"
@@ -211,3 +323,161 @@ func TestSequentialChunkReader_RestoresData(t *testing.T) {
 		t.Errorf("Restored data mismatch.\nWant: %s\nGot:  %s", expected, restored)
 	}
 }
+
+const benchUploadSize = 512 << 20
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// benchSource yields benchUploadSize bytes whose first bytes differ per
+// iteration, so successive uploads never deduplicate against each other.
+func benchSource(i int) io.Reader {
+	prefix := fmt.Appendf(nil, "%016d", i)
+	return io.MultiReader(bytes.NewReader(prefix), io.LimitReader(zeroReader{}, benchUploadSize-int64(len(prefix))))
+}
+
+// legacyStore is the pipeline storeUpload replaced, kept as a baseline: the
+// content is encrypted to scratch space under a throwaway key while it is
+// hashed, then decrypted again and re-encrypted under the convergent key.
+func legacyStore(app *App, src io.Reader, scratch string) (string, error) {
+	tmp, err := os.Create(scratch)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(scratch)
+	}()
+
+	ephemeral := make([]byte, crypto.KeySize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return "", err
+	}
+	streamer, err := crypto.NewGCMStreamer(ephemeral)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	if err := streamer.EncryptStream(tmp, io.TeeReader(src, hasher), []byte("scratch")); err != nil {
+		return "", err
+	}
+
+	size, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+	decryptor, err := crypto.NewDecryptor(tmp, ephemeral, size, []byte("scratch"))
+	if err != nil {
+		return "", err
+	}
+
+	key := crypto.ConvergentKey(hasher.Sum(nil), app.Conf.Secret)
+	id := crypto.GetID(key, ".bin")
+
+	out, err := app.Store.Put(id)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = out.Abort() }()
+
+	final, err := crypto.NewGCMStreamer(key)
+	if err != nil {
+		return "", err
+	}
+	if err := final.EncryptStream(out, decryptor, []byte(id)); err != nil {
+		return "", err
+	}
+	return id, out.Commit()
+}
+
+func BenchmarkStoreUpload512MB(b *testing.B) {
+	b.Run("legacy", func(b *testing.B) {
+		app, storageDir := setupTestApp(b)
+		scratch := filepath.Join(storageDir, TempDirName, "scratch")
+		b.SetBytes(benchUploadSize)
+
+		for i := 0; b.Loop(); i++ {
+			id, err := legacyStore(app, benchSource(i), scratch)
+			if err != nil {
+				b.Fatalf("legacy store failed: %v", err)
+			}
+			_ = app.Store.Delete(id)
+		}
+	})
+
+	b.Run("single-pass", func(b *testing.B) {
+		app, _ := setupTestApp(b)
+		b.SetBytes(benchUploadSize)
+
+		for i := 0; b.Loop(); i++ {
+			result, err := app.storeUpload(benchSource(i), "bench.bin", UploadOptions{})
+			if err != nil {
+				b.Fatalf("storeUpload failed: %v", err)
+			}
+			_ = app.Store.Delete(result.ID)
+		}
+	})
+}
+
+func BenchmarkStoreSession512MB(b *testing.B) {
+	app, _ := setupTestApp(b)
+	app.Conf.MaxMB = benchUploadSize / MegaByte
+
+	session, err := app.newSession("bench.bin", benchUploadSize, 0, UploadOptions{})
+	if err != nil {
+		b.Fatalf("newSession failed: %v", err)
+	}
+	src := benchSource(0)
+	for idx := range session.Total() {
+		if err := app.saveChunk(session.ID, idx, io.LimitReader(src, session.ChunkSize)); err != nil {
+			b.Fatalf("saveChunk failed: %v", err)
+		}
+	}
+
+	chunks := func() io.Reader {
+		return &SequentialChunkReader{app: app, uid: session.ID, total: session.Total()}
+	}
+
+	b.Run("legacy", func(b *testing.B) {
+		b.SetBytes(benchUploadSize)
+
+		for b.Loop() {
+			hasher := sha256.New()
+			if _, err := io.Copy(hasher, chunks()); err != nil {
+				b.Fatalf("hash pass failed: %v", err)
+			}
+
+			key := crypto.ConvergentKey(hasher.Sum(nil), app.Conf.Secret)
+			id := crypto.GetID(key, ".bin")
+			out, err := app.Store.Put(id)
+			if err != nil {
+				b.Fatalf("Put failed: %v", err)
+			}
+			streamer, _ := crypto.NewGCMStreamer(key)
+			if err := streamer.EncryptStream(out, chunks(), []byte(id)); err != nil {
+				b.Fatalf("encrypt pass failed: %v", err)
+			}
+			if err := out.Commit(); err != nil {
+				b.Fatalf("Commit failed: %v", err)
+			}
+			_ = app.Store.Delete(id)
+		}
+	})
+
+	b.Run("single-pass", func(b *testing.B) {
+		b.SetBytes(benchUploadSize)
+
+		for b.Loop() {
+			result, err := app.storeSession(session)
+			if err != nil {
+				b.Fatalf("storeSession failed: %v", err)
+			}
+			_ = app.Store.Delete(result.ID)
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package app
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidMetadata     = errors.New("invalid upload metadata")
+	ErrUnsupportedChecksum = errors.New("unsupported checksum algorithm")
+)
+
+// tus wraps a handler for the tus v1 protocol: every response advertises the
+// protocol version and every request other than OPTIONS must speak it.
+func (app *App) tus(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Tus-Resumable", TusVersion)
+
+		if request.Method != http.MethodOptions && request.Header.Get("Tus-Resumable") != TusVersion {
+			writer.Header().Set("Tus-Version", TusVersion)
+			app.SendError(writer, request, http.StatusPreconditionFailed)
+			return
+		}
+
+		handler(writer, request)
+	})
+}
+
+func (app *App) HandleTusOptions(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Tus-Version", TusVersion)
+	writer.Header().Set("Tus-Extension", TusExtensions)
+	writer.Header().Set("Tus-Max-Size", strconv.FormatInt(app.Conf.MaxMB*MegaByte, 10))
+	writer.Header().Set("Tus-Checksum-Algorithm", TusChecksums)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (app *App) HandleTusCreate(writer http.ResponseWriter, request *http.Request) {
+	size, err := strconv.ParseInt(request.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	if size > app.Conf.MaxMB*MegaByte {
+		app.SendError(writer, request, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(request.Header.Get("Upload-Metadata"))
+	if err != nil {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	opts, err := parseUploadOptions(request, func(name string) string { return metadata[name] })
+	if err != nil {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		app.Logger.Error("Failed to create upload session", "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	session := UploadSession{
+		ID:        id,
+		Filename:  filename,
+		Size:      size,
+		Options:   opts,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := app.saveSession(session); err != nil {
+		app.Logger.Error("Failed to create upload session", "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Location", "/files/"+id)
+
+	if session.complete() {
+		app.finishTusUpload(writer, request, session, http.StatusCreated)
+		return
+	}
+
+	writer.WriteHeader(http.StatusCreated)
+}
+
+func (app *App) HandleTusHead(writer http.ResponseWriter, request *http.Request) {
+	session, ok := app.loadTusSession(writer, request)
+	if !ok {
+		return
+	}
+
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Upload-Offset", strconv.FormatInt(session.receivedBytes(), 10))
+	writer.Header().Set("Upload-Length", strconv.FormatInt(session.Size, 10))
+	writer.WriteHeader(http.StatusOK)
+}
+
+func (app *App) HandleTusPatch(writer http.ResponseWriter, request *http.Request) {
+	if request.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		app.SendError(writer, request, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(request.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	checksum, expected, err := parseTusChecksum(request.Header.Get("Upload-Checksum"))
+	if err != nil {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	session, unlock, ok := app.lockTusSession(writer, request)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	received := session.receivedBytes()
+	if offset != received {
+		app.SendError(writer, request, http.StatusConflict)
+		return
+	}
+
+	if request.ContentLength != 0 && received < session.Size {
+		if len(session.Received) >= TusMaxChunks {
+			app.SendError(writer, request, http.StatusBadRequest)
+			return
+		}
+
+		var n byteCounter
+		idx := len(session.Received)
+		body := http.MaxBytesReader(writer, request.Body, session.Size-received)
+
+		src := io.TeeReader(body, &n)
+		if checksum != nil {
+			src = &digestReader{src: src, hash: checksum, want: expected}
+		}
+
+		if err := app.saveChunk(session.ID, idx, src); err != nil {
+			var maxErr *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxErr):
+				app.SendError(writer, request, http.StatusRequestEntityTooLarge)
+			case errors.Is(err, ErrChunkChecksum):
+				app.SendError(writer, request, StatusChecksumBad)
+			default:
+				app.Logger.Error("Failed to save tus chunk", "id", session.ID, "err", err)
+				app.SendError(writer, request, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if n > 0 {
+			if err := app.markChunkReceived(session.ID, idx, int64(n)); err != nil {
+				app.sendSessionError(writer, request, session.ID, err)
+				return
+			}
+			if session.Received == nil {
+				session.Received = make(map[int]int64)
+			}
+			session.Received[idx] = int64(n)
+		}
+	}
+
+	if session.complete() {
+		app.finishTusUpload(writer, request, session, http.StatusNoContent)
+		return
+	}
+
+	writer.Header().Set("Upload-Offset", strconv.FormatInt(session.receivedBytes(), 10))
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (app *App) HandleTusDelete(writer http.ResponseWriter, request *http.Request) {
+	session, unlock, ok := app.lockTusSession(writer, request)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	if err := app.removeSession(session.ID); err != nil {
+		app.Logger.Error("Failed to terminate upload", "id", session.ID, "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload assembles a complete upload and reports the share link in
+// response headers, since tus responses carry no body.
+func (app *App) finishTusUpload(writer http.ResponseWriter, request *http.Request, session UploadSession, code int) {
+	result, err := app.storeSession(session)
+	if err != nil {
+		app.Logger.Error("Failed to store upload", "id", session.ID, "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.removeSession(session.ID); err != nil {
+		app.Logger.Error("Failed to remove upload session", "id", session.ID, "err", err)
+	}
+
+	setResultHeaders(writer, result)
+	writer.Header().Set(LinkHeader, requestScheme(request)+"://"+shareLink(request, result))
+	writer.Header().Set("Upload-Offset", strconv.FormatInt(session.Size, 10))
+	writer.WriteHeader(code)
+}
+
+func (app *App) loadTusSession(writer http.ResponseWriter, request *http.Request) (UploadSession, bool) {
+	id := request.PathValue("id")
+	if !reUploadID.MatchString(id) {
+		app.SendError(writer, request, http.StatusNotFound)
+		return UploadSession{}, false
+	}
+
+	session, err := app.loadSession(id)
+	if err == nil && !session.appendOnly() {
+		err = ErrSessionNotFound
+	}
+	if err != nil {
+		app.sendSessionError(writer, request, id, err)
+		return UploadSession{}, false
+	}
+
+	return session, true
+}
+
+// lockTusSession loads an upload and holds its lock while the request works on
+// it. The session is checked before a lock is made for it, so requests for
+// unknown IDs leave nothing behind, and loaded again under the lock, as it may
+// have been finished or terminated in between.
+func (app *App) lockTusSession(writer http.ResponseWriter, request *http.Request) (UploadSession, func(), bool) {
+	if _, ok := app.loadTusSession(writer, request); !ok {
+		return UploadSession{}, nil, false
+	}
+
+	id := request.PathValue("id")
+	unlock, ok := app.lockUpload(id)
+	if !ok {
+		app.SendError(writer, request, http.StatusLocked)
+		return UploadSession{}, nil, false
+	}
+
+	session, ok := app.loadTusSession(writer, request)
+	if !ok {
+		unlock()
+		app.uploadLocks.Delete(id)
+		return UploadSession{}, nil, false
+	}
+
+	return session, unlock, true
+}
+
+// lockUpload serialises PATCH and DELETE requests for one upload, so two
+// clients resuming at the same offset cannot both append a chunk.
+func (app *App) lockUpload(id string) (func(), bool) {
+	value, _ := app.uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+func parseTusMetadata(raw string) (map[string]string, error) {
+	metadata := make(map[string]string)
+
+	for pair := range strings.SplitSeq(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || key == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidMetadata, key)
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+func parseTusChecksum(raw string) (hash.Hash, []byte, error) {
+	if raw == "" {
+		return nil, nil, nil
+	}
+
+	algorithm, encoded, _ := strings.Cut(raw, " ")
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid checksum: %w", err)
+	}
+
+	switch algorithm {
+	case "sha1":
+		return sha1.New(), expected, nil
+	case "sha256":
+		return sha256.New(), expected, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnsupportedChecksum, algorithm)
+	}
+}
+
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
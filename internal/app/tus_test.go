@@ -0,0 +1,199 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func tusRequest(t *testing.T, method, url string, headers map[string]string, body []byte) *http.Response {
+	req, _ := http.NewRequest(method, url, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", TusVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s request failed: %v", method, err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Errorf("Failed to close response body: %v", err)
+	}
+	return resp
+}
+
+func tusCreate(t *testing.T, baseURL string, size int, metadata string) string {
+	resp := tusRequest(t, http.MethodPost, baseURL+"/files/", map[string]string{
+		"Upload-Length":   fmt.Sprint(size),
+		"Upload-Metadata": metadata,
+	}, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Create status: %d", resp.StatusCode)
+	}
+	return baseURL + resp.Header.Get("Location")
+}
+
+func tusPatch(t *testing.T, location string, offset int, data []byte, headers map[string]string) *http.Response {
+	all := map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": fmt.Sprint(offset),
+	}
+	for k, v := range headers {
+		all[k] = v
+	}
+	return tusRequest(t, http.MethodPatch, location, all, data)
+}
+
+func TestIntegration_TusUpload(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	resp := tusRequest(t, http.MethodOptions, server.URL+"/files/", nil, nil)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Tus-Version") != TusVersion {
+		t.Fatalf("OPTIONS: %d %v", resp.StatusCode, resp.Header)
+	}
+	if !strings.Contains(resp.Header.Get("Tus-Extension"), "checksum") {
+		t.Errorf("Tus-Extension = %q", resp.Header.Get("Tus-Extension"))
+	}
+
+	content := []byte("resumable over tus")
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("tus.txt")) +
+		",max_downloads " + base64.StdEncoding.EncodeToString([]byte("3"))
+	location := tusCreate(t, server.URL, len(content), metadata)
+
+	resp = tusPatch(t, location, 0, content[:10], nil)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "10" {
+		t.Fatalf("First PATCH: %d offset %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+
+	resp = tusRequest(t, http.MethodHead, location, nil, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Upload-Offset") != "10" {
+		t.Fatalf("HEAD: %d offset %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	if resp.Header.Get("Upload-Length") != fmt.Sprint(len(content)) {
+		t.Errorf("Upload-Length = %q", resp.Header.Get("Upload-Length"))
+	}
+
+	sum := sha256.Sum256(content[10:])
+	resp = tusPatch(t, location, 10, content[10:], map[string]string{
+		"Upload-Checksum": "sha256 " + base64.StdEncoding.EncodeToString(sum[:]),
+	})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Final PATCH status: %d", resp.StatusCode)
+	}
+	if resp.Header.Get(DeleteTokenHeader) == "" || resp.Header.Get(MaxDownloadsHeader) != "3" {
+		t.Errorf("Missing upload result headers: %v", resp.Header)
+	}
+
+	link := resp.Header.Get(LinkHeader)
+	if !strings.HasSuffix(link, ".txt") {
+		t.Fatalf("Unexpected link %q", link)
+	}
+	slug := link[strings.LastIndex(link, "/")+1:]
+
+	dl, err := http.Get(server.URL + "/" + slug)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	got, _ := io.ReadAll(dl.Body)
+	_ = dl.Body.Close()
+	if !bytes.Equal(got, content) {
+		t.Errorf("Content mismatch: %q", got)
+	}
+	if !strings.Contains(dl.Header.Get("Content-Disposition"), "tus.txt") {
+		t.Errorf("Content-Disposition = %q", dl.Header.Get("Content-Disposition"))
+	}
+
+	resp = tusRequest(t, http.MethodHead, location, nil, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Finished upload still present: %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_TusRejections(t *testing.T) {
+	app, _ := setupTestApp(t)
+	server := httptest.NewServer(app.Routes())
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/files/", nil)
+	req.Header.Set("Upload-Length", "4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Create request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed || resp.Header.Get("Tus-Version") != TusVersion {
+		t.Errorf("Missing Tus-Resumable: %d", resp.StatusCode)
+	}
+
+	resp = tusRequest(t, http.MethodPost, server.URL+"/files/", map[string]string{
+		"Upload-Length": fmt.Sprint(app.Conf.MaxMB*MegaByte + 1),
+	}, nil)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized create: %d", resp.StatusCode)
+	}
+
+	location := tusCreate(t, server.URL, 8, "")
+
+	if resp := tusPatch(t, location, 3, []byte("data"), nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Offset mismatch: %d", resp.StatusCode)
+	}
+
+	resp = tusPatch(t, location, 0, []byte("data"), map[string]string{
+		"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(make([]byte, 20)),
+	})
+	if resp.StatusCode != StatusChecksumBad {
+		t.Errorf("Checksum mismatch: %d", resp.StatusCode)
+	}
+
+	resp = tusPatch(t, location, 0, []byte("data"), map[string]string{"Upload-Checksum": "md5 AAAA"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Unsupported checksum: %d", resp.StatusCode)
+	}
+
+	if resp := tusPatch(t, location, 0, []byte("too long data"), nil); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Body past Upload-Length: %d", resp.StatusCode)
+	}
+
+	resp = tusRequest(t, http.MethodHead, location, nil, nil)
+	if resp.Header.Get("Upload-Offset") != "0" {
+		t.Errorf("Rejected PATCHes advanced offset to %q", resp.Header.Get("Upload-Offset"))
+	}
+
+	if resp := tusRequest(t, http.MethodDelete, location, nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Terminate: %d", resp.StatusCode)
+	}
+	if resp := tusRequest(t, http.MethodHead, location, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Terminated upload still present: %d", resp.StatusCode)
+	}
+
+	chunks, err := app.Store.List(TempDirName + "/")
+	if err != nil {
+		t.Fatalf("List chunks: %v", err)
+	}
+	if len(chunks) != 0 {
+		t.Errorf("Terminated upload left %d chunks", len(chunks))
+	}
+
+	for i := range 3 {
+		unknown := fmt.Sprintf("%s/files/unknownupload%02d", server.URL, i)
+		if resp := tusPatch(t, unknown, 0, []byte("data"), nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("PATCH unknown upload: %d", resp.StatusCode)
+		}
+		if resp := tusRequest(t, http.MethodDelete, unknown, nil, nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("DELETE unknown upload: %d", resp.StatusCode)
+		}
+	}
+	app.uploadLocks.Range(func(id, _ any) bool {
+		t.Errorf("Upload lock left behind for %v", id)
+		return true
+	})
+}
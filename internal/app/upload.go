@@ -4,9 +4,9 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -29,6 +29,7 @@ func (app *App) HandleUpload(writer http.ResponseWriter, request *http.Request)
 
 	var filename string
 	var partReader io.Reader
+	fields := make(map[string]string)
 
 	for {
 		part, err := mr.NextPart()
@@ -45,6 +46,13 @@ func (app *App) HandleUpload(writer http.ResponseWriter, request *http.Request)
 			partReader = part
 			break
 		}
+
+		value, err := io.ReadAll(io.LimitReader(part, MaxFieldSize))
+		if err != nil {
+			app.SendError(writer, request, http.StatusBadRequest)
+			return
+		}
+		fields[part.FormName()] = string(value)
 	}
 
 	if partReader == nil {
@@ -52,96 +60,60 @@ func (app *App) HandleUpload(writer http.ResponseWriter, request *http.Request)
 		return
 	}
 
-	tmp, err := os.CreateTemp(filepath.Join(app.Conf.StorageDir, TempDirName), "up_*")
+	opts, err := parseUploadOptions(request, func(name string) string { return fields[name] })
 	if err != nil {
-		app.Logger.Error("Failed to create temp file", "err", err)
-		app.SendError(writer, request, http.StatusInternalServerError)
-		return
-	}
-	tmpPath := tmp.Name()
-
-	defer func() {
-		_ = tmp.Close()
-		if removeErr := os.Remove(tmpPath); removeErr != nil && !os.IsNotExist(removeErr) {
-			app.Logger.Error("Failed to remove temp file", "err", removeErr)
-		}
-	}()
-
-	ephemeralKey := make([]byte, crypto.KeySize)
-	if _, err := rand.Read(ephemeralKey); err != nil {
-		app.Logger.Error("Failed to generate ephemeral key", "err", err)
-		app.SendError(writer, request, http.StatusInternalServerError)
+		app.SendError(writer, request, http.StatusBadRequest)
 		return
 	}
 
-	pr, pw := io.Pipe()
-	hasher := sha256.New()
-	errChan := make(chan error, 1)
-
-	go func() {
-		_, err := io.Copy(io.MultiWriter(hasher, pw), partReader)
-		_ = pw.CloseWithError(err)
-		errChan <- err
-	}()
-
-	streamer, err := crypto.NewGCMStreamer(ephemeralKey)
+	result, err := app.storeUpload(partReader, filename, opts)
 	if err != nil {
-		_ = pr.Close()
-		app.Logger.Error("Failed to create streamer", "err", err)
-		app.SendError(writer, request, http.StatusInternalServerError)
-		return
-	}
-
-	if err := streamer.EncryptStream(tmp, pr); err != nil {
-		_ = pr.Close()
-		app.Logger.Error("Failed to encrypt stream", "err", err)
-		app.SendError(writer, request, http.StatusInternalServerError)
-		return
-	}
-
-	if err := <-errChan; err != nil {
 		if errors.Is(err, http.ErrMissingBoundary) || strings.Contains(err.Error(), "request body too large") {
 			app.SendError(writer, request, http.StatusRequestEntityTooLarge)
 		} else {
-			app.Logger.Error("Failed to read/hash upload", "err", err)
+			app.Logger.Error("Failed to store upload", "err", err)
 			app.SendError(writer, request, http.StatusInternalServerError)
 		}
 		return
 	}
 
-	convergentKey := hasher.Sum(nil)[:crypto.KeySize]
-
-	if _, err := tmp.Seek(0, 0); err != nil {
-		app.Logger.Error("Seek failed", "err", err)
-		app.SendError(writer, request, http.StatusInternalServerError)
-		return
-	}
-
-	info, _ := tmp.Stat()
-	decryptor := crypto.NewDecryptor(tmp, streamer.AEAD, info.Size())
-
-	app.finalizeUpload(writer, request, decryptor, convergentKey, filename)
+	app.RespondWithLink(writer, request, result)
 }
 
 func (app *App) HandleChunk(writer http.ResponseWriter, request *http.Request) {
 	const MaxChunkBody = UploadChunkSize + (1 << 20)
 	request.Body = http.MaxBytesReader(writer, request.Body, MaxChunkBody)
 
+	digest, err := chunkDigest(request.Header)
+	if err != nil {
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
 	uid := request.FormValue("upload_id")
 	idx, err := strconv.Atoi(request.FormValue("index"))
-	if err != nil {
+	if err != nil || !reUploadID.MatchString(uid) {
 		app.SendError(writer, request, http.StatusBadRequest)
 		return
 	}
 
-	maxChunks := int((app.Conf.MaxMB*MegaByte)/MinChunkSize) + ChunkSafetyMargin
+	session, err := app.loadSession(uid)
+	if err != nil {
+		app.sendSessionError(writer, request, uid, err)
+		return
+	}
+
+	if session.appendOnly() {
+		app.SendError(writer, request, http.StatusConflict)
+		return
+	}
 
-	if !reUploadID.MatchString(uid) || idx > maxChunks || idx < 0 {
+	if idx < 0 || idx >= session.Total() {
 		app.SendError(writer, request, http.StatusBadRequest)
 		return
 	}
 
-	file, _, err := request.FormFile("chunk")
+	file, header, err := request.FormFile("chunk")
 	if err != nil {
 		if strings.Contains(err.Error(), "request body too large") {
 			app.SendError(writer, request, http.StatusRequestEntityTooLarge)
@@ -156,114 +128,201 @@ func (app *App) HandleChunk(writer http.ResponseWriter, request *http.Request) {
 		}
 	}()
 
-	if err := app.saveChunk(uid, idx, file); err != nil {
+	if header.Size != session.expectedChunkSize(idx) {
+		app.Logger.Warn("Chunk length mismatch", "uid", uid, "index", idx, "size", header.Size)
+		app.SendError(writer, request, http.StatusBadRequest)
+		return
+	}
+
+	var src io.Reader = file
+	if digest != nil {
+		src = &digestReader{src: file, hash: sha256.New(), want: digest}
+	}
+
+	if err := app.saveChunk(uid, idx, src); err != nil {
+		if errors.Is(err, ErrChunkChecksum) {
+			app.Logger.Warn("Chunk checksum mismatch", "uid", uid, "index", idx)
+			app.SendError(writer, request, StatusChecksumBad)
+			return
+		}
 		app.Logger.Error("Failed to save chunk", "err", err)
 		app.SendError(writer, request, http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.markChunkReceived(uid, idx, header.Size); err != nil {
+		app.sendSessionError(writer, request, uid, err)
 	}
 }
 
 func (app *App) HandleFinish(writer http.ResponseWriter, request *http.Request) {
 	uid := request.FormValue("upload_id")
-	total, err := strconv.Atoi(request.FormValue("total"))
-	if err != nil {
+	if !reUploadID.MatchString(uid) {
 		app.SendError(writer, request, http.StatusBadRequest)
 		return
 	}
 
-	maxChunks := int((app.Conf.MaxMB*MegaByte)/MinChunkSize) + ChunkSafetyMargin
+	session, err := app.loadSession(uid)
+	if err != nil {
+		app.sendSessionError(writer, request, uid, err)
+		return
+	}
 
-	if !reUploadID.MatchString(uid) || total > maxChunks || total <= 0 {
-		app.SendError(writer, request, http.StatusBadRequest)
+	if session.appendOnly() || !session.complete() {
+		app.SendError(writer, request, http.StatusConflict)
 		return
 	}
 
 	defer func() {
-		if err := os.RemoveAll(filepath.Join(app.Conf.StorageDir, TempDirName, uid)); err != nil {
-			app.Logger.Error("Failed to remove chunk dir", "err", err)
+		if err := app.removeSession(uid); err != nil {
+			app.Logger.Error("Failed to remove upload session", "uid", uid, "err", err)
 		}
 	}()
 
-	var totalSize int64
-	for i := range total {
-		info, err := os.Stat(filepath.Join(app.Conf.StorageDir, TempDirName, uid, strconv.Itoa(i)))
-		if err != nil {
-			app.Logger.Error("Missing chunk", "index", i, "err", err)
-			app.SendError(writer, request, http.StatusBadRequest)
-			return
-		}
-		chunkContentSize := info.Size() - crypto.KeySize
-		if chunkContentSize < 0 {
-			app.SendError(writer, request, http.StatusBadRequest)
-			return
-		}
-		totalSize += chunkContentSize
+	result, err := app.storeSession(session)
+	if err != nil {
+		app.Logger.Error("Failed to store upload", "uid", uid, "err", err)
+		app.SendError(writer, request, http.StatusInternalServerError)
+		return
 	}
 
-	if totalSize > (app.Conf.MaxMB * MegaByte) {
-		app.Logger.Warn("Upload exceeded quota", "uid", uid, "size", totalSize)
-		app.SendError(writer, request, http.StatusRequestEntityTooLarge)
+	app.RespondWithLink(writer, request, result)
+}
+
+func (app *App) storeSession(session UploadSession) (UploadResult, error) {
+	multiSrc := &SequentialChunkReader{
+		app:   app,
+		uid:   session.ID,
+		total: session.Total(),
+	}
+	defer func() {
+		if err := multiSrc.Close(); err != nil {
+			app.Logger.Error("Failed to close sequential reader", "uid", session.ID, "err", err)
+		}
+	}()
+
+	return app.storeUpload(multiSrc, session.Filename, session.Options)
+}
+
+func (app *App) sendSessionError(writer http.ResponseWriter, request *http.Request, uid string, err error) {
+	if errors.Is(err, ErrSessionNotFound) {
+		app.SendError(writer, request, http.StatusNotFound)
 		return
 	}
+	app.Logger.Error("Failed to load upload session", "uid", uid, "err", err)
+	app.SendError(writer, request, http.StatusInternalServerError)
+}
 
+// storeUpload encrypts src in a single pass: the content is hashed while it
+// is encrypted under a random data key, and the convergent key derived from
+// the hash then seals that data key into the blob header. Content the
+// compression policy covers is compressed on the way in. Identical content
+// that is already stored is discarded instead of committed.
+func (app *App) storeUpload(src io.Reader, filename string, opts UploadOptions) (UploadResult, error) {
 	hasher := sha256.New()
-	for i := range total {
-		rc, err := app.openChunkDecryptor(uid, i)
-		if err != nil {
-			app.Logger.Error("Failed to open chunk for hashing", "index", i, "err", err)
-			app.SendError(writer, request, http.StatusInternalServerError)
-			return
-		}
-		if _, err := io.Copy(hasher, rc); err != nil {
-			_ = rc.Close()
-			app.Logger.Error("Failed to hash chunk", "index", i, "err", err)
-			app.SendError(writer, request, http.StatusInternalServerError)
-			return
-		}
-		_ = rc.Close()
+	if opts.Convergent() {
+		src = io.TeeReader(src, hasher)
 	}
 
-	convergentKey := hasher.Sum(nil)[:crypto.KeySize]
+	probe := &infoProbe{src: src}
+	body, encoding, err := app.compressUpload(probe, filepath.Ext(filename), opts)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("compress upload: %w", err)
+	}
 
-	multiSrc := &SequentialChunkReader{
-		app:   app,
-		uid:   uid,
-		total: total,
+	staged, err := app.stageBlob(body)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("encrypt upload: %w", err)
 	}
 	defer func() {
-		if err := multiSrc.Close(); err != nil {
-			app.Logger.Error("Failed to close sequential reader", "uid", uid, "err", err)
+		if err := staged.discard(); err != nil {
+			app.Logger.Error("Failed to discard staged upload", "err", err)
 		}
 	}()
 
-	app.finalizeUpload(writer, request, multiSrc, convergentKey, request.FormValue("filename"))
-}
+	key := crypto.ConvergentKey(hasher.Sum(nil), app.Conf.Secret)
+	if !opts.Convergent() {
+		key = make([]byte, crypto.KeySize)
+		if _, err := rand.Read(key); err != nil {
+			return UploadResult{}, fmt.Errorf("generate storage key: %w", err)
+		}
+	}
 
-func (app *App) finalizeUpload(writer http.ResponseWriter, request *http.Request, src io.Reader, key []byte, filename string) {
-	ext := filepath.Ext(filename)
-	id := crypto.GetID(key, ext)
-	finalPath := filepath.Join(app.Conf.StorageDir, id)
+	id := crypto.GetID(key, filepath.Ext(filename))
 
-	if info, err := os.Stat(finalPath); err == nil {
-		if err := app.RegisterFile(id, info.Size()); err != nil {
-			app.Logger.Error("Failed to update metadata for existing file", "err", err)
+	var blobInfo []byte
+	_, statErr := app.Store.Stat(id)
+	dedup := statErr == nil
+	if !dedup {
+		if err := staged.commit(key, id); err != nil {
+			return UploadResult{}, fmt.Errorf("commit upload: %w", err)
+		}
+
+		if !opts.E2E {
+			info := probe.info(filepath.Ext(filename))
+			info.Encoding = encoding
+			if blobInfo, err = sealFileInfo(key, id, info); err != nil {
+				return UploadResult{}, fmt.Errorf("seal file info: %w", err)
+			}
 		}
-		app.RespondWithLink(writer, request, key, filename)
-		return
 	}
 
-	if err := app.encryptAndSave(src, key, finalPath); err != nil {
-		app.Logger.Error("Encryption failed", "err", err)
-		app.SendError(writer, request, http.StatusInternalServerError)
-		return
+	result, err := app.registerUpload(id, key, filename, blobInfo, opts)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	result.Size, result.Dedup = probe.size, dedup
+	return result, nil
+}
+
+// registerUpload records a new lease on a stored blob and returns the result
+// to hand back to the uploader. blobInfo is the sealed file info of a newly
+// stored blob, or nil when the blob was already there. The lease gets a share
+// link of its own, whose key seals the blob key and this upload's name and
+// note.
+func (app *App) registerUpload(id string, key []byte, filename string, blobInfo []byte, opts UploadOptions) (UploadResult, error) {
+	deleteToken, deleteHash, err := newDeleteToken()
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	linkKey := make([]byte, crypto.KeySize)
+	if _, err := rand.Read(linkKey); err != nil {
+		return UploadResult{}, fmt.Errorf("generate link key: %w", err)
+	}
+
+	lease := Lease{
+		DeleteHash: deleteHash,
+		LinkID:     crypto.GetID(linkKey, filepath.Ext(filename)),
 	}
 
-	if info, err := os.Stat(finalPath); err == nil {
-		if err := app.RegisterFile(id, info.Size()); err != nil {
-			app.Logger.Error("Failed to save metadata", "err", err)
+	if lease.Key, err = crypto.SealMetadata(linkKey, key, []byte(lease.LinkID)); err != nil {
+		return UploadResult{}, fmt.Errorf("seal file key: %w", err)
+	}
+
+	if !opts.E2E {
+		if lease.Info, err = sealFileInfo(linkKey, lease.LinkID, uploadInfo(filename, opts)); err != nil {
+			return UploadResult{}, fmt.Errorf("seal file info: %w", err)
 		}
-	} else {
-		app.Logger.Error("Failed to stat new file", "err", err)
 	}
 
-	app.RespondWithLink(writer, request, key, filename)
+	info, err := app.Store.Stat(id)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("stat stored file: %w", err)
+	}
+
+	meta, _, err := app.RegisterFile(id, info.Size, lease, blobInfo, opts)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("save metadata: %w", err)
+	}
+
+	return UploadResult{
+		Key:           linkKey,
+		ID:            id,
+		Filename:      filename,
+		DeleteToken:   deleteToken,
+		ExpiresAt:     meta.ExpiresAt,
+		DownloadsLeft: meta.DownloadsLeft,
+	}, nil
 }
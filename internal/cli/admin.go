@@ -0,0 +1,320 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/skidoodle/safebin/internal/app"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+const adminUsage = `ls | stats | expire ID... | clean | reconcile [-fix]
+
+Commands:
+  ls         list stored files with size and expiry
+  stats      count files, bytes, leases, sessions and probes
+  expire     remove files and all their leases now
+  clean      run the periodic cleanup once
+  reconcile  compare blobs with the database; -fix repairs what it can
+
+Flags:`
+
+var ErrLocked = errors.New("database is locked; stop the server first")
+
+// cleanReport is what "admin clean" removed.
+type cleanReport struct {
+	Files    int   `json:"files"`
+	Bytes    int64 `json:"bytes"`
+	Leases   int   `json:"leases"`
+	Sessions int   `json:"sessions"`
+	Probes   int   `json:"probes"`
+}
+
+// reconcileReport is the JSON form of "admin reconcile".
+type reconcileReport struct {
+	app.Reconciliation
+	Fixed bool `json:"fixed"`
+}
+
+// Admin runs "safebin admin", which works on the storage directory itself
+// rather than through the API. bbolt allows one process at a time, so the
+// server has to be stopped first.
+func (env Env) Admin(_ context.Context, args []string) int {
+	flags := env.flagSet("admin", adminUsage)
+	storage := flags.String("s", "", "Storage directory (default $SAFEBIN_STORAGE or "+app.DefaultStorage+")")
+	asJSON := flags.Bool("json", false, "Print JSON instead of text")
+	fix := flags.Bool("fix", false, "Let reconcile repair what it finds")
+
+	operands, err := parseArgs(flags, args)
+	if err != nil {
+		return usageCode(err)
+	}
+	if len(operands) == 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	command, operands := operands[0], operands[1:]
+
+	switch command {
+	case "ls", "stats", "clean", "reconcile":
+		if len(operands) > 0 {
+			flags.Usage()
+			return ExitUsage
+		}
+	case "expire":
+		if len(operands) == 0 {
+			flags.Usage()
+			return ExitUsage
+		}
+	default:
+		fmt.Fprintf(env.Stderr, "safebin admin: unknown command %q\n", command)
+		flags.Usage()
+		return ExitUsage
+	}
+
+	instance, err := env.openStorage(*storage)
+	if err != nil {
+		return env.fail("admin", err)
+	}
+	defer func() { _ = instance.DB.Close() }()
+
+	out := output{env: env, json: *asJSON}
+	switch command {
+	case "ls":
+		return env.fail("admin", out.files(instance))
+	case "stats":
+		return env.fail("admin", out.stats(instance))
+	case "expire":
+		return out.expire(instance, operands)
+	case "clean":
+		return env.fail("admin", out.clean(instance))
+	default:
+		return out.reconcile(instance, *fix)
+	}
+}
+
+// openStorage opens the database and blob store the server would use for
+// dir. It refuses to create a database where there is none.
+func (env Env) openStorage(dir string) (*app.App, error) {
+	conf, err := app.LoadConfig(nil)
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		conf.StorageDir = dir
+		conf.S3.TempDir = filepath.Join(dir, app.TempDirName)
+	}
+
+	if _, err := os.Stat(filepath.Join(conf.StorageDir, app.DBDirName, app.DBFileName)); err != nil {
+		return nil, fmt.Errorf("no database in %s: %w", conf.StorageDir, err)
+	}
+
+	db, err := app.InitDB(conf.StorageDir)
+	if errors.Is(err, bolterrors.ErrTimeout) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := app.NewStore(conf)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &app.App{
+		Conf:   conf,
+		Logger: slog.New(slog.NewTextHandler(env.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+		DB:     db,
+		Store:  blobs,
+	}, nil
+}
+
+type output struct {
+	env  Env
+	json bool
+}
+
+func (o output) encode(v any) error {
+	encoder := json.NewEncoder(o.env.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (o output) files(instance *app.App) error {
+	files, err := instance.ListFiles()
+	if err != nil {
+		return err
+	}
+	if o.json {
+		if files == nil {
+			files = []app.FileSummary{}
+		}
+		return o.encode(files)
+	}
+
+	table := tabwriter.NewWriter(o.env.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tSIZE\tCREATED\tEXPIRES\tDOWNLOADS\tLEASES\tE2E")
+	for _, file := range files {
+		downloads := "-"
+		if file.DownloadsLeft > 0 {
+			downloads = strconv.Itoa(file.DownloadsLeft)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%d\t%t\n", file.ID, formatBytes(file.Size),
+			file.CreatedAt.Local().Format(time.DateTime), file.ExpiresAt.Local().Format(time.DateTime),
+			downloads, file.Leases, file.E2E)
+	}
+	return table.Flush()
+}
+
+func (o output) stats(instance *app.App) error {
+	stats, err := instance.Stats()
+	if err != nil {
+		return err
+	}
+	if o.json {
+		return o.encode(stats)
+	}
+
+	next := "-"
+	if stats.NextExpiry != nil {
+		next = stats.NextExpiry.Local().Format(time.DateTime)
+	}
+
+	table := tabwriter.NewWriter(o.env.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "files\t%d\n", stats.Files)
+	fmt.Fprintf(table, "size\t%s\n", formatBytes(stats.Bytes))
+	fmt.Fprintf(table, "leases\t%d (%d overdue)\n", stats.Leases, stats.Overdue)
+	fmt.Fprintf(table, "next expiry\t%s\n", next)
+	fmt.Fprintf(table, "upload sessions\t%d\n", stats.Sessions)
+	fmt.Fprintf(table, "probe challenges\t%d\n", stats.Probes)
+	return table.Flush()
+}
+
+// expire removes each ID, carrying on past failures and exiting with the
+// code of the first.
+func (o output) expire(instance *app.App, ids []string) int {
+	code := ExitOK
+	var expired []string
+
+	for _, id := range ids {
+		err := instance.ExpireFile(id)
+		if errors.Is(err, app.ErrFileNotFound) {
+			fmt.Fprintf(o.env.Stderr, "safebin admin: %s: no such file\n", id)
+			if code == ExitOK {
+				code = ExitNotFound
+			}
+			continue
+		}
+		if err != nil {
+			if failed := o.env.fail("admin", fmt.Errorf("%s: %w", id, err)); code == ExitOK {
+				code = failed
+			}
+			continue
+		}
+
+		expired = append(expired, id)
+		if !o.json {
+			fmt.Fprintf(o.env.Stdout, "expired %s\n", id)
+		}
+	}
+
+	if o.json {
+		if expired == nil {
+			expired = []string{}
+		}
+		if err := o.encode(map[string][]string{"expired": expired}); err != nil {
+			return o.env.fail("admin", err)
+		}
+	}
+	return code
+}
+
+func (o output) clean(instance *app.App) error {
+	before, err := instance.Stats()
+	if err != nil {
+		return err
+	}
+	instance.Cleanup()
+	after, err := instance.Stats()
+	if err != nil {
+		return err
+	}
+
+	report := cleanReport{
+		Files:    before.Files - after.Files,
+		Bytes:    before.Bytes - after.Bytes,
+		Leases:   before.Leases - after.Leases,
+		Sessions: before.Sessions - after.Sessions,
+		Probes:   before.Probes - after.Probes,
+	}
+	if o.json {
+		return o.encode(report)
+	}
+
+	_, err = fmt.Fprintf(o.env.Stdout, "removed %d files (%s), %d leases, %d upload sessions, %d probe challenges\n",
+		report.Files, formatBytes(report.Bytes), report.Leases, report.Sessions, report.Probes)
+	return err
+}
+
+// reconcile prints the disagreements it finds. It exits with ExitError when
+// there were some and fix was not requested, so it can run from cron.
+func (o output) reconcile(instance *app.App, fix bool) int {
+	report, err := instance.Reconcile(fix)
+	if err != nil {
+		return o.env.fail("admin", err)
+	}
+
+	if o.json {
+		if err := o.encode(reconcileReport{Reconciliation: report, Fixed: fix}); err != nil {
+			return o.env.fail("admin", err)
+		}
+	} else {
+		sections := []struct {
+			label string
+			items []string
+		}{
+			{"orphan blob", report.OrphanBlobs},
+			{"missing blob", report.MissingBlobs},
+			{"size mismatch", report.SizeMismatches},
+			{"unleased file", report.Unleased},
+			{"orphan lease", report.OrphanLeases},
+			{"unindexed lease", report.Unindexed},
+			{"dangling index", report.Dangling},
+			{"dangling link", report.DanglingLinks},
+		}
+
+		table := tabwriter.NewWriter(o.env.Stdout, 0, 0, 2, ' ', 0)
+		for _, section := range sections {
+			for _, item := range section.items {
+				fmt.Fprintf(table, "%s\t%s\n", section.label, item)
+			}
+		}
+		if err := table.Flush(); err != nil {
+			return o.env.fail("admin", err)
+		}
+
+		switch {
+		case report.Clean():
+			fmt.Fprintln(o.env.Stdout, "storage and database agree")
+		case fix && len(report.SizeMismatches) > 0:
+			fmt.Fprintln(o.env.Stdout, "repaired; size mismatches are left for a manual look")
+		case fix:
+			fmt.Fprintln(o.env.Stdout, "repaired")
+		}
+	}
+
+	if !report.Clean() && !fix {
+		return ExitError
+	}
+	return ExitOK
+}
@@ -0,0 +1,142 @@
+// Package cli implements the client commands of the safebin binary: upload,
+// get and rm. They talk to a running instance through the client package
+// and exit with codes scripts can branch on.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/skidoodle/safebin/client"
+)
+
+// Exit codes shared by every command.
+const (
+	ExitOK = 0
+	// ExitError covers failures without a more specific code, such as
+	// network errors and server faults.
+	ExitError = 1
+	// ExitUsage means the command line was wrong.
+	ExitUsage = 2
+	// ExitNotFound means the file does not exist or has expired.
+	ExitNotFound = 3
+	// ExitDenied means the server rejected the key or deletion token.
+	ExitDenied = 4
+	// ExitRejected means the server refused an upload, usually because it
+	// was too large or its options were invalid.
+	ExitRejected = 5
+)
+
+const (
+	ServerEnv      = "SAFEBIN_SERVER"
+	DeleteTokenEnv = "SAFEBIN_DELETE_TOKEN"
+)
+
+var ErrNoServer = errors.New("no server: set -server or " + ServerEnv)
+
+// Env is where a command reads and writes. Progress enables the progress
+// line on Stderr, which only makes sense on a terminal.
+type Env struct {
+	Stdin    io.Reader
+	Stdout   io.Writer
+	Stderr   io.Writer
+	Progress bool
+}
+
+// IsTerminal reports whether file is a character device, such as a terminal.
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (env Env) flagSet(name, operands string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(env.Stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(env.Stderr, "Usage: safebin %s [flags] %s\n", name, operands)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs parses flags wherever they appear among the operands, so
+// "get URL -o out" works as well as "get -o out URL". Everything after "--"
+// is an operand.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var operands []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+
+		rest := flags.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(operands, rest...), nil
+		}
+		if len(rest) == 0 {
+			return operands, nil
+		}
+		operands = append(operands, rest[0])
+		args = rest[1:]
+	}
+}
+
+func usageCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	return ExitUsage
+}
+
+// newClient connects to server, or to the host of link when no server is
+// configured.
+func newClient(server, link string) (*client.Client, error) {
+	if server == "" && strings.Contains(link, "://") {
+		if u, err := url.Parse(link); err == nil && u.Host != "" {
+			server = u.Scheme + "://" + u.Host
+		}
+	}
+	if server == "" {
+		return nil, ErrNoServer
+	}
+	return client.New(server)
+}
+
+// exitCode maps an error to the exit code of the command that hit it.
+func exitCode(err error) int {
+	var status *client.StatusError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrNoServer), errors.Is(err, ErrInvalidRetention), errors.Is(err, client.ErrInvalidLink):
+		return ExitUsage
+	case errors.Is(err, context.Canceled):
+		return ExitError
+	case errors.As(err, &status):
+		switch status.StatusCode {
+		case http.StatusNotFound:
+			return ExitNotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return ExitDenied
+		case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+			return ExitRejected
+		}
+	}
+	return ExitError
+}
+
+// fail reports err, if any, for command and returns its exit code.
+func (env Env) fail(command string, err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	fmt.Fprintf(env.Stderr, "safebin %s: %v\n", command, err)
+	return exitCode(err)
+}
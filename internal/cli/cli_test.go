@@ -0,0 +1,274 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/skidoodle/safebin/internal/app"
+	"github.com/skidoodle/safebin/internal/store"
+	"go.etcd.io/bbolt"
+)
+
+func setupServer(t *testing.T) *httptest.Server {
+	ts, _ := serveStorage(t, t.TempDir())
+	return ts
+}
+
+// serveStorage runs a server over storageDir and returns it with its
+// database, so a test can stop both and work on the directory offline.
+func serveStorage(t *testing.T, storageDir string) (*httptest.Server, *bbolt.DB) {
+	if err := os.MkdirAll(filepath.Join(storageDir, app.TempDirName), 0700); err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := app.InitDB(storageDir)
+	if err != nil {
+		t.Fatalf("Failed to init db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	web := fstest.MapFS{
+		"layout.html":  {Data: []byte(`{{define "layout"}}OK{{end}}`)},
+		"decrypt.html": {Data: []byte(`{{define "decrypt"}}DECRYPT{{end}}`)},
+	}
+	server := &app.App{
+		Conf:   app.Config{StorageDir: storageDir, MaxMB: 1},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Tmpl:   app.ParseTemplates(web),
+		Assets: web,
+		DB:     db,
+		Store:  store.NewFS(storageDir),
+	}
+
+	ts := httptest.NewServer(server.Routes())
+	t.Cleanup(ts.Close)
+	return ts, db
+}
+
+// run executes a command and returns its exit code and output.
+func run(t *testing.T, command func(Env, context.Context, []string) int, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	env := Env{Stdin: strings.NewReader(stdin), Stdout: &stdout, Stderr: &stderr}
+	code := command(env, context.Background(), args)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestUploadGetRemove(t *testing.T) {
+	ts := setupServer(t)
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "build.log")
+	content := []byte("step 1 ok\nstep 2 ok\n")
+	if err := os.WriteFile(src, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, stderr := run(t, Env.Upload, "", "-server", ts.URL, src)
+	link := strings.TrimSpace(stdout)
+	if code != ExitOK || !strings.HasSuffix(link, ".log") {
+		t.Fatalf("upload: %d %q %q", code, stdout, stderr)
+	}
+	_, token, found := strings.Cut(stderr, "delete token ")
+	token, _, _ = strings.Cut(token, ",")
+	if !found || token == "" {
+		t.Fatalf("No delete token in %q", stderr)
+	}
+
+	out := filepath.Join(dir, "copy.log")
+	if code, _, stderr := run(t, Env.Get, "", link, "-o", out); code != ExitOK {
+		t.Fatalf("get -o: %d %q", code, stderr)
+	}
+	if got, err := os.ReadFile(out); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("Saved %q, %v", got, err)
+	}
+
+	slug := link[strings.LastIndex(link, "/")+1:]
+	if code, stdout, _ := run(t, Env.Get, "", "-server", ts.URL, slug); code != ExitOK || stdout != string(content) {
+		t.Errorf("get to stdout: %d %q", code, stdout)
+	}
+
+	if code, _, _ := run(t, Env.Remove, "", "-token", "wrong", link); code != ExitDenied {
+		t.Errorf("rm with wrong token: %d, want %d", code, ExitDenied)
+	}
+	if code, _, stderr := run(t, Env.Remove, "", "-token", token, link); code != ExitOK {
+		t.Fatalf("rm: %d %q", code, stderr)
+	}
+	if code, _, _ := run(t, Env.Get, "", link, "-o", filepath.Join(dir, "gone")); code != ExitNotFound {
+		t.Errorf("get after rm: %d, want %d", code, ExitNotFound)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gone")); !os.IsNotExist(err) {
+		t.Errorf("Failed get left a file behind: %v", err)
+	}
+}
+
+func TestUploadStdin(t *testing.T) {
+	ts := setupServer(t)
+	t.Setenv(ServerEnv, ts.URL)
+
+	code, stdout, stderr := run(t, Env.Upload, "piped output", "-json", "-name", "out.txt", "-expires", "2d", "-max-downloads", "1", "-")
+	if code != ExitOK {
+		t.Fatalf("upload -: %d %q", code, stderr)
+	}
+
+	var record struct {
+		File          string `json:"file"`
+		URL           string `json:"url"`
+		Size          int64  `json:"size"`
+		DownloadsLeft int    `json:"downloads_left"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &record); err != nil {
+		t.Fatalf("Decode %q: %v", stdout, err)
+	}
+	if record.File != "-" || !strings.HasSuffix(record.URL, ".txt") || record.Size != int64(len("piped output")) || record.DownloadsLeft != 1 {
+		t.Errorf("Record: %+v", record)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	ts := setupServer(t)
+	t.Setenv(ServerEnv, "")
+	t.Setenv(DeleteTokenEnv, "")
+
+	large := filepath.Join(t.TempDir(), "large.bin")
+	if err := os.WriteFile(large, make([]byte, 3<<20), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		command func(Env, context.Context, []string) int
+		args    []string
+		want    int
+	}{
+		{"help", Env.Upload, []string{"-help"}, ExitOK},
+		{"unknown flag", Env.Upload, []string{"-bogus", "x"}, ExitUsage},
+		{"no files", Env.Upload, []string{"-server", ts.URL}, ExitUsage},
+		{"no server", Env.Upload, []string{"-"}, ExitUsage},
+		{"bad retention", Env.Upload, []string{"-server", ts.URL, "-expires", "soon", "-"}, ExitUsage},
+		{"missing file", Env.Upload, []string{"-server", ts.URL, "missing.txt"}, ExitError},
+		{"too large", Env.Upload, []string{"-server", ts.URL, large}, ExitRejected},
+		{"get without link", Env.Get, nil, ExitUsage},
+		{"get bad link", Env.Get, []string{ts.URL + "/short"}, ExitUsage},
+		{"get unknown", Env.Get, []string{ts.URL + "/" + strings.Repeat("A", 22) + ".txt"}, ExitNotFound},
+		{"rm without token", Env.Remove, []string{ts.URL + "/" + strings.Repeat("A", 22)}, ExitUsage},
+	}
+	for _, tc := range cases {
+		if code, _, stderr := run(t, tc.command, "", tc.args...); code != tc.want {
+			t.Errorf("%s: exit %d, want %d (%s)", tc.name, code, tc.want, stderr)
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	cases := map[string][]string{
+		"URL -o out":     {"URL"},
+		"-o out URL":     {"URL"},
+		"a -o out b":     {"a", "b"},
+		"-o out -- -x y": {"-x", "y"},
+		"- -o out":       {"-"},
+	}
+	for line, want := range cases {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		output := flags.String("o", "", "")
+
+		got, err := parseArgs(flags, strings.Fields(line))
+		if err != nil || !slices.Equal(got, want) || *output != "out" {
+			t.Errorf("parseArgs(%q) = %q (-o %q), %v", line, got, *output, err)
+		}
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	if d, err := parseRetention("7d"); err != nil || d.Hours() != 168 {
+		t.Errorf("7d = %v, %v", d, err)
+	}
+	if d, err := parseRetention("90m"); err != nil || d.Minutes() != 90 {
+		t.Errorf("90m = %v, %v", d, err)
+	}
+	for _, bad := range []string{"", "d", "-1d", "-5m", "soon"} {
+		if _, err := parseRetention(bad); err == nil {
+			t.Errorf("parseRetention(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	storageDir := t.TempDir()
+	ts, db := serveStorage(t, storageDir)
+
+	for _, content := range []string{"first", "second"} {
+		if code, _, stderr := run(t, Env.Upload, content, "-server", ts.URL, "-name", content+".txt", "-"); code != ExitOK {
+			t.Fatalf("upload: %d %q", code, stderr)
+		}
+	}
+
+	if code, _, stderr := run(t, Env.Admin, "", "-s", storageDir, "stats"); code != ExitError || !strings.Contains(stderr, ErrLocked.Error()) {
+		t.Errorf("stats while serving: %d %q", code, stderr)
+	}
+	ts.Close()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, stderr := run(t, Env.Admin, "", "-s", storageDir, "-json", "ls")
+	var files []app.FileSummary
+	if err := json.Unmarshal([]byte(stdout), &files); code != ExitOK || err != nil || len(files) != 2 {
+		t.Fatalf("ls -json: %d %q %q", code, stdout, stderr)
+	}
+	if code, stdout, _ := run(t, Env.Admin, "", "stats", "-s", storageDir); code != ExitOK || !strings.Contains(stdout, "2 (0 overdue)") {
+		t.Errorf("stats: %d %q", code, stdout)
+	}
+
+	if err := os.WriteFile(filepath.Join(storageDir, "stray"), []byte("left over"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if code, stdout, _ := run(t, Env.Admin, "", "-s", storageDir, "reconcile"); code != ExitError || !strings.Contains(stdout, "orphan blob  stray") {
+		t.Errorf("reconcile: %d %q", code, stdout)
+	}
+	if code, stdout, _ := run(t, Env.Admin, "", "-s", storageDir, "reconcile", "-fix"); code != ExitOK || !strings.HasSuffix(stdout, "repaired\n") {
+		t.Errorf("reconcile -fix: %d %q", code, stdout)
+	}
+	if code, stdout, _ := run(t, Env.Admin, "", "-s", storageDir, "reconcile"); code != ExitOK || stdout != "storage and database agree\n" {
+		t.Errorf("reconcile after fix: %d %q", code, stdout)
+	}
+
+	id := files[0].ID
+	if code, stdout, _ := run(t, Env.Admin, "", "-s", storageDir, "expire", id); code != ExitOK || stdout != "expired "+id+"\n" {
+		t.Errorf("expire: %d %q", code, stdout)
+	}
+	if code, _, stderr := run(t, Env.Admin, "", "-s", storageDir, "expire", id); code != ExitNotFound {
+		t.Errorf("expire twice: %d %q", code, stderr)
+	}
+
+	var cleaned cleanReport
+	code, stdout, _ = run(t, Env.Admin, "", "-s", storageDir, "-json", "clean")
+	if err := json.Unmarshal([]byte(stdout), &cleaned); code != ExitOK || err != nil || cleaned.Files != 0 {
+		t.Errorf("clean: %d %q", code, stdout)
+	}
+
+	cases := map[string][]string{
+		"no command":      {"-s", storageDir},
+		"unknown command": {"-s", storageDir, "vacuum"},
+		"expire nothing":  {"-s", storageDir, "expire"},
+		"stray operand":   {"-s", storageDir, "ls", "extra"},
+	}
+	for name, args := range cases {
+		if code, _, _ := run(t, Env.Admin, "", args...); code != ExitUsage {
+			t.Errorf("%s: exit %d, want %d", name, code, ExitUsage)
+		}
+	}
+	if code, _, stderr := run(t, Env.Admin, "", "-s", t.TempDir(), "ls"); code != ExitError || !strings.Contains(stderr, "no database") {
+		t.Errorf("ls without a database: %d %q", code, stderr)
+	}
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/skidoodle/safebin/client"
+)
+
+// Get runs "safebin get URL [-o FILE]". Without -o, or with "-o -", the file
+// goes to stdout. A file output only appears once the download is complete.
+func (env Env) Get(ctx context.Context, args []string) int {
+	flags := env.flagSet("get", "URL")
+	server := flags.String("server", os.Getenv(ServerEnv), "Server URL, needed when URL is a bare slug")
+	output := flags.String("o", "-", `Output file, "-" for stdout`)
+
+	links, err := parseArgs(flags, args)
+	if err != nil {
+		return usageCode(err)
+	}
+	if len(links) != 1 {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	c, err := newClient(*server, links[0])
+	if err != nil {
+		return env.fail("get", err)
+	}
+
+	file, err := c.Download(ctx, links[0])
+	if err != nil {
+		return env.fail("get", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	if *output == "-" {
+		return env.fail("get", env.copyDownload(env.Stdout, file))
+	}
+	return env.fail("get", env.saveDownload(*output, file))
+}
+
+// saveDownload writes file to a temporary sibling of path and renames it
+// into place, so a failed download never leaves a truncated file behind.
+func (env Env) saveDownload(path string, file *client.File) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".safebin-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	err = env.copyDownload(tmp, file)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (env Env) copyDownload(dst io.Writer, file *client.File) error {
+	bar := env.newProgress(file.Name, file.Size)
+	written, err := io.Copy(bar.writer(dst), file)
+	bar.finish()
+
+	if err == nil && file.Size >= 0 && written != file.Size {
+		err = fmt.Errorf("download truncated at %d of %d bytes", written, file.Size)
+	}
+	return err
+}
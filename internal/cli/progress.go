@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+const progressInterval = 100 * time.Millisecond
+
+// progress draws a single self-overwriting status line. A nil progress
+// draws nothing, so callers need not check whether it is enabled.
+type progress struct {
+	out   io.Writer
+	label string
+	total int64
+	done  int64
+	drawn time.Time
+}
+
+func (env Env) newProgress(label string, total int64) *progress {
+	if !env.Progress {
+		return nil
+	}
+	return &progress{out: env.Stderr, label: label, total: total}
+}
+
+func (p *progress) add(n int) {
+	if p == nil {
+		return
+	}
+	p.done += int64(n)
+	if time.Since(p.drawn) >= progressInterval {
+		p.draw()
+	}
+}
+
+func (p *progress) draw() {
+	p.drawn = time.Now()
+	if p.total > 0 {
+		fmt.Fprintf(p.out, "\r\033[K%s  %s / %s  %3d%%", p.label, formatBytes(p.done), formatBytes(p.total), p.done*100/p.total)
+	} else {
+		fmt.Fprintf(p.out, "\r\033[K%s  %s", p.label, formatBytes(p.done))
+	}
+}
+
+// finish draws the final state and ends the line.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.draw()
+	fmt.Fprintln(p.out)
+}
+
+func (p *progress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{Reader: r, progress: p}
+}
+
+func (p *progress) writer(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return &progressWriter{Writer: w, progress: p}
+}
+
+type progressReader struct {
+	io.Reader
+	progress *progress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.progress.add(n)
+	return n, err
+}
+
+type progressWriter struct {
+	io.Writer
+	progress *progress
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.progress.add(n)
+	return n, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
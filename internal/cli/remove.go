@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"context"
+	"os"
+)
+
+// Remove runs "safebin rm URL", revoking an upload with its deletion token.
+func (env Env) Remove(ctx context.Context, args []string) int {
+	flags := env.flagSet("rm", "URL")
+	server := flags.String("server", os.Getenv(ServerEnv), "Server URL, needed when URL is a bare slug")
+	token := flags.String("token", os.Getenv(DeleteTokenEnv), "Deletion token from the upload")
+
+	links, err := parseArgs(flags, args)
+	if err != nil {
+		return usageCode(err)
+	}
+	if len(links) != 1 || *token == "" {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	c, err := newClient(*server, links[0])
+	if err != nil {
+		return env.fail("rm", err)
+	}
+	if err := c.Delete(ctx, links[0], *token); err != nil {
+		return env.fail("rm", err)
+	}
+	return ExitOK
+}
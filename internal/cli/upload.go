@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skidoodle/safebin/client"
+)
+
+// uploadRecord is one line of "upload -json" output.
+type uploadRecord struct {
+	File string `json:"file"`
+	*client.Result
+}
+
+// Upload runs "safebin upload FILE...". It prints one link per file on
+// stdout and keeps going after a failed file, exiting with the code of the
+// first failure.
+func (env Env) Upload(ctx context.Context, args []string) int {
+	flags := env.flagSet("upload", "FILE...")
+	server := flags.String("server", os.Getenv(ServerEnv), "Server URL")
+	expires := flags.String("expires", "", "Retention such as 7d or 36h")
+	maxDownloads := flags.Int("max-downloads", 0, "Delete the file after this many downloads")
+	private := flags.Bool("private", false, "Do not deduplicate against other uploads")
+	note := flags.String("note", "", "Note shown to downloaders")
+	name := flags.String("name", "", `File name to upload as (default the base name, or "stdin")`)
+	asJSON := flags.Bool("json", false, "Print each result as a JSON line")
+
+	files, err := parseArgs(flags, args)
+	if err != nil {
+		return usageCode(err)
+	}
+	if len(files) == 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	opts := client.UploadOptions{MaxDownloads: *maxDownloads, Private: *private, Note: *note}
+	if *expires != "" {
+		if opts.Expires, err = parseRetention(*expires); err != nil {
+			return env.fail("upload", err)
+		}
+	}
+
+	c, err := newClient(*server, "")
+	if err != nil {
+		return env.fail("upload", err)
+	}
+
+	code := ExitOK
+	for _, file := range files {
+		result, err := env.uploadOne(ctx, c, file, *name, opts)
+		if err != nil {
+			if failed := env.fail("upload", fmt.Errorf("%s: %w", file, err)); code == ExitOK {
+				code = failed
+			}
+			continue
+		}
+
+		if *asJSON {
+			if err := json.NewEncoder(env.Stdout).Encode(uploadRecord{File: file, Result: result}); err != nil {
+				return env.fail("upload", err)
+			}
+			continue
+		}
+		fmt.Fprintln(env.Stdout, result.URL)
+		if result.DeleteToken != "" {
+			fmt.Fprintf(env.Stderr, "%s: delete token %s, expires %s\n", file, result.DeleteToken, result.ExpiresAt.Local().Format(time.DateTime))
+		}
+	}
+	return code
+}
+
+func (env Env) uploadOne(ctx context.Context, c *client.Client, file, name string, opts client.UploadOptions) (*client.Result, error) {
+	src, size := env.Stdin, int64(-1)
+	filename := "stdin"
+
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = f.Close() }()
+
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("is a directory")
+		}
+		src, size, filename = f, info.Size(), filepath.Base(file)
+	}
+	if name != "" {
+		filename = name
+	}
+
+	bar := env.newProgress(filename, size)
+	result, err := c.Upload(ctx, bar.reader(src), filename, size, opts)
+	bar.finish()
+	return result, err
+}
+
+var ErrInvalidRetention = errors.New("invalid retention")
+
+// parseRetention accepts whole days ("7d") as well as Go durations.
+func parseRetention(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%w %q", ErrInvalidRetention, value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%w %q", ErrInvalidRetention, value)
+	}
+	return d, nil
+}
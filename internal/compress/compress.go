@@ -0,0 +1,152 @@
+// Package compress implements a seekable framing of DEFLATE.
+//
+// The plaintext is cut into FrameSize pieces that are compressed
+// independently, each ending in a sync flush, so any frame can be inflated on
+// its own and the frames concatenate into one valid DEFLATE stream. An index
+// of compressed frame lengths and a fixed trailer follow the frames:
+//
+//	frame 0 | ... | frame n-1 | len 0 (u32) | ... | len n-1 (u32) | trailer
+//
+// The trailer holds the frame size, frame count, plaintext length, the
+// CRC-32 of the plaintext and the Magic, all big endian.
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// Encoding names the framing in file metadata.
+	Encoding = "deflate-frames"
+
+	FrameSize   = 256 << 10
+	TrailerSize = 4 + 4 + 8 + 4 + 4
+)
+
+var (
+	Magic = []byte("SBZ1")
+
+	ErrCorrupt = errors.New("compressed stream corrupt")
+)
+
+// Compressor is a reader that yields the framed compression of src.
+type Compressor struct {
+	src     io.Reader
+	flate   *flate.Writer
+	frame   []byte
+	out     bytes.Buffer
+	lengths []uint32
+	crc     uint32
+	size    uint64
+	done    bool
+}
+
+func NewCompressor(src io.Reader, level int) (*Compressor, error) {
+	fw, err := flate.NewWriter(io.Discard, level)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create deflate writer: %w", err)
+	}
+
+	return &Compressor{
+		src:   src,
+		flate: fw,
+		frame: make([]byte, FrameSize),
+	}, nil
+}
+
+func (c *Compressor) Read(buf []byte) (int, error) {
+	for c.out.Len() == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.fill(); err != nil {
+			return 0, err
+		}
+	}
+	return c.out.Read(buf)
+}
+
+// fill compresses the next frame of src into the output buffer, followed by
+// the index and trailer once src is exhausted.
+func (c *Compressor) fill() error {
+	n, err := io.ReadFull(c.src, c.frame)
+	last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !last {
+		return err
+	}
+
+	if n > 0 {
+		before := c.out.Len()
+		c.flate.Reset(&c.out)
+		if _, err := c.flate.Write(c.frame[:n]); err != nil {
+			return fmt.Errorf("failed to compress frame: %w", err)
+		}
+		if err := c.flate.Flush(); err != nil {
+			return fmt.Errorf("failed to flush frame: %w", err)
+		}
+
+		c.lengths = append(c.lengths, uint32(c.out.Len()-before))
+		c.crc = crc32.Update(c.crc, crc32.IEEETable, c.frame[:n])
+		c.size += uint64(n)
+	}
+
+	if !last {
+		return nil
+	}
+
+	for _, length := range c.lengths {
+		c.out.Write(binary.BigEndian.AppendUint32(nil, length))
+	}
+	c.out.Write(marshalTrailer(trailer{
+		frameSize: FrameSize,
+		frames:    uint32(len(c.lengths)),
+		size:      c.size,
+		crc:       c.crc,
+	}))
+	c.done = true
+	return nil
+}
+
+type trailer struct {
+	frameSize uint32
+	frames    uint32
+	size      uint64
+	crc       uint32
+}
+
+func marshalTrailer(t trailer) []byte {
+	buf := make([]byte, 0, TrailerSize)
+	buf = binary.BigEndian.AppendUint32(buf, t.frameSize)
+	buf = binary.BigEndian.AppendUint32(buf, t.frames)
+	buf = binary.BigEndian.AppendUint64(buf, t.size)
+	buf = binary.BigEndian.AppendUint32(buf, t.crc)
+	return append(buf, Magic...)
+}
+
+func parseTrailer(raw []byte) (trailer, error) {
+	if !bytes.Equal(raw[TrailerSize-len(Magic):], Magic) {
+		return trailer{}, fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+
+	t := trailer{
+		frameSize: binary.BigEndian.Uint32(raw[0:4]),
+		frames:    binary.BigEndian.Uint32(raw[4:8]),
+		size:      binary.BigEndian.Uint64(raw[8:16]),
+		crc:       binary.BigEndian.Uint32(raw[16:20]),
+	}
+
+	if t.frameSize == 0 {
+		return trailer{}, fmt.Errorf("%w: zero frame size", ErrCorrupt)
+	}
+	if t.size > uint64(t.frames)*uint64(t.frameSize) || (t.frames > 0 && t.size <= uint64(t.frames-1)*uint64(t.frameSize)) {
+		return trailer{}, fmt.Errorf("%w: %d bytes do not fill %d frames", ErrCorrupt, t.size, t.frames)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,170 @@
+package compress_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/skidoodle/safebin/internal/compress"
+)
+
+func compressAll(t *testing.T, payload []byte) []byte {
+	c, err := compress.NewCompressor(bytes.NewReader(payload), flate.DefaultCompression)
+	if err != nil {
+		t.Fatalf("NewCompressor failed: %v", err)
+	}
+
+	encoded, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	return encoded
+}
+
+func logLines(size int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < size; i++ {
+		fmt.Fprintf(&buf, "2026-01-02T15:04:05Z level=info msg=\"request served\" id=%d status=200\n", i)
+	}
+	return buf.Bytes()[:size]
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, compress.FrameSize - 1, compress.FrameSize, compress.FrameSize + 1, 3*compress.FrameSize + 17} {
+		payload := logLines(size)
+		encoded := compressAll(t, payload)
+
+		r, err := compress.NewReader(bytes.NewReader(encoded), int64(len(encoded)))
+		if err != nil {
+			t.Fatalf("NewReader(%d bytes) failed: %v", size, err)
+		}
+		if r.Size() != int64(size) {
+			t.Errorf("Size = %d, want %d", r.Size(), size)
+		}
+
+		plain, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(plain, payload) {
+			t.Errorf("Roundtrip of %d bytes failed: %v", size, err)
+		}
+	}
+}
+
+func TestCompressesText(t *testing.T) {
+	payload := logLines(2 << 20)
+	encoded := compressAll(t, payload)
+
+	if ratio := float64(len(payload)) / float64(len(encoded)); ratio < 5 {
+		t.Errorf("Log lines compressed only %.1fx", ratio)
+	}
+}
+
+func TestRandomAccess(t *testing.T) {
+	payload := make([]byte, 4*compress.FrameSize+100)
+	if _, err := rand.Read(payload[:compress.FrameSize]); err != nil {
+		t.Fatal(err)
+	}
+	copy(payload[compress.FrameSize:], logLines(len(payload)-compress.FrameSize))
+	encoded := compressAll(t, payload)
+
+	r, err := compress.NewReader(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+
+	for _, off := range []int64{3*compress.FrameSize - 10, 5, compress.FrameSize + 7, int64(len(payload)) - 50} {
+		buf := make([]byte, 200)
+		n, err := r.ReadAt(buf, off)
+		if err != nil && !errors.Is(err, io.EOF) {
+			t.Fatalf("ReadAt(%d) failed: %v", off, err)
+		}
+		if !bytes.Equal(buf[:n], payload[off:off+int64(n)]) {
+			t.Errorf("ReadAt(%d) mismatch", off)
+		}
+	}
+
+	if _, err := r.Seek(-150, io.SeekEnd); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	tail, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(tail, payload[len(payload)-150:]) {
+		t.Errorf("Tail read mismatch: %v", err)
+	}
+}
+
+func TestFramesFormOneDeflateStream(t *testing.T) {
+	payload := logLines(2*compress.FrameSize + 5)
+	encoded := compressAll(t, payload)
+
+	frames := len(encoded) - compress.TrailerSize - 3*4
+	stream := append(append([]byte{}, encoded[:frames]...), 1, 0, 0, 0xff, 0xff)
+
+	plain, err := io.ReadAll(flate.NewReader(bytes.NewReader(stream)))
+	if err != nil || !bytes.Equal(plain, payload) {
+		t.Errorf("Concatenated frames did not inflate: %v", err)
+	}
+}
+
+func TestRejectsCorruptStream(t *testing.T) {
+	encoded := compressAll(t, logLines(compress.FrameSize+10))
+
+	for name, mutate := range map[string]func([]byte) []byte{
+		"magic":     func(b []byte) []byte { b[len(b)-1] ^= 1; return b },
+		"truncated": func(b []byte) []byte { return b[1:] },
+		"short":     func(b []byte) []byte { return b[:compress.TrailerSize-1] },
+	} {
+		mutated := mutate(append([]byte{}, encoded...))
+		if _, err := compress.NewReader(bytes.NewReader(mutated), int64(len(mutated))); !errors.Is(err, compress.ErrCorrupt) {
+			t.Errorf("%s: want ErrCorrupt, got %v", name, err)
+		}
+	}
+
+	mutated := append([]byte{}, encoded...)
+	mutated[0] ^= 0xff
+	r, err := compress.NewReader(bytes.NewReader(mutated), int64(len(mutated)))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Error("Corrupt frame inflated without error")
+	}
+}
+
+func TestGzipWithoutInflating(t *testing.T) {
+	for _, size := range []int{0, 10, 2*compress.FrameSize + 5} {
+		payload := logLines(size)
+		encoded := compressAll(t, payload)
+
+		r, err := compress.NewReader(bytes.NewReader(encoded), int64(len(encoded)))
+		if err != nil {
+			t.Fatalf("NewReader failed: %v", err)
+		}
+
+		gz := r.Gzip()
+		member, err := io.ReadAll(gz)
+		if err != nil || int64(len(member)) != gz.Size() {
+			t.Fatalf("Read gzip of %d bytes: %d of %d bytes, %v", size, len(member), gz.Size(), err)
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(member))
+		if err != nil {
+			t.Fatalf("gzip.NewReader failed: %v", err)
+		}
+		plain, err := io.ReadAll(zr)
+		if err != nil || !bytes.Equal(plain, payload) {
+			t.Errorf("Gzip of %d bytes did not decode: %v", size, err)
+		}
+
+		if _, err := gz.Seek(gz.Size()/2, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		rest, err := io.ReadAll(gz)
+		if err != nil || !bytes.Equal(rest, member[gz.Size()/2:]) {
+			t.Errorf("Gzip read after seek mismatch: %v", err)
+		}
+	}
+}
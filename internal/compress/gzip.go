@@ -0,0 +1,104 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// gzipHeader is a minimal RFC 1952 member header: deflate, no flags, no
+// modification time, unknown OS.
+var gzipHeader = []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff}
+
+// finalBlock is an empty stored DEFLATE block with BFINAL set. The frames all
+// end byte aligned after a sync flush, so it can follow them directly.
+var finalBlock = []byte{1, 0, 0, 0xff, 0xff}
+
+// GzipReader presents a framed stream as a single gzip member without
+// inflating it.
+type GzipReader struct {
+	src    io.ReadSeeker
+	body   int64
+	tail   []byte
+	offset int64
+	synced bool
+}
+
+// Gzip returns the stream re-wrapped as gzip. The frames already form one
+// DEFLATE stream, so only a header, a final block and the recorded CRC-32 and
+// length are added around them. The GzipReader shares the source with r, so
+// the two must not be read concurrently.
+func (r *Reader) Gzip() *GzipReader {
+	tail := append([]byte{}, finalBlock...)
+	tail = binary.LittleEndian.AppendUint32(tail, r.crc)
+	tail = binary.LittleEndian.AppendUint32(tail, uint32(r.size))
+
+	return &GzipReader{
+		src:  r.src,
+		body: r.offsets[len(r.offsets)-1],
+		tail: tail,
+	}
+}
+
+// Size is the length of the gzip member.
+func (g *GzipReader) Size() int64 {
+	return int64(len(gzipHeader)) + g.body + int64(len(g.tail))
+}
+
+func (g *GzipReader) Read(buf []byte) (int, error) {
+	head := int64(len(gzipHeader))
+
+	switch {
+	case g.offset >= g.Size():
+		return 0, io.EOF
+	case g.offset < head:
+		n := copy(buf, gzipHeader[g.offset:])
+		g.offset += int64(n)
+		return n, nil
+	case g.offset >= head+g.body:
+		n := copy(buf, g.tail[g.offset-head-g.body:])
+		g.offset += int64(n)
+		return n, nil
+	}
+
+	pos := g.offset - head
+	if !g.synced {
+		if _, err := g.src.Seek(pos, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("failed to seek: %w", err)
+		}
+		g.synced = true
+	}
+
+	n, err := g.src.Read(buf[:min(int64(len(buf)), g.body-pos)])
+	g.offset += int64(n)
+	if err == io.EOF {
+		if g.offset < head+g.body {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (g *GzipReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = g.offset + offset
+	case io.SeekEnd:
+		abs = g.Size() + offset
+	default:
+		return 0, ErrInvalidWhence
+	}
+
+	if abs < 0 {
+		return 0, ErrNegativeBias
+	}
+
+	g.offset = abs
+	g.synced = false
+	return abs, nil
+}
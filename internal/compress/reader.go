@@ -0,0 +1,200 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	ErrInvalidWhence = errors.New("invalid whence")
+	ErrNegativeBias  = errors.New("negative bias")
+)
+
+// Reader inflates a framed stream with random access. It keeps the most
+// recently inflated frame, so small reads within a frame cost one copy.
+type Reader struct {
+	mu        sync.Mutex
+	src       io.ReadSeeker
+	offsets   []int64
+	frameSize int64
+	size      int64
+	crc       uint32
+	offset    int64
+
+	inflater   io.ReadCloser
+	source     bytes.Reader
+	compressed []byte
+	frame      []byte
+	frameIdx   int64
+}
+
+// NewReader reads the index of a framed stream of encodedSize bytes.
+func NewReader(src io.ReadSeeker, encodedSize int64) (*Reader, error) {
+	if encodedSize < TrailerSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrCorrupt, encodedSize)
+	}
+
+	raw := make([]byte, TrailerSize)
+	if err := readAt(src, raw, encodedSize-TrailerSize); err != nil {
+		return nil, err
+	}
+
+	t, err := parseTrailer(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	indexSize := int64(t.frames) * 4
+	dataSize := encodedSize - TrailerSize - indexSize
+	if dataSize < 0 {
+		return nil, fmt.Errorf("%w: index of %d frames exceeds stream", ErrCorrupt, t.frames)
+	}
+
+	index := make([]byte, indexSize)
+	if err := readAt(src, index, dataSize); err != nil {
+		return nil, err
+	}
+
+	offsets := make([]int64, t.frames+1)
+	for i := range t.frames {
+		offsets[i+1] = offsets[i] + int64(binary.BigEndian.Uint32(index[i*4:]))
+	}
+	if offsets[t.frames] != dataSize {
+		return nil, fmt.Errorf("%w: index covers %d of %d bytes", ErrCorrupt, offsets[t.frames], dataSize)
+	}
+
+	return &Reader{
+		src:       src,
+		offsets:   offsets,
+		frameSize: int64(t.frameSize),
+		size:      int64(t.size),
+		crc:       t.crc,
+		inflater:  flate.NewReader(nil),
+		frameIdx:  -1,
+	}, nil
+}
+
+func readAt(src io.ReadSeeker, buf []byte, offset int64) error {
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek: %w", err)
+	}
+	if _, err := io.ReadFull(src, buf); err != nil {
+		return fmt.Errorf("failed to read compressed data: %w", err)
+	}
+	return nil
+}
+
+// Size is the length of the decompressed content.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// loadFrame inflates frame idx into the frame buffer unless it is already
+// there.
+func (r *Reader) loadFrame(idx int64) ([]byte, error) {
+	if idx == r.frameIdx {
+		return r.frame, nil
+	}
+
+	start, end := r.offsets[idx], r.offsets[idx+1]
+	if cap(r.compressed) < int(end-start) {
+		r.compressed = make([]byte, end-start)
+	}
+	r.compressed = r.compressed[:end-start]
+
+	r.frameIdx = -1
+	if err := readAt(r.src, r.compressed, start); err != nil {
+		return nil, err
+	}
+
+	want := min(r.frameSize, r.size-idx*r.frameSize)
+	if cap(r.frame) < int(want) {
+		r.frame = make([]byte, r.frameSize)
+	}
+	r.frame = r.frame[:want]
+
+	r.source.Reset(r.compressed)
+	if err := r.inflater.(flate.Resetter).Reset(&r.source, nil); err != nil {
+		return nil, fmt.Errorf("%w: frame %d: %w", ErrCorrupt, idx, err)
+	}
+	if _, err := io.ReadFull(r.inflater, r.frame); err != nil {
+		return nil, fmt.Errorf("%w: frame %d: %w", ErrCorrupt, idx, err)
+	}
+
+	r.frameIdx = idx
+	return r.frame, nil
+}
+
+func (r *Reader) Read(buf []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, err := r.readAt(buf, r.offset, false)
+	r.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads decompressed content at off without moving the Read offset.
+func (r *Reader) ReadAt(buf []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeBias
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.readAt(buf, off, true)
+}
+
+// readAt copies from the frames at off, stopping after one frame unless full
+// is set.
+func (r *Reader) readAt(buf []byte, off int64, full bool) (int, error) {
+	nRead := 0
+	for nRead < len(buf) {
+		pos := off + int64(nRead)
+		if pos >= r.size {
+			return nRead, io.EOF
+		}
+
+		frame, err := r.loadFrame(pos / r.frameSize)
+		if err != nil {
+			return nRead, err
+		}
+
+		nRead += copy(buf[nRead:], frame[pos%r.frameSize:])
+		if !full {
+			break
+		}
+	}
+	return nRead, nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var abs int64
+
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, ErrInvalidWhence
+	}
+
+	if abs < 0 {
+		return 0, ErrNegativeBias
+	}
+
+	r.offset = abs
+	return abs, nil
+}
@@ -3,6 +3,7 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
@@ -16,6 +17,21 @@ const (
 	NonceSize    = 12
 	KeySize      = 16
 	IDSize       = 9
+	TagSize      = 16
+
+	FormatLegacy   = 0
+	FormatStream   = 1
+	FormatHeader   = 2
+	FormatEnvelope = 3
+)
+
+const convergentLabel = "safebin convergent key v1"
+
+var (
+	Magic = []byte("SBIN")
+
+	ErrTruncated = errors.New("ciphertext truncated")
+	ErrTampered  = errors.New("ciphertext failed authentication")
 )
 
 func DeriveKey(reader io.Reader) ([]byte, error) {
@@ -28,6 +44,18 @@ func DeriveKey(reader io.Reader) ([]byte, error) {
 	return hasher.Sum(nil)[:KeySize], nil
 }
 
+func ConvergentKey(digest, secret []byte) []byte {
+	if len(secret) == 0 {
+		return digest[:KeySize]
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(convergentLabel))
+	mac.Write(digest)
+
+	return mac.Sum(nil)[:KeySize]
+}
+
 func GetID(key []byte, ext string) string {
 	hasher := sha256.New()
 	hasher.Write(key)
@@ -36,11 +64,38 @@ func GetID(key []byte, ext string) string {
 	return base64.RawURLEncoding.EncodeToString(hasher.Sum(nil)[:IDSize])
 }
 
+func EncryptedSize(plainSize int64) int64 {
+	chunks := max(1, (plainSize+GCMChunkSize-1)/GCMChunkSize)
+	return HeaderSize + plainSize + chunks*TagSize
+}
+
+func streamPrefix() []byte {
+	return append(append([]byte{}, Magic...), FormatStream)
+}
+
+func streamNonce(nonce []byte, chunkIdx uint64, final bool) {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[3:11], chunkIdx)
+	if final {
+		nonce[11] = 1
+	}
+}
+
+func legacyNonce(nonce []byte, chunkIdx uint64) {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[4:], chunkIdx)
+}
+
+func streamAAD(prefix, ad []byte) []byte {
+	return append(append([]byte{}, prefix...), ad...)
+}
+
 type GCMStreamer struct {
 	AEAD cipher.AEAD
+	opts options
 }
 
-func NewGCMStreamer(key []byte) (*GCMStreamer, error) {
+func NewGCMStreamer(key []byte, opts ...Option) (*GCMStreamer, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
@@ -51,36 +106,113 @@ func NewGCMStreamer(key []byte) (*GCMStreamer, error) {
 		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
 
-	return &GCMStreamer{AEAD: gcm}, nil
+	return &GCMStreamer{AEAD: gcm, opts: buildOptions(opts)}, nil
 }
 
-func (g *GCMStreamer) EncryptStream(dst io.Writer, src io.Reader) error {
-	buf := make([]byte, GCMChunkSize)
-	var chunkIdx uint64
+func (g *GCMStreamer) EncryptStream(dst io.Writer, src io.Reader, ad []byte) error {
+	header := Header{
+		Version:   FormatHeader,
+		Suite:     SuiteAES128GCM,
+		ChunkSize: GCMChunkSize,
+		Length:    UnknownLength,
+	}
 
-	for {
-		bytesRead, err := io.ReadFull(src, buf)
-		if bytesRead > 0 {
-			nonce := make([]byte, NonceSize)
-			binary.BigEndian.PutUint64(nonce[4:], chunkIdx)
+	seeker, seekable := dst.(io.WriteSeeker)
+	var start int64
+	if seekable {
+		pos, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			seekable = false
+		}
+		start = pos
+	}
 
-			ciphertext := g.AEAD.Seal(nil, nonce, buf[:bytesRead], nil)
+	placeholder := make([]byte, HeaderSize)
+	if !seekable {
+		placeholder = sealHeader(g.AEAD, header, ad)
+	}
 
-			if _, werr := dst.Write(ciphertext); werr != nil {
-				return fmt.Errorf("failed to write ciphertext: %w", werr)
-			}
+	if _, err := dst.Write(placeholder); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
 
-			chunkIdx++
+	length, err := g.encryptChunks(dst, src, header.chunkAAD(ad))
+	if err != nil {
+		return err
+	}
+
+	if !seekable {
+		return nil
+	}
+
+	header.Length = length
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to header: %w", err)
+	}
+	if _, err := seeker.Write(sealHeader(g.AEAD, header, ad)); err != nil {
+		return fmt.Errorf("failed to rewrite header: %w", err)
+	}
+	if _, err := seeker.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("failed to seek to end: %w", err)
+	}
+
+	return nil
+}
+
+func (g *GCMStreamer) encryptChunks(dst io.Writer, src io.Reader, aad []byte) (uint64, error) {
+	if g.opts.workers > 1 {
+		return encryptChunksParallel(g.AEAD, dst, src, aad, g.opts.workers)
+	}
+	return encryptChunks(g.AEAD, dst, src, aad)
+}
+
+// encryptChunks seals src to dst in GCMChunkSize pieces, marking the last
+// piece in its nonce, and returns the number of plaintext bytes written.
+func encryptChunks(aead cipher.AEAD, dst io.Writer, src io.Reader, aad []byte) (uint64, error) {
+	nonce := make([]byte, NonceSize)
+	cur := make([]byte, GCMChunkSize)
+	next := make([]byte, GCMChunkSize)
+	var ciphertext []byte
+	var length uint64
+
+	curLen, err := readChunk(src, cur)
+	if err != nil {
+		return 0, err
+	}
+
+	for chunkIdx := uint64(0); ; chunkIdx++ {
+		nextLen := 0
+		if curLen == GCMChunkSize {
+			if nextLen, err = readChunk(src, next); err != nil {
+				return 0, err
+			}
 		}
 
-		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
-			break
+		final := nextLen == 0
+		streamNonce(nonce, chunkIdx, final)
+		ciphertext = aead.Seal(ciphertext[:0], nonce, cur[:curLen], aad)
+		length += uint64(curLen)
+
+		if _, werr := dst.Write(ciphertext); werr != nil {
+			return 0, fmt.Errorf("failed to write ciphertext: %w", werr)
 		}
 
-		if err != nil {
-			return fmt.Errorf("failed to read source: %w", err)
+		if final {
+			return length, nil
 		}
+
+		cur, next = next, cur
+		curLen = nextLen
 	}
+}
 
-	return nil
+func readChunk(src io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(src, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, nil
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to read source: %w", err)
+	}
+	return n, nil
 }
@@ -3,7 +3,10 @@ package crypto_test
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"io"
+	"sync"
 	"testing"
 
 	"github.com/skidoodle/safebin/internal/crypto"
@@ -68,12 +71,15 @@ func TestEncryptDecryptStream(t *testing.T) {
 		t.Fatalf("Failed to create streamer: %v", err)
 	}
 
-	if err := streamer.EncryptStream(&encryptedBuf, bytes.NewReader(payload)); err != nil {
+	if err := streamer.EncryptStream(&encryptedBuf, bytes.NewReader(payload), []byte("file-id")); err != nil {
 		t.Fatalf("EncryptStream failed: %v", err)
 	}
 
 	encryptedReader := bytes.NewReader(encryptedBuf.Bytes())
-	decryptor := crypto.NewDecryptor(encryptedReader, streamer.AEAD, int64(encryptedBuf.Len()))
+	decryptor, err := crypto.NewDecryptor(encryptedReader, key, int64(encryptedBuf.Len()), []byte("file-id"))
+	if err != nil {
+		t.Fatalf("NewDecryptor failed: %v", err)
+	}
 
 	decrypted := make([]byte, payloadSize)
 	n, err := io.ReadFull(decryptor, decrypted)
@@ -104,12 +110,15 @@ func TestDecryptorSeeking(t *testing.T) {
 
 	var encryptedBuf bytes.Buffer
 	streamer, _ := crypto.NewGCMStreamer(key)
-	if err := streamer.EncryptStream(&encryptedBuf, bytes.NewReader(payload)); err != nil {
+	if err := streamer.EncryptStream(&encryptedBuf, bytes.NewReader(payload), nil); err != nil {
 		t.Fatalf("EncryptStream failed: %v", err)
 	}
 
 	r := bytes.NewReader(encryptedBuf.Bytes())
-	d := crypto.NewDecryptor(r, streamer.AEAD, int64(encryptedBuf.Len()))
+	d, err := crypto.NewDecryptor(r, key, int64(encryptedBuf.Len()), nil)
+	if err != nil {
+		t.Fatalf("NewDecryptor failed: %v", err)
+	}
 
 	tests := []struct {
 		name   string
@@ -150,3 +159,255 @@ func TestDecryptorSeeking(t *testing.T) {
 		})
 	}
 }
+
+func encryptPayload(t *testing.T, key, payload, ad []byte) []byte {
+	streamer, err := crypto.NewGCMStreamer(key)
+	if err != nil {
+		t.Fatalf("Failed to create streamer: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := streamer.EncryptStream(&buf, bytes.NewReader(payload), ad); err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func openBlob(key, blob, ad []byte) ([]byte, error) {
+	d, err := crypto.NewDecryptor(bytes.NewReader(blob), key, int64(len(blob)), ad)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(d)
+}
+
+func TestEncryptedSize(t *testing.T) {
+	key := make([]byte, crypto.KeySize)
+
+	for _, size := range []int{0, 1, crypto.GCMChunkSize - 1, crypto.GCMChunkSize, crypto.GCMChunkSize + 1, 3 * crypto.GCMChunkSize} {
+		blob := encryptPayload(t, key, make([]byte, size), nil)
+		if got := crypto.EncryptedSize(int64(size)); got != int64(len(blob)) {
+			t.Errorf("EncryptedSize(%d) = %d, actual %d", size, got, len(blob))
+		}
+
+		plain, err := openBlob(key, blob, nil)
+		if err != nil {
+			t.Fatalf("Roundtrip of %d bytes failed: %v", size, err)
+		}
+		if len(plain) != size {
+			t.Errorf("Roundtrip of %d bytes returned %d", size, len(plain))
+		}
+	}
+}
+
+func TestDecryptorRejectsTruncation(t *testing.T) {
+	key := make([]byte, crypto.KeySize)
+	payload := make([]byte, crypto.GCMChunkSize*3)
+	blob := encryptPayload(t, key, payload, []byte("id"))
+
+	chunk := crypto.GCMChunkSize + crypto.TagSize
+	header := len(blob) - 3*chunk
+
+	for _, cut := range []int{header + 2*chunk, header + chunk, header + 5, header} {
+		if _, err := openBlob(key, blob[:cut], []byte("id")); err == nil {
+			t.Errorf("Truncation to %d bytes was not detected", cut)
+		}
+	}
+
+	_, err := openBlob(key, blob[:header+2*chunk], []byte("id"))
+	if !errors.Is(err, crypto.ErrTampered) {
+		t.Errorf("Chunk-boundary truncation: want ErrTampered, got %v", err)
+	}
+}
+
+func TestDecryptorRejectsReorderAndSplice(t *testing.T) {
+	key := make([]byte, crypto.KeySize)
+	payload := make([]byte, crypto.GCMChunkSize*3)
+	for i := range payload {
+		payload[i] = byte(i / crypto.GCMChunkSize)
+	}
+
+	blob := encryptPayload(t, key, payload, []byte("file-a"))
+	chunk := crypto.GCMChunkSize + crypto.TagSize
+	header := len(blob) - 3*chunk
+
+	swapped := append([]byte{}, blob...)
+	copy(swapped[header:], blob[header+chunk:header+2*chunk])
+	copy(swapped[header+chunk:], blob[header:header+chunk])
+	if _, err := openBlob(key, swapped, []byte("file-a")); !errors.Is(err, crypto.ErrTampered) {
+		t.Errorf("Reordered chunks: want ErrTampered, got %v", err)
+	}
+
+	if _, err := openBlob(key, blob, []byte("file-b")); !errors.Is(err, crypto.ErrTampered) {
+		t.Errorf("Wrong file binding: want ErrTampered, got %v", err)
+	}
+
+	other := encryptPayload(t, key, payload, []byte("file-b"))
+	spliced := append([]byte{}, blob...)
+	copy(spliced[header:], other[header:header+chunk])
+	if _, err := openBlob(key, spliced, []byte("file-a")); !errors.Is(err, crypto.ErrTampered) {
+		t.Errorf("Cross-file splice: want ErrTampered, got %v", err)
+	}
+}
+
+func TestDecryptorReadsLegacyFormat(t *testing.T) {
+	key := make([]byte, crypto.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, crypto.GCMChunkSize*2+100)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	streamer, _ := crypto.NewGCMStreamer(key)
+	var legacy []byte
+	nonce := make([]byte, crypto.NonceSize)
+	for idx := 0; idx*crypto.GCMChunkSize < len(payload); idx++ {
+		end := min((idx+1)*crypto.GCMChunkSize, len(payload))
+		binary.BigEndian.PutUint64(nonce[4:], uint64(idx))
+		legacy = streamer.AEAD.Seal(legacy, nonce, payload[idx*crypto.GCMChunkSize:end], nil)
+	}
+
+	plain, err := openBlob(key, legacy, []byte("ignored-for-legacy"))
+	if err != nil {
+		t.Fatalf("Legacy blob rejected: %v", err)
+	}
+	if !bytes.Equal(plain, payload) {
+		t.Error("Legacy blob decrypted incorrectly")
+	}
+}
+
+func TestConvergentKey(t *testing.T) {
+	digest := bytes.Repeat([]byte{0xab}, 32)
+
+	plain := crypto.ConvergentKey(digest, nil)
+	if !bytes.Equal(plain, digest[:crypto.KeySize]) {
+		t.Error("Unkeyed convergent key should be the truncated digest")
+	}
+
+	keyedA := crypto.ConvergentKey(digest, []byte("secret-a-0123456789"))
+	keyedA2 := crypto.ConvergentKey(digest, []byte("secret-a-0123456789"))
+	keyedB := crypto.ConvergentKey(digest, []byte("secret-b-0123456789"))
+
+	if !bytes.Equal(keyedA, keyedA2) {
+		t.Error("Keyed convergent key is not deterministic")
+	}
+	if bytes.Equal(keyedA, plain) || bytes.Equal(keyedA, keyedB) {
+		t.Error("Secret does not influence the convergent key")
+	}
+	if len(keyedA) != crypto.KeySize {
+		t.Errorf("Expected key length %d, got %d", crypto.KeySize, len(keyedA))
+	}
+}
+
+type countingReader struct {
+	io.ReadSeeker
+	reads int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.reads++
+	return c.ReadSeeker.Read(p)
+}
+
+func TestDecryptorCachesChunks(t *testing.T) {
+	key := make([]byte, crypto.KeySize)
+	payload := make([]byte, 6*crypto.GCMChunkSize)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+	blob := encryptPayload(t, key, payload, []byte("id"))
+
+	src := &countingReader{ReadSeeker: bytes.NewReader(blob)}
+	d, err := crypto.NewDecryptor(src, key, int64(len(blob)), []byte("id"))
+	if err != nil {
+		t.Fatalf("NewDecryptor failed: %v", err)
+	}
+
+	buf := make([]byte, 1024)
+	offsets := []int64{10, 2*crypto.GCMChunkSize + 5, 4000, 2*crypto.GCMChunkSize + 900, 6*crypto.GCMChunkSize - 1024}
+
+	src.reads = 0
+	for range 3 {
+		for _, off := range offsets {
+			n, err := d.ReadAt(buf, off)
+			if err != nil || !bytes.Equal(buf[:n], payload[off:off+int64(n)]) {
+				t.Fatalf("ReadAt(%d) mismatch: %v", off, err)
+			}
+		}
+	}
+	if src.reads != 2 {
+		t.Errorf("Repeated ranges read the source %d times, want 2", src.reads)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := d.Seek(offsets[1], io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Cached Read allocated %.0f times", allocs)
+	}
+}
+
+func TestDecryptorConcurrentReadAt(t *testing.T) {
+	key := make([]byte, crypto.KeySize)
+	payload := make([]byte, 8*crypto.GCMChunkSize+77)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+	blob := encryptPayload(t, key, payload, []byte("id"))
+
+	d, err := crypto.NewDecryptor(bytes.NewReader(blob), key, int64(len(blob)), []byte("id"), crypto.WithReadahead(2))
+	if err != nil {
+		t.Fatalf("NewDecryptor failed: %v", err)
+	}
+	defer func() { _ = d.Close() }()
+
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 3000)
+			for i := range 50 {
+				off := int64((worker*7919 + i*104729) % (len(payload) - len(buf)))
+				if _, err := d.ReadAt(buf, off); err != nil || !bytes.Equal(buf, payload[off:off+int64(len(buf))]) {
+					t.Errorf("ReadAt(%d) mismatch: %v", off, err)
+					return
+				}
+			}
+		}()
+	}
+
+	plain, err := io.ReadAll(d)
+	wg.Wait()
+	if err != nil || !bytes.Equal(plain, payload) {
+		t.Errorf("Sequential read alongside ReadAt mismatch: %v", err)
+	}
+}
+
+func BenchmarkDecryptorRanges(b *testing.B) {
+	key := make([]byte, crypto.KeySize)
+	blob := encryptWith(b, key, make([]byte, 16*crypto.GCMChunkSize), nil)
+
+	d, err := crypto.NewDecryptor(bytes.NewReader(blob), key, int64(len(blob)), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+
+	for i := 0; b.Loop(); i++ {
+		off := int64(3*crypto.GCMChunkSize + (i%32)*2048)
+		if _, err := d.ReadAt(buf, off); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
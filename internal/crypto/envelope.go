@@ -0,0 +1,119 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EnvelopeHeaderSize is the header of a FormatEnvelope blob: the common header
+// fields, a random wrapping nonce and the data key sealed under the file key.
+const EnvelopeHeaderSize = headerFieldsSize + NonceSize + KeySize + TagSize
+
+var ErrEnvelopeUnsealed = errors.New("envelope stream not written")
+
+// Envelope encrypts a stream under a random data key and wraps that key with
+// the file key afterwards. Writers that derive the file key from the content
+// can then encrypt and hash in a single pass over the plaintext.
+type Envelope struct {
+	dataKey []byte
+	header  Header
+	start   int64
+	written bool
+	opts    []Option
+}
+
+func NewEnvelope(opts ...Option) (*Envelope, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	return &Envelope{
+		dataKey: dataKey,
+		opts:    opts,
+		header: Header{
+			Version:   FormatEnvelope,
+			Suite:     SuiteAES128GCM,
+			ChunkSize: GCMChunkSize,
+			Length:    UnknownLength,
+		},
+	}, nil
+}
+
+// EncryptStream writes a placeholder header followed by the encrypted stream.
+// The blob cannot be opened until Seal has written the real header.
+func (e *Envelope) EncryptStream(dst io.WriteSeeker, src io.Reader) error {
+	start, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to locate header: %w", err)
+	}
+
+	if _, err := dst.Write(make([]byte, EnvelopeHeaderSize)); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	streamer, err := NewGCMStreamer(e.dataKey, e.opts...)
+	if err != nil {
+		return err
+	}
+
+	length, err := streamer.encryptChunks(dst, src, e.header.chunkAAD(nil))
+	if err != nil {
+		return err
+	}
+
+	e.start = start
+	e.header.Length = length
+	e.written = true
+	return nil
+}
+
+// Seal wraps the data key under key, binding it to ad, and writes the header
+// in front of the stream written by EncryptStream.
+func (e *Envelope) Seal(dst io.WriteSeeker, key, ad []byte) error {
+	if !e.written {
+		return ErrEnvelopeUnsealed
+	}
+
+	streamer, err := NewGCMStreamer(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate wrapping nonce: %w", err)
+	}
+
+	fields := append(e.header.marshal(), nonce...)
+	sealed := streamer.AEAD.Seal(fields, nonce, e.dataKey, streamAAD(fields, ad))
+
+	if _, err := dst.Seek(e.start, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to header: %w", err)
+	}
+	if _, err := dst.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	if _, err := dst.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("failed to seek to end: %w", err)
+	}
+
+	return nil
+}
+
+// openEnvelope authenticates an envelope header with the file key and returns
+// it together with the unwrapped data key.
+func openEnvelope(streamer *GCMStreamer, raw, ad []byte) (Header, []byte, error) {
+	fields := raw[:headerFieldsSize+NonceSize]
+	nonce := fields[headerFieldsSize:]
+
+	dataKey, err := streamer.AEAD.Open(nil, nonce, raw[len(fields):EnvelopeHeaderSize], streamAAD(fields, ad))
+	if err != nil {
+		return Header{}, nil, fmt.Errorf("%w: %w", ErrInvalidHeader, ErrTampered)
+	}
+
+	h, err := parseHeader(fields[:headerFieldsSize])
+	return h, dataKey, err
+}
@@ -0,0 +1,104 @@
+package crypto_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skidoodle/safebin/internal/crypto"
+)
+
+func sealEnvelope(t *testing.T, key, payload, ad []byte) []byte {
+	path := filepath.Join(t.TempDir(), "blob")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	envelope, err := crypto.NewEnvelope()
+	if err != nil {
+		t.Fatalf("NewEnvelope failed: %v", err)
+	}
+	if err := envelope.EncryptStream(f, bytes.NewReader(payload)); err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	if err := envelope.Seal(f, key, ad); err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	return blob
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, crypto.KeySize)
+	payload := bytes.Repeat([]byte("envelope"), crypto.GCMChunkSize/4)
+
+	blob := sealEnvelope(t, key, payload, []byte("id"))
+	if blob[4] != crypto.FormatEnvelope {
+		t.Fatalf("Version = %d, want %d", blob[4], crypto.FormatEnvelope)
+	}
+
+	d, err := crypto.NewDecryptor(bytes.NewReader(blob), key, int64(len(blob)), []byte("id"))
+	if err != nil {
+		t.Fatalf("NewDecryptor failed: %v", err)
+	}
+	if d.Format() != crypto.FormatEnvelope || d.Size() != int64(len(payload)) {
+		t.Errorf("Format %d size %d", d.Format(), d.Size())
+	}
+
+	if _, err := d.Seek(crypto.GCMChunkSize+3, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	tail, err := io.ReadAll(d)
+	if err != nil || !bytes.Equal(tail, payload[crypto.GCMChunkSize+3:]) {
+		t.Errorf("Ranged read mismatch: %v", err)
+	}
+
+	again := sealEnvelope(t, key, payload, []byte("id"))
+	if bytes.Equal(blob, again) {
+		t.Error("Two envelopes of the same content share a data key")
+	}
+}
+
+func TestEnvelopeRejectsWrongKeyAndAD(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, crypto.KeySize)
+	blob := sealEnvelope(t, key, []byte("payload"), []byte("id"))
+
+	otherKey := bytes.Repeat([]byte{8}, crypto.KeySize)
+	if _, err := crypto.NewDecryptor(bytes.NewReader(blob), otherKey, int64(len(blob)), []byte("id")); !errors.Is(err, crypto.ErrTampered) {
+		t.Errorf("Wrong key: want ErrTampered, got %v", err)
+	}
+	if _, err := crypto.NewDecryptor(bytes.NewReader(blob), key, int64(len(blob)), []byte("other")); !errors.Is(err, crypto.ErrTampered) {
+		t.Errorf("Wrong AD: want ErrTampered, got %v", err)
+	}
+
+	blob[crypto.EnvelopeHeaderSize] ^= 1
+	if _, err := crypto.NewDecryptor(bytes.NewReader(blob), key, int64(len(blob)), []byte("id")); !errors.Is(err, crypto.ErrTampered) {
+		t.Errorf("Flipped body: want ErrTampered, got %v", err)
+	}
+}
+
+func TestEnvelopeSealRequiresStream(t *testing.T) {
+	envelope, err := crypto.NewEnvelope()
+	if err != nil {
+		t.Fatalf("NewEnvelope failed: %v", err)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "blob"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := envelope.Seal(f, make([]byte, crypto.KeySize), nil); !errors.Is(err, crypto.ErrEnvelopeUnsealed) {
+		t.Errorf("Seal before stream: want ErrEnvelopeUnsealed, got %v", err)
+	}
+}
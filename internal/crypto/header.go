@@ -0,0 +1,118 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	SuiteAES128GCM = 1
+
+	UnknownLength = ^uint64(0)
+	MaxChunkSize  = 16 << 20
+
+	headerFieldsSize = 18
+	HeaderSize       = headerFieldsSize + TagSize
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported blob format")
+	ErrInvalidHeader     = errors.New("invalid blob header")
+)
+
+type Header struct {
+	Version   byte
+	Suite     byte
+	ChunkSize uint32
+	Length    uint64
+}
+
+func (h Header) marshal() []byte {
+	buf := make([]byte, headerFieldsSize)
+	copy(buf, Magic)
+	buf[4] = h.Version
+	buf[5] = h.Suite
+	binary.BigEndian.PutUint32(buf[6:10], h.ChunkSize)
+	binary.BigEndian.PutUint64(buf[10:18], h.Length)
+	return buf
+}
+
+func (h Header) chunkAAD(ad []byte) []byte {
+	return streamAAD(h.marshal()[:10], ad)
+}
+
+func headerNonce() []byte {
+	return bytes.Repeat([]byte{0xff}, NonceSize)
+}
+
+func sealHeader(aead cipher.AEAD, h Header, ad []byte) []byte {
+	fields := h.marshal()
+	return aead.Seal(fields, headerNonce(), nil, streamAAD(fields, ad))
+}
+
+func openHeader(aead cipher.AEAD, raw, ad []byte) (Header, error) {
+	fields := raw[:headerFieldsSize]
+	if _, err := aead.Open(nil, headerNonce(), raw[headerFieldsSize:HeaderSize], streamAAD(fields, ad)); err != nil {
+		return Header{}, fmt.Errorf("%w: %w", ErrInvalidHeader, ErrTampered)
+	}
+
+	return parseHeader(fields)
+}
+
+func parseHeader(fields []byte) (Header, error) {
+	h := Header{
+		Version:   fields[4],
+		Suite:     fields[5],
+		ChunkSize: binary.BigEndian.Uint32(fields[6:10]),
+		Length:    binary.BigEndian.Uint64(fields[10:18]),
+	}
+
+	if h.Suite != SuiteAES128GCM {
+		return h, fmt.Errorf("%w: cipher suite %d", ErrUnsupportedFormat, h.Suite)
+	}
+	if h.ChunkSize == 0 || h.ChunkSize > MaxChunkSize {
+		return h, fmt.Errorf("%w: chunk size %d", ErrInvalidHeader, h.ChunkSize)
+	}
+
+	return h, nil
+}
+
+func sniffFormat(readSeeker io.ReadSeeker, encryptedSize int64) (int, []byte, error) {
+	if encryptedSize < int64(len(Magic))+1 {
+		return FormatLegacy, nil, nil
+	}
+
+	if _, err := readSeeker.Seek(0, io.SeekStart); err != nil {
+		return 0, nil, fmt.Errorf("failed to seek: %w", err)
+	}
+
+	head := make([]byte, min(encryptedSize, EnvelopeHeaderSize))
+	if _, err := io.ReadFull(readSeeker, head); err != nil {
+		return 0, nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	if !bytes.Equal(head[:len(Magic)], Magic) {
+		return FormatLegacy, nil, nil
+	}
+
+	switch version := int(head[len(Magic)]); version {
+	case FormatStream:
+		return FormatStream, head[:len(Magic)+1], nil
+	case FormatHeader:
+		if len(head) < HeaderSize {
+			return 0, nil, ErrTruncated
+		}
+		return FormatHeader, head[:HeaderSize], nil
+	case FormatEnvelope:
+		if len(head) < EnvelopeHeaderSize {
+			return 0, nil, ErrTruncated
+		}
+		return FormatEnvelope, head, nil
+	default:
+		return 0, nil, fmt.Errorf("%w: version %d", ErrUnsupportedFormat, version)
+	}
+}
@@ -0,0 +1,141 @@
+package crypto_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skidoodle/safebin/internal/crypto"
+)
+
+func encryptToFile(t *testing.T, key, payload, ad []byte) []byte {
+	path := filepath.Join(t.TempDir(), "blob")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	streamer, _ := crypto.NewGCMStreamer(key)
+	if err := streamer.EncryptStream(f, bytes.NewReader(payload), ad); err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	return blob
+}
+
+func TestHeaderRecordsLayout(t *testing.T) {
+	key := make([]byte, crypto.KeySize)
+	payload := make([]byte, crypto.GCMChunkSize+10)
+
+	blob := encryptToFile(t, key, payload, []byte("id"))
+
+	if !bytes.HasPrefix(blob, crypto.Magic) {
+		t.Fatal("Blob does not start with magic")
+	}
+	if blob[4] != crypto.FormatHeader || blob[5] != crypto.SuiteAES128GCM {
+		t.Errorf("Unexpected version/suite: %d/%d", blob[4], blob[5])
+	}
+	if got := binary.BigEndian.Uint32(blob[6:10]); got != crypto.GCMChunkSize {
+		t.Errorf("Chunk size = %d, want %d", got, crypto.GCMChunkSize)
+	}
+	if got := binary.BigEndian.Uint64(blob[10:18]); got != uint64(len(payload)) {
+		t.Errorf("Length = %d, want %d", got, len(payload))
+	}
+
+	d, err := crypto.NewDecryptor(bytes.NewReader(blob), key, int64(len(blob)), []byte("id"))
+	if err != nil {
+		t.Fatalf("NewDecryptor failed: %v", err)
+	}
+	if d.Format() != crypto.FormatHeader || d.Size() != int64(len(payload)) {
+		t.Errorf("Format %d size %d", d.Format(), d.Size())
+	}
+}
+
+func TestHeaderIsAuthenticated(t *testing.T) {
+	key := make([]byte, crypto.KeySize)
+	blob := encryptToFile(t, key, []byte("payload"), []byte("id"))
+
+	for _, pos := range []int{5, 8, 17, 20} {
+		tampered := append([]byte{}, blob...)
+		tampered[pos] ^= 0x01
+
+		if _, err := openBlob(key, tampered, []byte("id")); err == nil {
+			t.Errorf("Tampering header byte %d was not detected", pos)
+		}
+	}
+
+	future := append([]byte{}, blob...)
+	future[4] = 99
+	if _, err := openBlob(key, future, []byte("id")); !errors.Is(err, crypto.ErrUnsupportedFormat) {
+		t.Errorf("Unknown version: want ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestFormatsCoexist(t *testing.T) {
+	key := make([]byte, crypto.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, crypto.GCMChunkSize+500)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+	ad := []byte("file-id")
+
+	streamer, _ := crypto.NewGCMStreamer(key)
+	nonce := make([]byte, crypto.NonceSize)
+
+	var legacy []byte
+	for idx, off := 0, 0; off < len(payload); idx, off = idx+1, off+crypto.GCMChunkSize {
+		clear(nonce)
+		binary.BigEndian.PutUint64(nonce[4:], uint64(idx))
+		legacy = streamer.AEAD.Seal(legacy, nonce, payload[off:min(off+crypto.GCMChunkSize, len(payload))], nil)
+	}
+
+	v1 := append(append([]byte{}, crypto.Magic...), crypto.FormatStream)
+	aad := append(append([]byte{}, v1...), ad...)
+	for idx, off := 0, 0; off < len(payload); idx, off = idx+1, off+crypto.GCMChunkSize {
+		end := min(off+crypto.GCMChunkSize, len(payload))
+		clear(nonce)
+		binary.BigEndian.PutUint64(nonce[3:11], uint64(idx))
+		if end == len(payload) {
+			nonce[11] = 1
+		}
+		v1 = streamer.AEAD.Seal(v1, nonce, payload[off:end], aad)
+	}
+
+	blobs := map[int][]byte{
+		crypto.FormatLegacy: legacy,
+		crypto.FormatStream: v1,
+		crypto.FormatHeader: encryptToFile(t, key, payload, ad),
+	}
+
+	for format, blob := range blobs {
+		d, err := crypto.NewDecryptor(bytes.NewReader(blob), key, int64(len(blob)), ad)
+		if err != nil {
+			t.Fatalf("Format %d rejected: %v", format, err)
+		}
+		if d.Format() != format {
+			t.Errorf("Detected format %d, want %d", d.Format(), format)
+		}
+		plain, err := io.ReadAll(d)
+		if err != nil {
+			t.Fatalf("Format %d read failed: %v", format, err)
+		}
+		if !bytes.Equal(plain, payload) {
+			t.Errorf("Format %d decrypted incorrectly", format)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+const metadataLabel = "safebin metadata key v1"
+
+func metadataKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(metadataLabel))
+	return mac.Sum(nil)[:KeySize]
+}
+
+func SealMetadata(key, plaintext, ad []byte) ([]byte, error) {
+	streamer, err := NewGCMStreamer(metadataKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return streamer.AEAD.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func OpenMetadata(key, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < NonceSize+TagSize {
+		return nil, ErrTruncated
+	}
+
+	streamer, err := NewGCMStreamer(metadataKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := streamer.AEAD.Open(nil, sealed[:NonceSize], sealed[NonceSize:], ad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata: %w", ErrTampered)
+	}
+
+	return plaintext, nil
+}
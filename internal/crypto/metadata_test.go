@@ -0,0 +1,54 @@
+package crypto_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/skidoodle/safebin/internal/crypto"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, crypto.KeySize)
+	record := []byte(`{"name":"report.pdf"}`)
+
+	sealed, err := crypto.SealMetadata(key, record, []byte("file-id"))
+	if err != nil {
+		t.Fatalf("SealMetadata failed: %v", err)
+	}
+
+	if bytes.Contains(sealed, []byte("report")) {
+		t.Error("Sealed metadata leaks plaintext")
+	}
+
+	opened, err := crypto.OpenMetadata(key, sealed, []byte("file-id"))
+	if err != nil {
+		t.Fatalf("OpenMetadata failed: %v", err)
+	}
+	if !bytes.Equal(opened, record) {
+		t.Errorf("Round trip mismatch: %q", opened)
+	}
+
+	if _, err := crypto.OpenMetadata(key, sealed, []byte("other-id")); !errors.Is(err, crypto.ErrTampered) {
+		t.Errorf("Expected ErrTampered for wrong AD, got %v", err)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := crypto.OpenMetadata(key, sealed, []byte("file-id")); !errors.Is(err, crypto.ErrTampered) {
+		t.Errorf("Expected ErrTampered for flipped bit, got %v", err)
+	}
+
+	if _, err := crypto.OpenMetadata(key, sealed[:8], []byte("file-id")); !errors.Is(err, crypto.ErrTruncated) {
+		t.Errorf("Expected ErrTruncated for short record, got %v", err)
+	}
+}
+
+func TestMetadataKeyIsSeparated(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, crypto.KeySize)
+	sealed, _ := crypto.SealMetadata(key, []byte("x"), nil)
+
+	streamer, _ := crypto.NewGCMStreamer(key)
+	if _, err := streamer.AEAD.Open(nil, sealed[:crypto.NonceSize], sealed[crypto.NonceSize:], nil); err == nil {
+		t.Error("Metadata must not be sealed with the blob key itself")
+	}
+}
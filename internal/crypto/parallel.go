@@ -0,0 +1,295 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Option tunes how a GCMStreamer, Envelope or Decryptor processes chunks.
+type Option func(*options)
+
+type options struct {
+	workers   int
+	readahead int
+}
+
+// WithWorkers seals or opens up to n chunks concurrently. Output order is
+// unchanged; n <= 1 keeps the serial implementation.
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		o.workers = n
+	}
+}
+
+// WithReadahead lets a Decryptor fetch and decrypt up to n chunks ahead of
+// sequential reads. A Decryptor with readahead must be closed.
+func WithReadahead(n int) Option {
+	return func(o *options) {
+		o.readahead = n
+	}
+}
+
+func buildOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.workers > 1 && o.readahead <= 0 {
+		o.readahead = 2 * o.workers
+	}
+	return o
+}
+
+var chunkPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, GCMChunkSize+TagSize)
+		return &buf
+	},
+}
+
+func getBuffer(size int) *[]byte {
+	buf := chunkPool.Get().(*[]byte)
+	if cap(*buf) < size {
+		*buf = make([]byte, size)
+	}
+	*buf = (*buf)[:size]
+	return buf
+}
+
+func putBuffer(buf *[]byte) {
+	if buf != nil {
+		chunkPool.Put(buf)
+	}
+}
+
+type sealJob struct {
+	idx   uint64
+	final bool
+	plain *[]byte
+	out   *[]byte
+	done  chan struct{}
+}
+
+// encryptChunksParallel is encryptChunks with sealing spread over workers.
+// A reader goroutine feeds chunks to the workers and, in the same order, to
+// the caller, which writes each one as soon as it has been sealed.
+func encryptChunksParallel(aead cipher.AEAD, dst io.Writer, src io.Reader, aad []byte, workers int) (uint64, error) {
+	jobs := make(chan *sealJob, workers)
+	ordered := make(chan *sealJob, 2*workers)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce := make([]byte, NonceSize)
+			for job := range jobs {
+				streamNonce(nonce, job.idx, job.final)
+				job.out = getBuffer(len(*job.plain) + aead.Overhead())
+				*job.out = aead.Seal((*job.out)[:0], nonce, *job.plain, aad)
+				putBuffer(job.plain)
+				close(job.done)
+			}
+		}()
+	}
+
+	var readErr error
+	go func() {
+		defer close(ordered)
+		defer close(jobs)
+
+		cur := getBuffer(GCMChunkSize)
+		curLen, err := readChunk(src, *cur)
+		if err != nil {
+			putBuffer(cur)
+			readErr = err
+			return
+		}
+
+		for chunkIdx := uint64(0); ; chunkIdx++ {
+			var next *[]byte
+			nextLen := 0
+			if curLen == GCMChunkSize {
+				next = getBuffer(GCMChunkSize)
+				if nextLen, err = readChunk(src, *next); err != nil {
+					putBuffer(cur)
+					putBuffer(next)
+					readErr = err
+					return
+				}
+			}
+
+			*cur = (*cur)[:curLen]
+			job := &sealJob{idx: chunkIdx, final: nextLen == 0, plain: cur, done: make(chan struct{})}
+
+			select {
+			case ordered <- job:
+			case <-stop:
+				putBuffer(cur)
+				putBuffer(next)
+				return
+			}
+			jobs <- job
+
+			if job.final {
+				putBuffer(next)
+				return
+			}
+			cur, curLen = next, nextLen
+		}
+	}()
+
+	var length uint64
+	var writeErr error
+	for job := range ordered {
+		<-job.done
+		if writeErr == nil {
+			if _, err := dst.Write(*job.out); err != nil {
+				writeErr = fmt.Errorf("failed to write ciphertext: %w", err)
+				close(stop)
+			}
+			length += uint64(len(*job.out) - aead.Overhead())
+		}
+		putBuffer(job.out)
+	}
+	wg.Wait()
+
+	if writeErr != nil {
+		return 0, writeErr
+	}
+	if readErr != nil {
+		return 0, readErr
+	}
+	return length, nil
+}
+
+type openJob struct {
+	idx    int64
+	sealed *[]byte
+	plain  *[]byte
+	data   []byte
+	err    error
+	done   chan struct{}
+}
+
+// prefetcher reads sealed chunks from a Decryptor's source in order and
+// hands them to workers, keeping at most readahead results queued.
+type prefetcher struct {
+	next    int64
+	results chan *openJob
+	stop    chan struct{}
+	exited  chan struct{}
+}
+
+func (d *Decryptor) startPrefetch(from int64) {
+	p := &prefetcher{
+		next:    from,
+		results: make(chan *openJob, d.opts.readahead),
+		stop:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	d.prefetch = p
+
+	jobs := make(chan *openJob, d.opts.readahead)
+
+	var wg sync.WaitGroup
+	for range max(1, d.opts.workers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce := make([]byte, NonceSize)
+			for job := range jobs {
+				job.plain = getBuffer(int(d.chunkSize))
+				job.data, job.err = d.openChunk(job.idx, nonce, (*job.plain)[:0], *job.sealed)
+				putBuffer(job.sealed)
+				job.sealed = nil
+				close(job.done)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(p.exited)
+		defer close(p.results)
+		defer wg.Wait()
+		defer close(jobs)
+
+		for idx := from; idx < d.chunks; idx++ {
+			job := &openJob{idx: idx, done: make(chan struct{})}
+
+			buf := getBuffer(int(d.chunkSize) + d.aead.Overhead())
+			n, err := d.readSealed(idx, *buf)
+			if err != nil {
+				putBuffer(buf)
+				job.err = err
+				close(job.done)
+			} else {
+				*buf = (*buf)[:n]
+				job.sealed = buf
+			}
+
+			select {
+			case p.results <- job:
+			case <-p.stop:
+				putBuffer(job.sealed)
+				return
+			}
+
+			if err != nil {
+				return
+			}
+			jobs <- job
+		}
+	}()
+}
+
+// prefetched returns the plaintext of chunk idx from the readahead pipeline,
+// restarting the pipeline when the reader has moved elsewhere.
+func (d *Decryptor) prefetched(idx int64) ([]byte, error) {
+	if d.current != nil && d.current.idx == idx {
+		return d.current.data, d.current.err
+	}
+
+	if d.prefetch == nil || d.prefetch.next != idx {
+		d.stopPrefetch()
+		d.startPrefetch(idx)
+	}
+
+	job, ok := <-d.prefetch.results
+	if !ok {
+		return nil, io.ErrUnexpectedEOF
+	}
+	<-job.done
+	d.prefetch.next++
+
+	d.releaseCurrent()
+	d.current = job
+	return job.data, job.err
+}
+
+func (d *Decryptor) stopPrefetch() {
+	p := d.prefetch
+	if p == nil {
+		return
+	}
+
+	close(p.stop)
+	for job := range p.results {
+		<-job.done
+		putBuffer(job.plain)
+	}
+	<-p.exited
+
+	d.prefetch = nil
+	d.phyOffset = -1
+}
+
+func (d *Decryptor) releaseCurrent() {
+	if d.current != nil {
+		putBuffer(d.current.plain)
+		d.current = nil
+	}
+}
@@ -0,0 +1,142 @@
+package crypto_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/skidoodle/safebin/internal/crypto"
+)
+
+func encryptWith(t testing.TB, key, payload, ad []byte, opts ...crypto.Option) []byte {
+	streamer, err := crypto.NewGCMStreamer(key, opts...)
+	if err != nil {
+		t.Fatalf("Failed to create streamer: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := streamer.EncryptStream(&buf, bytes.NewReader(payload), ad); err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParallelEncryptMatchesSerial(t *testing.T) {
+	key := make([]byte, crypto.KeySize)
+
+	for _, size := range []int{0, 1, crypto.GCMChunkSize, crypto.GCMChunkSize + 1, 9*crypto.GCMChunkSize + 7} {
+		payload := make([]byte, size)
+		if _, err := rand.Read(payload); err != nil {
+			t.Fatal(err)
+		}
+
+		serial := encryptWith(t, key, payload, []byte("id"))
+		parallel := encryptWith(t, key, payload, []byte("id"), crypto.WithWorkers(4))
+		if !bytes.Equal(serial, parallel) {
+			t.Errorf("Parallel ciphertext of %d bytes differs from serial", size)
+		}
+	}
+}
+
+func TestReadaheadDecryptor(t *testing.T) {
+	key := make([]byte, crypto.KeySize)
+	payload := make([]byte, 10*crypto.GCMChunkSize+123)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+	blob := encryptPayload(t, key, payload, []byte("id"))
+
+	d, err := crypto.NewDecryptor(bytes.NewReader(blob), key, int64(len(blob)), []byte("id"), crypto.WithWorkers(4), crypto.WithReadahead(3))
+	if err != nil {
+		t.Fatalf("NewDecryptor failed: %v", err)
+	}
+	defer func() { _ = d.Close() }()
+
+	plain, err := io.ReadAll(d)
+	if err != nil || !bytes.Equal(plain, payload) {
+		t.Fatalf("Sequential read mismatch: %v", err)
+	}
+
+	for _, offset := range []int64{5*crypto.GCMChunkSize + 9, 17, int64(len(payload)) - 1} {
+		if _, err := d.Seek(offset, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		buf := make([]byte, 2*crypto.GCMChunkSize)
+		n, err := io.ReadFull(d, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("Read at %d failed: %v", offset, err)
+		}
+		if !bytes.Equal(buf[:n], payload[offset:offset+int64(n)]) {
+			t.Errorf("Data mismatch at offset %d", offset)
+		}
+	}
+
+	blob[len(blob)-3*crypto.GCMChunkSize] ^= 1
+	tampered, err := crypto.NewDecryptor(bytes.NewReader(blob), key, int64(len(blob)), []byte("id"), crypto.WithReadahead(2))
+	if err != nil {
+		t.Fatalf("NewDecryptor failed: %v", err)
+	}
+	defer func() { _ = tampered.Close() }()
+
+	if _, err := io.ReadAll(tampered); !errors.Is(err, crypto.ErrTampered) {
+		t.Errorf("Tampered chunk with readahead: want ErrTampered, got %v", err)
+	}
+}
+
+const benchPayloadSize = 64 << 20
+
+var benchModes = []struct {
+	name string
+	opts []crypto.Option
+}{
+	{"serial", nil},
+	{"workers4", []crypto.Option{crypto.WithWorkers(4), crypto.WithReadahead(8)}},
+	{"workers8", []crypto.Option{crypto.WithWorkers(8), crypto.WithReadahead(16)}},
+}
+
+func BenchmarkEncryptStream(b *testing.B) {
+	key := make([]byte, crypto.KeySize)
+	payload := make([]byte, benchPayloadSize)
+
+	for _, mode := range benchModes {
+		b.Run(mode.name, func(b *testing.B) {
+			streamer, err := crypto.NewGCMStreamer(key, mode.opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(benchPayloadSize)
+			b.ReportAllocs()
+
+			for b.Loop() {
+				if err := streamer.EncryptStream(io.Discard, bytes.NewReader(payload), nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecryptor(b *testing.B) {
+	key := make([]byte, crypto.KeySize)
+	blob := encryptWith(b, key, make([]byte, benchPayloadSize), nil)
+
+	for _, mode := range benchModes {
+		b.Run(mode.name, func(b *testing.B) {
+			b.SetBytes(benchPayloadSize)
+			b.ReportAllocs()
+
+			for b.Loop() {
+				d, err := crypto.NewDecryptor(bytes.NewReader(blob), key, int64(len(blob)), nil, mode.opts...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n, err := io.Copy(io.Discard, d); err != nil || n != benchPayloadSize {
+					b.Fatalf("Copied %d bytes: %v", n, err)
+				}
+				_ = d.Close()
+			}
+		})
+	}
+}
@@ -2,56 +2,201 @@ package crypto
 
 import (
 	"crypto/cipher"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 )
 
 var ErrInvalidWhence = errors.New("invalid whence")
 var ErrNegativeBias = errors.New("negative bias")
 
+// decryptCacheSize is how many decrypted chunks a Decryptor keeps, enough for
+// players that alternate between a few nearby ranges.
+const decryptCacheSize = 4
+
+type cachedChunk struct {
+	idx  int64
+	buf  []byte
+	data []byte
+	used uint64
+}
+
 type Decryptor struct {
 	readSeeker io.ReadSeeker
 	aead       cipher.AEAD
+	format     int
+	aad        []byte
+	chunkSize  int64
+	dataOffset int64
+	lastChunk  int64
+	chunks     int64
 	size       int64
 	offset     int64
 	phyOffset  int64
-}
 
-func NewDecryptor(readSeeker io.ReadSeeker, aead cipher.AEAD, encryptedSize int64) *Decryptor {
-	overhead := int64(aead.Overhead())
-	chunkWithOverhead := int64(GCMChunkSize) + overhead
+	mu       sync.Mutex
+	opts     options
+	nonce    []byte
+	sealed   []byte
+	cache    [decryptCacheSize]cachedChunk
+	tick     uint64
+	prefetch *prefetcher
+	current  *openJob
+}
 
-	fullBlocks := encryptedSize / chunkWithOverhead
-	remainder := encryptedSize % chunkWithOverhead
+func NewDecryptor(readSeeker io.ReadSeeker, key []byte, encryptedSize int64, ad []byte, opts ...Option) (*Decryptor, error) {
+	streamer, err := NewGCMStreamer(key)
+	if err != nil {
+		return nil, err
+	}
 
-	plainSize := fullBlocks * GCMChunkSize
-	if remainder > overhead {
-		plainSize += (remainder - overhead)
+	format, head, err := sniffFormat(readSeeker, encryptedSize)
+	if err != nil {
+		return nil, err
 	}
 
-	return &Decryptor{
+	d := &Decryptor{
 		readSeeker: readSeeker,
-		aead:       aead,
-		size:       plainSize,
-		offset:     0,
+		aead:       streamer.AEAD,
+		format:     format,
+		chunkSize:  GCMChunkSize,
 		phyOffset:  -1,
+		opts:       buildOptions(opts),
+	}
+
+	length := UnknownLength
+
+	switch format {
+	case FormatStream:
+		d.aad = streamAAD(head, ad)
+		d.dataOffset = int64(len(head))
+	case FormatHeader:
+		header, err := openHeader(d.aead, head, ad)
+		if err != nil {
+			return nil, err
+		}
+		d.aad = header.chunkAAD(ad)
+		d.chunkSize = int64(header.ChunkSize)
+		d.dataOffset = HeaderSize
+		length = header.Length
+	case FormatEnvelope:
+		header, dataKey, err := openEnvelope(streamer, head, ad)
+		if err != nil {
+			return nil, err
+		}
+		data, err := NewGCMStreamer(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		d.aead = data.AEAD
+		d.aad = header.chunkAAD(nil)
+		d.chunkSize = int64(header.ChunkSize)
+		d.dataOffset = EnvelopeHeaderSize
+		length = header.Length
+	}
+
+	overhead := int64(d.aead.Overhead())
+	chunkWithOverhead := d.chunkSize + overhead
+	body := encryptedSize - d.dataOffset
+
+	fullBlocks := body / chunkWithOverhead
+	remainder := body % chunkWithOverhead
+
+	if format == FormatLegacy {
+		d.size = fullBlocks * d.chunkSize
+		d.chunks = fullBlocks
+		if remainder > overhead {
+			d.size += remainder - overhead
+			d.chunks++
+		}
+		return d, nil
+	}
+
+	switch {
+	case remainder == 0 && fullBlocks == 0:
+		return nil, ErrTruncated
+	case remainder == 0:
+		d.lastChunk = fullBlocks - 1
+		d.size = fullBlocks * d.chunkSize
+	case remainder < overhead:
+		return nil, ErrTruncated
+	default:
+		d.lastChunk = fullBlocks
+		d.size = fullBlocks*d.chunkSize + remainder - overhead
 	}
+
+	d.chunks = d.lastChunk + 1
+
+	if length != UnknownLength && uint64(d.size) != length {
+		return nil, fmt.Errorf("%w: header records %d bytes, blob holds %d", ErrTruncated, length, d.size)
+	}
+
+	if _, err := d.readChunk(d.lastChunk); err != nil {
+		return nil, err
+	}
+
+	return d, nil
 }
 
-func (d *Decryptor) Read(buf []byte) (int, error) {
-	if d.offset >= d.size {
-		return 0, io.EOF
+func (d *Decryptor) Format() int {
+	return d.format
+}
+
+func (d *Decryptor) Size() int64 {
+	return d.size
+}
+
+// readChunk returns the plaintext of a chunk, decrypting it into the least
+// recently used cache slot on a miss. The result is only valid until a later
+// call evicts it.
+func (d *Decryptor) readChunk(chunkIdx int64) ([]byte, error) {
+	d.tick++
+
+	slot := &d.cache[0]
+	for i := range d.cache {
+		entry := &d.cache[i]
+		if entry.data != nil && entry.idx == chunkIdx {
+			entry.used = d.tick
+			return entry.data, nil
+		}
+		if entry.used < slot.used {
+			slot = entry
+		}
 	}
 
-	chunkIdx := d.offset / GCMChunkSize
-	overhang := d.offset % GCMChunkSize
+	if d.sealed == nil {
+		d.sealed = make([]byte, d.chunkSize+int64(d.aead.Overhead()))
+		d.nonce = make([]byte, NonceSize)
+	}
+	if slot.buf == nil {
+		slot.buf = make([]byte, 0, d.chunkSize)
+	}
 
-	overhead := int64(d.aead.Overhead())
-	actualChunkSize := int64(GCMChunkSize) + overhead
+	slot.data = nil
+	bytesRead, err := d.readSealed(chunkIdx, d.sealed)
+	if err != nil {
+		return nil, err
+	}
 
-	targetOffset := chunkIdx * actualChunkSize
+	plaintext, err := d.openChunk(chunkIdx, d.nonce, slot.buf[:0], d.sealed[:bytesRead])
+	if err != nil {
+		return nil, err
+	}
+
+	slot.idx, slot.data, slot.used = chunkIdx, plaintext, d.tick
+	return plaintext, nil
+}
+
+// readSealed reads the ciphertext of a chunk into buf, which must hold a
+// whole chunk including its tag, and returns the number of bytes read.
+func (d *Decryptor) readSealed(chunkIdx int64, buf []byte) (int, error) {
+	if chunkIdx < 0 {
+		return 0, fmt.Errorf("invalid chunk index")
+	}
+
+	actualChunkSize := d.chunkSize + int64(d.aead.Overhead())
+	targetOffset := d.dataOffset + chunkIdx*actualChunkSize
 
 	if d.phyOffset != targetOffset {
 		if _, err := d.readSeeker.Seek(targetOffset, io.SeekStart); err != nil {
@@ -60,9 +205,7 @@ func (d *Decryptor) Read(buf []byte) (int, error) {
 		d.phyOffset = targetOffset
 	}
 
-	encrypted := make([]byte, actualChunkSize)
-
-	bytesRead, err := io.ReadFull(d.readSeeker, encrypted)
+	bytesRead, err := io.ReadFull(d.readSeeker, buf[:actualChunkSize])
 	if bytesRead > 0 {
 		d.phyOffset += int64(bytesRead)
 	}
@@ -71,15 +214,47 @@ func (d *Decryptor) Read(buf []byte) (int, error) {
 		return 0, fmt.Errorf("failed to read encrypted data: %w", err)
 	}
 
-	nonce := make([]byte, NonceSize)
-	if chunkIdx < 0 {
-		return 0, fmt.Errorf("invalid chunk index")
+	return bytesRead, nil
+}
+
+// openChunk authenticates and decrypts a sealed chunk, appending the
+// plaintext to dst. It only reads Decryptor state, so workers may share it
+// as long as each brings its own nonce buffer.
+func (d *Decryptor) openChunk(chunkIdx int64, nonce, dst, sealed []byte) ([]byte, error) {
+	if d.format == FormatLegacy {
+		legacyNonce(nonce, uint64(chunkIdx))
+	} else {
+		streamNonce(nonce, uint64(chunkIdx), chunkIdx == d.lastChunk)
+	}
+
+	plaintext, err := d.aead.Open(dst, nonce, sealed, d.aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk %d: %w", chunkIdx, ErrTampered)
+	}
+
+	return plaintext, nil
+}
+
+func (d *Decryptor) Read(buf []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.offset >= d.size {
+		return 0, io.EOF
 	}
-	binary.BigEndian.PutUint64(nonce[4:], uint64(chunkIdx))
 
-	plaintext, err := d.aead.Open(nil, nonce, encrypted[:bytesRead], nil)
+	chunkIdx := d.offset / d.chunkSize
+	overhang := d.offset % d.chunkSize
+
+	var plaintext []byte
+	var err error
+	if d.opts.readahead > 0 {
+		plaintext, err = d.prefetched(chunkIdx)
+	} else {
+		plaintext, err = d.readChunk(chunkIdx)
+	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to decrypt: %w", err)
+		return 0, err
 	}
 
 	if overhang >= int64(len(plaintext)) {
@@ -93,7 +268,56 @@ func (d *Decryptor) Read(buf []byte) (int, error) {
 	return nCopied, nil
 }
 
+// ReadAt reads plaintext at off without moving the Read offset. It always
+// goes through the chunk cache, stopping any readahead first, and is safe to
+// call from several goroutines.
+func (d *Decryptor) ReadAt(buf []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeBias
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stopPrefetch()
+
+	nRead := 0
+	for nRead < len(buf) {
+		pos := off + int64(nRead)
+		if pos >= d.size {
+			return nRead, io.EOF
+		}
+
+		plaintext, err := d.readChunk(pos / d.chunkSize)
+		if err != nil {
+			return nRead, err
+		}
+
+		overhang := pos % d.chunkSize
+		if overhang >= int64(len(plaintext)) {
+			return nRead, io.EOF
+		}
+		nRead += copy(buf[nRead:], plaintext[overhang:])
+	}
+
+	return nRead, nil
+}
+
+// Close stops any readahead in flight and returns its buffers. It does not
+// close the underlying reader.
+func (d *Decryptor) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stopPrefetch()
+	d.releaseCurrent()
+	return nil
+}
+
 func (d *Decryptor) Seek(offset int64, whence int) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	var abs int64
 
 	switch whence {
@@ -0,0 +1,52 @@
+// Package slug encodes and parses the last path segment of a share link: the
+// file key in unpadded base64url followed by the original extension. The
+// server and the client package both go through it, so links mean the same
+// thing on either side.
+package slug
+
+import (
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	KeyLength = 16
+	Length    = 22
+
+	// unsafeChars are dropped from extensions so a slug never needs escaping
+	// and cannot break out of the path segment.
+	unsafeChars = "\"<> \\/:;?@[]^`{}|~"
+)
+
+var (
+	ErrTooShort   = errors.New("slug too short")
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// Format builds the slug for key and the extension of filename.
+func Format(key []byte, filename string) string {
+	ext := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(unsafeChars, r) {
+			return -1
+		}
+		return r
+	}, filepath.Ext(filename))
+
+	return base64.RawURLEncoding.EncodeToString(key) + ext
+}
+
+// Parse splits a slug into the file key and extension.
+func Parse(slug string) ([]byte, string, error) {
+	if len(slug) < Length {
+		return nil, "", ErrTooShort
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(slug[:Length])
+	if err != nil || len(key) != KeyLength {
+		return nil, "", ErrInvalidKey
+	}
+
+	return key, slug[Length:], nil
+}
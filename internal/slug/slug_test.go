@@ -0,0 +1,46 @@
+package slug
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFormatParseRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0xfb}, KeyLength)
+
+	slug := Format(key, "archive.tar.gz")
+	if len(slug) != Length+len(".gz") || !strings.HasSuffix(slug, ".gz") {
+		t.Fatalf("Format = %q", slug)
+	}
+
+	got, ext, err := Parse(slug)
+	if err != nil || !bytes.Equal(got, key) || ext != ".gz" {
+		t.Errorf("Parse(%q) = %x, %q, %v", slug, got, ext, err)
+	}
+}
+
+func TestFormatDropsUnsafeExtension(t *testing.T) {
+	key := make([]byte, KeyLength)
+	if slug := Format(key, "x.p<h>p"); slug[Length:] != ".php" {
+		t.Errorf("Format kept unsafe characters: %q", slug)
+	}
+	if slug := Format(key, "README"); len(slug) != Length {
+		t.Errorf("Format without extension = %q", slug)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	cases := map[string]error{
+		"short":                            ErrTooShort,
+		strings.Repeat("A", Length-1):      ErrTooShort,
+		strings.Repeat("!", Length):        ErrInvalidKey,
+		strings.Repeat("A", Length) + ".c": nil,
+	}
+	for slug, want := range cases {
+		if _, _, err := Parse(slug); !errors.Is(err, want) {
+			t.Errorf("Parse(%q) error = %v, want %v", slug, err, want)
+		}
+	}
+}
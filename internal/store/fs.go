@@ -0,0 +1,211 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const dirPerm = 0o700
+
+type FS struct {
+	root string
+}
+
+func NewFS(root string) *FS {
+	return &FS{root: root}
+}
+
+func (f *FS) path(name string) (string, error) {
+	if !validName(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return filepath.Join(f.root, filepath.FromSlash(name)), nil
+}
+
+func (f *FS) Put(name string) (Writer, error) {
+	dest, err := f.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), dirPerm); err != nil {
+		return nil, fmt.Errorf("create directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(dest), ".put-*")
+	if err != nil {
+		return nil, fmt.Errorf("create staging file: %w", err)
+	}
+
+	return &fsWriter{File: file, fs: f, dest: dest}, nil
+}
+
+type fsWriter struct {
+	*os.File
+	fs   *FS
+	dest string
+	done bool
+}
+
+func (w *fsWriter) Commit() error {
+	return w.commit(w.dest)
+}
+
+func (w *fsWriter) CommitAs(name string) error {
+	dest, err := w.fs.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), dirPerm); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	return w.commit(dest)
+}
+
+func (w *fsWriter) commit(dest string) error {
+	if w.done {
+		return ErrClosed
+	}
+	w.done = true
+
+	if err := w.File.Close(); err != nil {
+		_ = os.Remove(w.Name())
+		return fmt.Errorf("close staging file: %w", err)
+	}
+
+	if err := os.Rename(w.Name(), dest); err != nil {
+		_ = os.Remove(w.Name())
+		return fmt.Errorf("rename staging file: %w", err)
+	}
+
+	return nil
+}
+
+func (w *fsWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	_ = w.File.Close()
+	if err := os.Remove(w.Name()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove staging file: %w", err)
+	}
+
+	return nil
+}
+
+func (f *FS) Open(name string) (io.ReadSeekCloser, error) {
+	p, err := f.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotExist, name)
+	}
+
+	return file, err
+}
+
+func (f *FS) Stat(name string) (Info, error) {
+	p, err := f.path(name)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return Info{}, fmt.Errorf("%w: %s", ErrNotExist, name)
+	}
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Info{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (f *FS) Delete(name string) error {
+	p, err := f.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	// Empty directories are pruned up to, but not including, the top level:
+	// directories such as tmp belong to the layout and others write there
+	// without going through the store.
+	for dir := path.Dir(name); path.Dir(dir) != "."; dir = path.Dir(dir) {
+		if os.Remove(filepath.Join(f.root, filepath.FromSlash(dir))) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (f *FS) List(prefix string) ([]Info, error) {
+	if !validName(prefix + "x") {
+		return nil, fmt.Errorf("%w: prefix %q", ErrInvalidName, prefix)
+	}
+
+	start := f.root
+	if dir := path.Dir(prefix + "x"); dir != "." {
+		start = filepath.Join(f.root, filepath.FromSlash(dir))
+	}
+
+	var infos []Info
+	err := filepath.WalkDir(start, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if strings.HasPrefix(entry.Name(), ".") && p != start {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(f.root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		infos = append(infos, Info{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+
+	return infos, err
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errNegativeOffset = errors.New("negative offset")
+
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]memObject
+}
+
+type memObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string]memObject)}
+}
+
+func (m *Memory) Put(name string) (Writer, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return &memWriter{store: m, name: name}, nil
+}
+
+type memWriter struct {
+	store *Memory
+	name  string
+	buf   []byte
+	pos   int64
+	done  bool
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, ErrClosed
+	}
+
+	end := w.pos + int64(len(p))
+	if end > int64(len(w.buf)) {
+		w.buf = append(w.buf, make([]byte, end-int64(len(w.buf)))...)
+	}
+	copy(w.buf[w.pos:], p)
+	w.pos = end
+
+	return len(p), nil
+}
+
+func (w *memWriter) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = w.pos + offset
+	case io.SeekEnd:
+		abs = int64(len(w.buf)) + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+
+	if abs < 0 {
+		return 0, errNegativeOffset
+	}
+
+	w.pos = abs
+	return abs, nil
+}
+
+func (w *memWriter) Commit() error {
+	return w.CommitAs(w.name)
+}
+
+func (w *memWriter) CommitAs(name string) error {
+	if !validName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if w.done {
+		return ErrClosed
+	}
+	w.done = true
+
+	w.store.mu.Lock()
+	w.store.objects[name] = memObject{data: w.buf, modTime: time.Now()}
+	w.store.mu.Unlock()
+
+	return nil
+}
+
+func (w *memWriter) Abort() error {
+	w.done = true
+	w.buf = nil
+	return nil
+}
+
+type memReader struct {
+	*bytes.Reader
+}
+
+func (memReader) Close() error {
+	return nil
+}
+
+func (m *Memory) Open(name string) (io.ReadSeekCloser, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[name]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotExist, name)
+	}
+
+	return memReader{bytes.NewReader(obj.data)}, nil
+}
+
+func (m *Memory) Stat(name string) (Info, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[name]
+	m.mu.RUnlock()
+
+	if !ok {
+		return Info{}, fmt.Errorf("%w: %s", ErrNotExist, name)
+	}
+
+	return Info{Name: name, Size: int64(len(obj.data)), ModTime: obj.modTime}, nil
+}
+
+func (m *Memory) Delete(name string) error {
+	m.mu.Lock()
+	delete(m.objects, name)
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *Memory) List(prefix string) ([]Info, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var infos []Info
+	for name, obj := range m.objects {
+		if strings.HasPrefix(name, prefix) {
+			infos = append(infos, Info{Name: name, Size: int64(len(obj.data)), ModTime: obj.modTime})
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
@@ -0,0 +1,439 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Service      = "s3"
+	s3Algorithm    = "AWS4-HMAC-SHA256"
+	s3DateFormat   = "20060102T150405Z"
+	s3EmptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	TempDir   string
+}
+
+type S3 struct {
+	conf     S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+func NewS3(conf S3Config) (*S3, error) {
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", conf.Endpoint)
+	}
+	if conf.Bucket == "" {
+		return nil, errors.New("S3 bucket is required")
+	}
+	if conf.Region == "" {
+		conf.Region = "us-east-1"
+	}
+
+	return &S3{
+		conf:     conf,
+		endpoint: endpoint,
+		client:   &http.Client{},
+		now:      time.Now,
+	}, nil
+}
+
+func (s *S3) objectURL(name string, query url.Values) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.conf.Bucket
+	if name != "" {
+		u.Path += "/" + name
+	}
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (s *S3) do(method, name string, query url.Values, header http.Header, body io.ReadSeeker, size int64, payloadHash string) (*http.Response, error) {
+	request, err := http.NewRequest(method, s.objectURL(name, query).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		request.Header[k] = v
+	}
+
+	if body != nil {
+		request.Body = io.NopCloser(body)
+		request.ContentLength = size
+		request.GetBody = func() (io.ReadCloser, error) {
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(body), nil
+		}
+	}
+
+	s.sign(request, payloadHash)
+
+	resp, err := s.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: %w", method, name, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotExist, name)
+	}
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return resp, nil
+}
+
+func (s *S3) sign(request *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format(s3DateFormat)
+	day := amzDate[:8]
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := []string{"host"}
+	values := map[string]string{"host": request.URL.Host}
+	for k, v := range request.Header {
+		lower := strings.ToLower(k)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-type" {
+			names = append(names, lower)
+			values[lower] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		request.Method,
+		s3EscapePath(request.URL.Path),
+		s3CanonicalQuery(request.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.conf.Region + "/" + s3Service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	stringToSign := s3Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := s3HMAC([]byte("AWS4"+s.conf.SecretKey), day)
+	key = s3HMAC(key, s.conf.Region)
+	key = s3HMAC(key, s3Service)
+	key = s3HMAC(key, "aws4_request")
+	signature := hex.EncodeToString(s3HMAC(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.conf.AccessKey, scope, signedHeaders, signature))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return s3Escape(p, true)
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string{}, query[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func (s *S3) Put(name string) (Writer, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	spool, err := os.CreateTemp(s.conf.TempDir, "s3put-*")
+	if err != nil {
+		return nil, fmt.Errorf("create spool file: %w", err)
+	}
+
+	return &s3Writer{File: spool, store: s, name: name}, nil
+}
+
+type s3Writer struct {
+	*os.File
+	store *S3
+	name  string
+	done  bool
+}
+
+func (w *s3Writer) Commit() error {
+	return w.CommitAs(w.name)
+}
+
+func (w *s3Writer) CommitAs(name string) error {
+	if !validName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if w.done {
+		return ErrClosed
+	}
+	w.done = true
+	defer w.discard()
+
+	size, err := w.File.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("size spool file: %w", err)
+	}
+
+	if _, err := w.File.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind spool file: %w", err)
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, w.File); err != nil {
+		return fmt.Errorf("hash spool file: %w", err)
+	}
+	if _, err := w.File.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind spool file: %w", err)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := w.store.do(http.MethodPut, name, nil, header, w.File, size, hex.EncodeToString(hasher.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (w *s3Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.discard()
+	return nil
+}
+
+func (w *s3Writer) discard() {
+	_ = w.File.Close()
+	_ = os.Remove(w.File.Name())
+}
+
+func (s *S3) Open(name string) (io.ReadSeekCloser, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Reader{store: s, name: name, size: info.Size}, nil
+}
+
+type s3Reader struct {
+	store  *S3
+	name   string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		header := http.Header{}
+		header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+
+		resp, err := r.store.do(http.MethodGet, r.name, nil, header, nil, 0, s3EmptyPayload)
+		if err != nil {
+			return 0, err
+		}
+		r.body = resp.Body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if errors.Is(err, io.EOF) && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+
+	if abs < 0 {
+		return 0, errNegativeOffset
+	}
+
+	if abs != r.offset && r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+
+	r.offset = abs
+	return abs, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+func (s *S3) Stat(name string) (Info, error) {
+	if !validName(name) {
+		return Info{}, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	resp, err := s.do(http.MethodHead, name, nil, nil, nil, 0, s3EmptyPayload)
+	if err != nil {
+		return Info{}, err
+	}
+	_ = resp.Body.Close()
+
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return Info{}, fmt.Errorf("s3 HEAD %s: invalid Content-Length", name)
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return Info{Name: name, Size: size, ModTime: modTime}, nil
+}
+
+func (s *S3) Delete(name string) error {
+	if !validName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	resp, err := s.do(http.MethodDelete, name, nil, nil, nil, 0, s3EmptyPayload)
+	if errors.Is(err, ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+func (s *S3) List(prefix string) ([]Info, error) {
+	var infos []Info
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(http.MethodGet, "", query, nil, nil, 0, s3EmptyPayload)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode S3 listing: %w", err)
+		}
+
+		for _, obj := range result.Contents {
+			infos = append(infos, Info{Name: obj.Key, Size: obj.Size, ModTime: obj.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return infos, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
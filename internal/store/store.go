@@ -0,0 +1,57 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotExist    = errors.New("object does not exist")
+	ErrInvalidName = errors.New("invalid object name")
+	ErrClosed      = errors.New("writer already committed or aborted")
+)
+
+type Info struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Writer stages an object until Commit publishes it under the name given to
+// Put. CommitAs publishes it under another name instead, for objects whose
+// name depends on content that is only known once it has been written.
+type Writer interface {
+	io.WriteSeeker
+	Commit() error
+	CommitAs(name string) error
+	Abort() error
+}
+
+type Store interface {
+	Put(name string) (Writer, error)
+	Open(name string) (io.ReadSeekCloser, error)
+	Stat(name string) (Info, error)
+	Delete(name string) error
+	List(prefix string) ([]Info, error)
+}
+
+func validName(name string) bool {
+	if name == "" || name[0] == '/' || name[len(name)-1] == '/' {
+		return false
+	}
+
+	for i := 0; i < len(name); {
+		j := i
+		for j < len(name) && name[j] != '/' {
+			j++
+		}
+		segment := name[i:j]
+		if segment == "" || segment == "." || segment == ".." || segment[0] == '.' {
+			return false
+		}
+		i = j + 1
+	}
+
+	return true
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+
+	if _, err := s.Open("missing"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Open missing: want ErrNotExist, got %v", err)
+	}
+	if _, err := s.Stat("missing"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat missing: want ErrNotExist, got %v", err)
+	}
+	if _, err := s.Put("../escape"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Put traversal: want ErrInvalidName, got %v", err)
+	}
+
+	w, err := s.Put("tmp/upload/0")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := w.Write([]byte("XXXX body")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if _, err := s.Stat("tmp/upload/0"); !errors.Is(err, ErrNotExist) {
+		t.Error("Object visible before commit")
+	}
+
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	if _, err := w.Write([]byte("head")); err != nil {
+		t.Fatalf("Patch failed: %v", err)
+	}
+	if _, err := w.Seek(0, io.SeekEnd); err != nil {
+		t.Fatalf("Seek end failed: %v", err)
+	}
+	if _, err := w.Write([]byte("!")); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Errorf("Abort after commit should be a no-op, got %v", err)
+	}
+
+	info, err := s.Stat("tmp/upload/0")
+	if err != nil || info.Size != int64(len("head body!")) {
+		t.Fatalf("Stat after commit: %+v, %v", info, err)
+	}
+
+	r, err := s.Open("tmp/upload/0")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := r.Seek(5, io.SeekStart); err != nil {
+		t.Fatalf("Seek read failed: %v", err)
+	}
+	tail, err := io.ReadAll(r)
+	if err != nil || string(tail) != "body!" {
+		t.Errorf("Ranged read: got %q, %v", tail, err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Rewind failed: %v", err)
+	}
+	all, _ := io.ReadAll(r)
+	if !bytes.Equal(all, []byte("head body!")) {
+		t.Errorf("Full read: got %q", all)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+
+	aborted, err := s.Put("tmp/upload/1")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := aborted.Write([]byte("discard me")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := aborted.Abort(); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+
+	blob, err := s.Put("tmp/staging")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := blob.Write([]byte("blob")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := blob.CommitAs("blobid"); err != nil {
+		t.Fatalf("CommitAs failed: %v", err)
+	}
+	if err := blob.Commit(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Commit after CommitAs: want ErrClosed, got %v", err)
+	}
+	if info, err := s.Stat("blobid"); err != nil || info.Size != 4 {
+		t.Errorf("Stat renamed object: %+v, %v", info, err)
+	}
+
+	listed, err := s.List("tmp/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(listed) != 1 || listed[0].Name != "tmp/upload/0" {
+		t.Errorf("List tmp/: got %+v", listed)
+	}
+
+	if err := s.Delete("tmp/upload/0"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := s.Delete("tmp/upload/0"); err != nil {
+		t.Errorf("Repeated delete should succeed, got %v", err)
+	}
+	if _, err := s.Stat("tmp/upload/0"); !errors.Is(err, ErrNotExist) {
+		t.Error("Object still present after delete")
+	}
+
+	if listed, _ := s.List(""); len(listed) != 1 || listed[0].Name != "blobid" {
+		t.Errorf("List after delete: got %+v", listed)
+	}
+}
+
+func TestFS(t *testing.T) {
+	root := t.TempDir()
+	testStore(t, NewFS(root))
+
+	if _, err := os.Stat(root + "/tmp/upload"); !os.IsNotExist(err) {
+		t.Error("Delete left empty directories behind")
+	}
+	if info, err := os.Stat(root + "/tmp"); err != nil || !info.IsDir() {
+		t.Errorf("Delete removed the top-level directory: %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("SAFEBIN_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("SAFEBIN_TEST_S3_ENDPOINT not set")
+	}
+
+	s, err := NewS3(S3Config{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("SAFEBIN_TEST_S3_REGION"),
+		Bucket:    os.Getenv("SAFEBIN_TEST_S3_BUCKET"),
+		AccessKey: os.Getenv("SAFEBIN_TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("SAFEBIN_TEST_S3_SECRET_KEY"),
+		TempDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("NewS3 failed: %v", err)
+	}
+
+	testStore(t, s)
+}
@@ -2,87 +2,65 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"log/slog"
-	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strings"
 	"syscall"
 
-	"github.com/skidoodle/safebin/internal/app"
-	"github.com/skidoodle/safebin/web"
+	"github.com/skidoodle/safebin/internal/cli"
 )
 
-func main() {
-	cfg := app.LoadConfig()
-	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level:     slog.LevelDebug,
-		AddSource: true,
-	}))
+const usage = `Usage: safebin <command> [flags]
 
-	logger.Info("Initializing Safebin Server",
-		"storage_dir", cfg.StorageDir,
-		"max_file_size", fmt.Sprintf("%dMB", cfg.MaxMB),
-	)
+Commands:
+  serve              run the server (the default)
+  upload FILE...     upload files, "-" reads stdin
+  get URL [-o FILE]  download a file
+  rm URL             delete an upload with its token
+  admin COMMAND      inspect and repair the storage directory offline
 
-	tmpDir := filepath.Join(cfg.StorageDir, app.TempDirName)
-	if err := os.MkdirAll(tmpDir, app.PermUserRWX); err != nil {
-		logger.Error("Failed to initialize storage directory", "err", err)
-		os.Exit(1)
-	}
+Run "safebin <command> -help" for the flags of a command.
+`
 
-	db, err := app.InitDB(cfg.StorageDir)
-	if err != nil {
-		logger.Error("Failed to initialize database", "err", err)
-		os.Exit(1)
+func main() {
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			logger.Error("Failed to close database", "err", err)
-		}
-	}()
 
-	application := &app.App{
-		Conf:   cfg,
-		Logger: logger,
-		Tmpl:   app.ParseTemplates(web.Assets),
-		Assets: web.Assets,
-		DB:     db,
+	if command == "serve" {
+		os.Exit(serve(args))
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	go application.StartCleanupTask(ctx)
-
-	srv := &http.Server{
-		Addr:         cfg.Addr,
-		Handler:      application.Routes(),
-		ReadTimeout:  app.ServerTimeout,
-		WriteTimeout: app.ServerTimeout,
-		IdleTimeout:  app.ServerTimeout,
+	env := cli.Env{
+		Stdin:    os.Stdin,
+		Stdout:   os.Stdout,
+		Stderr:   os.Stderr,
+		Progress: cli.IsTerminal(os.Stderr),
 	}
 
-	go func() {
-		application.Logger.Info("Server is ready and listening", "addr", cfg.Addr)
-
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			application.Logger.Error("Server failed to start", "err", err)
-			os.Exit(1)
-		}
-	}()
-
-	<-ctx.Done()
-	application.Logger.Info("Shutting down gracefully...")
-
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
-	defer cancel()
-
-	if err := srv.Shutdown(shutdownCtx); err != nil {
-		application.Logger.Error("Forced shutdown", "err", err)
+	code := cli.ExitOK
+	switch command {
+	case "upload":
+		code = env.Upload(ctx, args)
+	case "get":
+		code = env.Get(ctx, args)
+	case "rm":
+		code = env.Remove(ctx, args)
+	case "admin":
+		code = env.Admin(ctx, args)
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "safebin: unknown command %q\n\n%s", command, usage)
+		code = cli.ExitUsage
 	}
 
-	application.Logger.Info("Server stopped")
+	stop()
+	os.Exit(code)
 }
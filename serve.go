@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/skidoodle/safebin/internal/app"
+	"github.com/skidoodle/safebin/internal/cli"
+	"github.com/skidoodle/safebin/web"
+)
+
+// serve runs the server until it is interrupted.
+func serve(args []string) int {
+	cfg, err := app.LoadConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return cli.ExitOK
+	}
+	if err != nil {
+		return cli.ExitUsage
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level:     slog.LevelDebug,
+		AddSource: true,
+	}))
+
+	if cfg.SecretFile != "" {
+		secret, err := app.LoadSecret(cfg.SecretFile)
+		if err != nil {
+			logger.Error("Failed to load convergence secret", "err", err)
+			return cli.ExitError
+		}
+		cfg.Secret = secret
+	}
+
+	if err := app.ValidateSecret(cfg.Secret); err != nil {
+		logger.Error("Invalid convergence secret", "err", err)
+		return cli.ExitError
+	}
+
+	logger.Info("Initializing Safebin Server",
+		"storage_dir", cfg.StorageDir,
+		"max_file_size", fmt.Sprintf("%dMB", cfg.MaxMB),
+		"keyed_convergence", len(cfg.Secret) > 0,
+		"s3_bucket", cfg.S3.Bucket,
+	)
+
+	tmpDir := filepath.Join(cfg.StorageDir, app.TempDirName)
+	if err := os.MkdirAll(tmpDir, app.PermUserRWX); err != nil {
+		logger.Error("Failed to initialize storage directory", "err", err)
+		return cli.ExitError
+	}
+
+	db, err := app.InitDB(cfg.StorageDir)
+	if err != nil {
+		logger.Error("Failed to initialize database", "err", err)
+		return cli.ExitError
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Error("Failed to close database", "err", err)
+		}
+	}()
+
+	blobs, err := app.NewStore(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize blob store", "err", err)
+		return cli.ExitError
+	}
+
+	application := &app.App{
+		Conf:   cfg,
+		Logger: logger,
+		Tmpl:   app.ParseTemplates(web.Assets),
+		Assets: web.Assets,
+		DB:     db,
+		Store:  blobs,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go application.StartCleanupTask(ctx)
+
+	srv := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      application.Routes(),
+		ReadTimeout:  app.ServerTimeout,
+		WriteTimeout: app.ServerTimeout,
+		IdleTimeout:  app.ServerTimeout,
+	}
+
+	go func() {
+		application.Logger.Info("Server is ready and listening", "addr", cfg.Addr)
+
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			application.Logger.Error("Server failed to start", "err", err)
+			os.Exit(cli.ExitError)
+		}
+	}()
+
+	<-ctx.Done()
+	application.Logger.Info("Shutting down gracefully...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		application.Logger.Error("Forced shutdown", "err", err)
+	}
+
+	application.Logger.Info("Server stopped")
+	return cli.ExitOK
+}
@@ -50,25 +50,41 @@ async function handleUpload(file) {
   $("busy-state").classList.remove("hidden");
   $("p-bar-container").classList.add("visible");
 
-  const uploadID = Array.from(window.crypto.getRandomValues(new Uint8Array(16)), (b) => b.toString(16).padStart(2, "0")).join("");
-  const chunkSize = 1024 * 1024 * 8;
-  const total = Math.ceil(file.size / chunkSize);
+  const chunkSize = E2E.chunkSize;
+  const e2e = $("e2e-toggle").checked;
+  const total = E2E.chunkCount(file.size);
 
   try {
-    for (let i = 0; i < total; i++) {
-      const fd = new FormData();
-      fd.append("upload_id", uploadID);
-      fd.append("index", i);
-      fd.append("chunk", file.slice(i * chunkSize, (i + 1) * chunkSize));
-      const res = await fetch("/upload/chunk", { method: "POST", body: fd });
-      if (!res.ok) throw new Error();
-      $("p-fill").style.width = ((i + 1) / total) * 100 + "%";
+    const instant = e2e ? null : await probeUpload(file);
+    if (instant) {
+      $("busy-state").classList.add("hidden");
+      $("result-state").classList.remove("hidden");
+      $("result-state").innerHTML = instant;
+      return;
     }
 
+    const session = await openSession(file, e2e, total);
+    const key = session.key;
+    let done = session.received.size;
+    $("p-fill").style.width = (done / total) * 100 + "%";
+
+    const pending = [];
+    for (let i = 0; i < total; i++) if (!session.received.has(i)) pending.push(i);
+
+    const worker = async () => {
+      while (pending.length) {
+        const i = pending.shift();
+        let chunk = file.slice(i * chunkSize, (i + 1) * chunkSize);
+        if (e2e) chunk = new Blob([await E2E.encryptChunk(key, i, i === total - 1, await chunk.arrayBuffer())]);
+
+        await sendChunk(session.id, i, chunk);
+        $("p-fill").style.width = (++done / total) * 100 + "%";
+      }
+    };
+    await Promise.all(Array.from({ length: UPLOAD_CONCURRENCY }, worker));
+
     const finalFd = new FormData();
-    finalFd.append("upload_id", uploadID);
-    finalFd.append("filename", file.name);
-    finalFd.append("total", total);
+    finalFd.append("upload_id", session.id);
 
     const res = await fetch("/upload/finish", {
       method: "POST",
@@ -76,9 +92,16 @@ async function handleUpload(file) {
       headers: { "X-Requested-With": "XMLHttpRequest" },
     });
 
+    if (res.status !== 409) localStorage.removeItem(resumeKey(file, e2e));
+
     $("busy-state").classList.add("hidden");
     $("result-state").classList.remove("hidden");
     $("result-state").innerHTML = await res.text();
+
+    if (e2e && res.ok) {
+      const fragment = new URLSearchParams({ key: session.exportedKey, name: file.name });
+      $("share-url").value += "#" + fragment.toString();
+    }
   } catch (e) {
     $("busy-state").classList.add("hidden");
     $("result-state").classList.remove("hidden");
@@ -92,6 +115,105 @@ async function handleUpload(file) {
   }
 }
 
+const UPLOAD_CONCURRENCY = 4;
+const CHUNK_ATTEMPTS = 4;
+
+async function sendChunk(id, index, chunk) {
+  const headers = {};
+  if (crypto.subtle) {
+    headers["X-Chunk-SHA256"] = hex(await crypto.subtle.digest("SHA-256", await chunk.arrayBuffer()));
+  }
+
+  for (let attempt = 1; ; attempt++) {
+    const fd = new FormData();
+    fd.append("upload_id", id);
+    fd.append("index", index);
+    fd.append("chunk", chunk);
+
+    let status = 0;
+    try {
+      const res = await fetch("/upload/chunk", { method: "POST", body: fd, headers });
+      if (res.ok) return;
+      status = res.status;
+    } catch (e) {}
+
+    const retryable = status === 0 || status === 460 || status >= 500;
+    if (!retryable || attempt >= CHUNK_ATTEMPTS) throw new Error("chunk " + index + " failed");
+    await new Promise((r) => setTimeout(r, 500 * 2 ** attempt));
+  }
+}
+
+const hex = (buf) => Array.from(new Uint8Array(buf), (b) => b.toString(16).padStart(2, "0")).join("");
+
+// probeUpload asks whether the server already stores this file and, if so,
+// proves possession by hashing the byte ranges it picks instead of uploading.
+async function probeUpload(file) {
+  if (!crypto.subtle) return null;
+
+  try {
+    const sha256 = hex(await crypto.subtle.digest("SHA-256", await file.arrayBuffer()));
+    const fd = new FormData();
+    fd.append("sha256", sha256);
+    fd.append("size", file.size);
+    fd.append("filename", file.name);
+
+    const res = await fetch("/upload/probe", { method: "POST", body: fd });
+    if (!res.ok) return null;
+    const probe = await res.json();
+    if (!probe.present) return null;
+
+    const parts = probe.ranges.map((r) => file.slice(r.offset, r.offset + r.length));
+    const proof = new FormData();
+    proof.append("sha256", sha256);
+    proof.append("proof", hex(await crypto.subtle.digest("SHA-256", await new Blob(parts).arrayBuffer())));
+
+    const done = await fetch("/upload/probe/" + probe.challenge, {
+      method: "POST",
+      body: proof,
+      headers: { "X-Requested-With": "XMLHttpRequest" },
+    });
+    return done.ok ? await done.text() : null;
+  } catch (e) {
+    return null;
+  }
+}
+
+function resumeKey(file, e2e) {
+  return ["safebin-upload", file.name, file.size, file.lastModified, e2e ? "e2e" : "plain"].join(":");
+}
+
+async function openSession(file, e2e, total) {
+  const saved = JSON.parse(localStorage.getItem(resumeKey(file, e2e)) || "null");
+  if (saved) {
+    const res = await fetch("/upload/" + saved.id);
+    if (res.ok) {
+      const status = await res.json();
+      return {
+        id: saved.id,
+        key: e2e ? await E2E.importKey(saved.key, ["encrypt"]) : null,
+        exportedKey: saved.key,
+        received: new Set(status.received.map((c) => c.index)),
+      };
+    }
+    localStorage.removeItem(resumeKey(file, e2e));
+  }
+
+  const key = e2e ? await E2E.generateKey() : null;
+  const fd = new FormData();
+  fd.append("filename", e2e ? "" : file.name);
+  fd.append("size", e2e ? file.size + total * (E2E.encChunkSize - E2E.chunkSize) : file.size);
+  fd.append("chunk_size", e2e ? E2E.encChunkSize : E2E.chunkSize);
+  if (e2e) fd.append("e2e", "1");
+
+  const res = await fetch("/upload/start", { method: "POST", body: fd });
+  if (!res.ok) throw new Error();
+  const status = await res.json();
+
+  const exportedKey = e2e ? await E2E.exportKey(key) : null;
+  localStorage.setItem(resumeKey(file, e2e), JSON.stringify({ id: status.id, key: exportedKey }));
+  return { id: status.id, key, exportedKey, received: new Set() };
+}
+
 function copyToClipboard(btn) {
   const input = $("share-url");
   input.select();
@@ -101,6 +223,16 @@ function copyToClipboard(btn) {
   setTimeout(() => (btn.innerText = "Copy"), 2000);
 }
 
+async function deleteFile(btn) {
+  const slug = $("share-url").value.split("#")[0].split("/").pop();
+  const res = await fetch("/" + slug, {
+    method: "DELETE",
+    headers: { "X-Delete-Token": $("delete-token").value },
+  });
+  btn.innerText = res.ok ? "Deleted" : "Failed";
+  btn.disabled = res.ok;
+}
+
 function resetUI() {
   location.reload();
 }
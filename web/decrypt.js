@@ -0,0 +1,70 @@
+const $ = (id) => document.getElementById(id);
+const params = new URLSearchParams(location.hash.slice(1));
+const rawURL = location.pathname + "?raw=1";
+const fileName = params.get("name") || "download";
+
+function fail(msg) {
+  $("busy-state").classList.add("hidden");
+  $("result-state").classList.remove("hidden");
+  $("result-state").innerHTML = `<div class="result-container"><div class="error-text"></div></div>`;
+  $("result-state").querySelector(".error-text").textContent = msg;
+}
+
+function activeWorker(reg) {
+  if (reg.active) return Promise.resolve(reg.active);
+  const sw = reg.installing || reg.waiting;
+  return new Promise((resolve) =>
+    sw.addEventListener("statechange", () => {
+      if (sw.state === "activated") resolve(sw);
+    }),
+  );
+}
+
+async function viaServiceWorker(keyParam) {
+  const reg = await navigator.serviceWorker.register("/static/sw.js", { scope: "/static/" });
+  const worker = await activeWorker(reg);
+  const id = Array.from(crypto.getRandomValues(new Uint8Array(16)), (b) => b.toString(16).padStart(2, "0")).join("");
+
+  await new Promise((resolve) => {
+    const channel = new MessageChannel();
+    channel.port1.onmessage = resolve;
+    worker.postMessage({ id, url: rawURL, key: keyParam, name: fileName }, [channel.port2]);
+  });
+
+  const frame = document.createElement("iframe");
+  frame.hidden = true;
+  frame.src = "/static/e2e-download/" + id;
+  document.body.appendChild(frame);
+}
+
+async function inMemory(keyParam) {
+  const res = await fetch(rawURL);
+  if (!res.ok) throw new Error("Download failed (" + res.status + ")");
+  const key = await E2E.importKey(keyParam);
+  const stream = E2E.decryptStream(key, res.body, parseInt(res.headers.get("Content-Length")));
+  const blob = await new Response(stream).blob();
+  const a = document.createElement("a");
+  a.href = URL.createObjectURL(blob);
+  a.download = fileName;
+  a.click();
+  setTimeout(() => URL.revokeObjectURL(a.href), 10000);
+}
+
+async function startDownload() {
+  const keyParam = params.get("key");
+  if (!keyParam) return fail("Missing decryption key in link");
+
+  $("idle-state").classList.add("hidden");
+  $("busy-state").classList.remove("hidden");
+
+  try {
+    if ("serviceWorker" in navigator) await viaServiceWorker(keyParam);
+    else await inMemory(keyParam);
+    $("status-msg").textContent = "Decrypting in your browser...";
+  } catch (e) {
+    fail("Decryption failed");
+  }
+}
+
+$("file-name").textContent = fileName;
+$("download-btn").onclick = startDownload;
@@ -0,0 +1,91 @@
+const E2E = (() => {
+  const chunkSize = 1024 * 1024 * 8;
+  const tagSize = 16;
+  const encChunkSize = chunkSize + tagSize;
+
+  const b64url = (bytes) =>
+    btoa(String.fromCharCode(...new Uint8Array(bytes)))
+      .replace(/\+/g, "-")
+      .replace(/\//g, "_")
+      .replace(/=+$/, "");
+
+  const unb64url = (str) =>
+    Uint8Array.from(atob(str.replace(/-/g, "+").replace(/_/g, "/")), (c) => c.charCodeAt(0));
+
+  function iv(index, final) {
+    const bytes = new Uint8Array(12);
+    bytes[0] = final ? 1 : 0;
+    new DataView(bytes.buffer).setBigUint64(4, BigInt(index));
+    return bytes;
+  }
+
+  async function generateKey() {
+    return crypto.subtle.generateKey({ name: "AES-GCM", length: 128 }, true, ["encrypt", "decrypt"]);
+  }
+
+  async function exportKey(key) {
+    return b64url(await crypto.subtle.exportKey("raw", key));
+  }
+
+  async function importKey(encoded, usages = ["decrypt"]) {
+    return crypto.subtle.importKey("raw", unb64url(encoded), "AES-GCM", false, usages);
+  }
+
+  async function encryptChunk(key, index, final, data) {
+    return crypto.subtle.encrypt({ name: "AES-GCM", iv: iv(index, final) }, key, data);
+  }
+
+  async function decryptChunk(key, index, final, data) {
+    return new Uint8Array(await crypto.subtle.decrypt({ name: "AES-GCM", iv: iv(index, final) }, key, data));
+  }
+
+  function chunkCount(plainSize) {
+    return Math.max(1, Math.ceil(plainSize / chunkSize));
+  }
+
+  function plainSize(encSize) {
+    const count = Math.max(1, Math.ceil(encSize / encChunkSize));
+    return encSize - count * tagSize;
+  }
+
+  function decryptStream(key, body, encSize) {
+    const count = Math.max(1, Math.ceil(encSize / encChunkSize));
+    let pieces = [];
+    let buffered = 0;
+    let index = 0;
+
+    const take = (n) => {
+      const out = new Uint8Array(n);
+      let filled = 0;
+      while (filled < n) {
+        const head = pieces[0];
+        const used = Math.min(head.length, n - filled);
+        out.set(head.subarray(0, used), filled);
+        filled += used;
+        if (used === head.length) pieces.shift();
+        else pieces[0] = head.subarray(used);
+      }
+      buffered -= n;
+      return out;
+    };
+
+    return body.pipeThrough(
+      new TransformStream({
+        async transform(piece, ctrl) {
+          pieces.push(piece);
+          buffered += piece.length;
+          while (index < count - 1 && buffered >= encChunkSize) {
+            ctrl.enqueue(await decryptChunk(key, index, false, take(encChunkSize)));
+            index++;
+          }
+        },
+        async flush(ctrl) {
+          if (index !== count - 1) throw new Error("truncated ciphertext");
+          ctrl.enqueue(await decryptChunk(key, index, true, take(buffered)));
+        },
+      }),
+    );
+  }
+
+  return { chunkSize, encChunkSize, chunkCount, plainSize, generateKey, exportKey, importKey, encryptChunk, decryptStream };
+})();
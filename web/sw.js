@@ -0,0 +1,38 @@
+importScripts("e2e.js");
+
+const pending = new Map();
+
+self.addEventListener("install", () => self.skipWaiting());
+self.addEventListener("activate", (e) => e.waitUntil(self.clients.claim()));
+
+self.addEventListener("message", (e) => {
+  const { id, url, key, name } = e.data;
+  pending.set(id, { url, key, name });
+  e.ports[0].postMessage({ ok: true });
+});
+
+self.addEventListener("fetch", (e) => {
+  const path = new URL(e.request.url).pathname;
+  const prefix = "/static/e2e-download/";
+  if (!path.startsWith(prefix)) return;
+
+  const job = pending.get(path.slice(prefix.length));
+  if (!job) return;
+  pending.delete(path.slice(prefix.length));
+
+  e.respondWith(
+    (async () => {
+      const res = await fetch(job.url);
+      if (!res.ok) return new Response("Download failed", { status: res.status });
+      const encSize = parseInt(res.headers.get("Content-Length"));
+      const key = await E2E.importKey(job.key);
+      return new Response(E2E.decryptStream(key, res.body, encSize), {
+        headers: {
+          "Content-Type": "application/octet-stream",
+          "Content-Length": String(E2E.plainSize(encSize)),
+          "Content-Disposition": "attachment; filename*=UTF-8''" + encodeURIComponent(job.name),
+        },
+      });
+    })(),
+  );
+});